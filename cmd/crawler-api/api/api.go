@@ -0,0 +1,248 @@
+// Package api serves uptime stats, records and intervals over HTTP as JSON
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+const (
+	defaultRange = 24 * time.Hour
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Server is a HTTP handler serving the following endpoints:
+//
+//	/api/v1/stats      aggregated uptime stats
+//	/api/v1/records    raw check records
+//	/api/v1/intervals  uptime/downtime interval timelines
+//
+// All of them accept the following query parameters:
+//
+//	from, to       time range in RFC3339 or unix seconds, last 24 hours by default
+//	url            URL filter, may be repeated
+//	source         source IP or proxy filter, may be repeated
+//	limit, offset  pagination, 100 items per page by default
+type Server struct {
+	d   db.RecordGetter
+	mux *http.ServeMux
+}
+
+// New creates API server reading records from d
+func New(d db.RecordGetter) *Server {
+	s := &Server{d: d, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/api/v1/stats", s.handleStats)
+	s.mux.HandleFunc("/api/v1/records", s.handleRecords)
+	s.mux.HandleFunc("/api/v1/intervals", s.handleIntervals)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// query is a parsed request filter
+type query struct {
+	from, to time.Time
+	urls     []string
+	sources  map[string]bool
+	limit    int
+	offset   int
+}
+
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseInt(s string, def int) (int, error) {
+	if len(s) == 0 {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("should be non-negative integer")
+	}
+	return v, nil
+}
+
+func parseQuery(r *http.Request) (*query, error) {
+	var (
+		v   = r.URL.Query()
+		q   = &query{to: time.Now().UTC(), urls: v["url"]}
+		err error
+	)
+
+	if s := v.Get("to"); len(s) > 0 {
+		if q.to, err = parseTime(s); err != nil {
+			return nil, fmt.Errorf("invalid to: %s", err)
+		}
+	}
+	q.from = q.to.Add(-defaultRange)
+	if s := v.Get("from"); len(s) > 0 {
+		if q.from, err = parseTime(s); err != nil {
+			return nil, fmt.Errorf("invalid from: %s", err)
+		}
+	}
+	if q.from.After(q.to) {
+		return nil, fmt.Errorf("from is after to")
+	}
+
+	if len(v["source"]) > 0 {
+		q.sources = map[string]bool{}
+		for _, src := range v["source"] {
+			q.sources[src] = true
+		}
+	}
+
+	if q.limit, err = parseInt(v.Get("limit"), defaultLimit); err != nil {
+		return nil, fmt.Errorf("invalid limit: %s", err)
+	}
+	if q.limit == 0 || q.limit > maxLimit {
+		q.limit = maxLimit
+	}
+	if q.offset, err = parseInt(v.Get("offset"), 0); err != nil {
+		return nil, fmt.Errorf("invalid offset: %s", err)
+	}
+
+	return q, nil
+}
+
+// page returns [start, end) bounds of requested page within n items
+func (q *query) page(n int) (int, int) {
+	start := q.offset
+	if start > n {
+		start = n
+	}
+	end := start + q.limit
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+// stream streams records matching query ordered by url, source and time
+func (s *Server) stream(ctx context.Context, q *query, fn func(r *db.Record)) error {
+	return db.StreamRecords(ctx, s.d, q.from, q.to, q.urls, func(r *db.Record) error {
+		if q.sources == nil || q.sources[r.LocalIP] {
+			fn(r)
+		}
+		return nil
+	})
+}
+
+// page is a paginated response
+type page struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Items  interface{} `json:"items"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error: Failed to write response: %s\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// serve handles request common part: query parsing and pagination. items
+// returns all the response items and their total number, items outside of
+// the page may be omitted.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request,
+	items func(ctx context.Context, q *query) ([]interface{}, int, error)) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	q, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	all, total, err := items(r.Context(), q)
+	if err != nil {
+		log.Printf("Error: Failed to get records: %s\n", err)
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to get records"))
+		return
+	}
+
+	if len(all) == total {
+		start, end := q.page(total)
+		all = all[start:end]
+	}
+	writeJSON(w, http.StatusOK, page{Total: total, Offset: q.offset, Limit: q.limit, Items: all})
+}
+
+// timelines aggregates records matching query
+func (s *Server) timelines(ctx context.Context, q *query, keepIntervals bool) ([]stat.Timeline, error) {
+	a := stat.NewAccumulator(keepIntervals)
+	if err := s.stream(ctx, q, a.Add); err != nil {
+		return nil, err
+	}
+	return a.Timelines(), nil
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, func(ctx context.Context, q *query) ([]interface{}, int, error) {
+		timelines, err := s.timelines(ctx, q, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		res := make([]interface{}, len(timelines))
+		for i := range timelines {
+			st := timelines[i].Stat()
+			res[i] = newStatView(&st)
+		}
+		return res, len(res), nil
+	})
+}
+
+// handleRecords streams records keeping only the requested page in memory
+func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, func(ctx context.Context, q *query) ([]interface{}, int, error) {
+		var (
+			res   = []interface{}{}
+			total int
+		)
+		err := s.stream(ctx, q, func(r *db.Record) {
+			if total >= q.offset && total < q.offset+q.limit {
+				res = append(res, newRecordView(r))
+			}
+			total++
+		})
+		return res, total, err
+	})
+}
+
+func (s *Server) handleIntervals(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, func(ctx context.Context, q *query) ([]interface{}, int, error) {
+		timelines, err := s.timelines(ctx, q, true)
+		if err != nil {
+			return nil, 0, err
+		}
+		res := make([]interface{}, len(timelines))
+		for i := range timelines {
+			res[i] = newTimelineView(&timelines[i])
+		}
+		return res, len(res), nil
+	})
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+	"github.com/bpiddubnyi/crawler/db/memory"
+)
+
+func testServer() *Server {
+	var (
+		d     = memory.New()
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	for i := 0; i < 10; i++ {
+		t := start.Add(time.Duration(i) * time.Minute)
+		d.Add(
+			db.Record{URL: "http://a", LocalIP: "10.0.0.1", Time: t, Up: i < 5 || i > 7},
+			db.Record{URL: "http://a", LocalIP: "10.0.0.2", Time: t, Up: true},
+			db.Record{URL: "http://b", LocalIP: "10.0.0.1", Time: t, Up: true},
+		)
+	}
+	return New(d)
+}
+
+func get(t *testing.T, s *Server, url string, v interface{}) int {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("Failed to decode %s response: %s", url, err)
+		}
+	}
+	return w.Code
+}
+
+const window = "from=2020-01-01T00:00:00Z&to=2020-01-02T00:00:00Z"
+
+func TestRecords(t *testing.T) {
+	s := testServer()
+
+	var resp struct {
+		Total int          `json:"total"`
+		Items []recordView `json:"items"`
+	}
+	if code := get(t, s, "/api/v1/records?"+window+"&url=http://a&source=10.0.0.1&limit=3&offset=8", &resp); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if resp.Total != 10 || len(resp.Items) != 2 {
+		t.Fatalf("total = %d, items = %d, want 10 and 2", resp.Total, len(resp.Items))
+	}
+	if it := resp.Items[0]; it.URL != "http://a" || it.Source != "10.0.0.1" || !it.Up {
+		t.Errorf("unexpected record %+v", it)
+	}
+}
+
+func TestStatsAndIntervals(t *testing.T) {
+	s := testServer()
+
+	var stats struct {
+		Total int        `json:"total"`
+		Items []statView `json:"items"`
+	}
+	if code := get(t, s, "/api/v1/stats?"+window+"&url=http://a&source=10.0.0.1", &stats); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if stats.Total != 1 {
+		t.Fatalf("total = %d, want 1", stats.Total)
+	}
+	st := stats.Items[0]
+	if st.WholeTime != 540 || st.UpTime != 360 || st.LongestDown == nil || st.LongestDown.Duration != 180 {
+		t.Errorf("unexpected stat %+v", st)
+	}
+
+	var intervals struct {
+		Total int            `json:"total"`
+		Items []timelineView `json:"items"`
+	}
+	if code := get(t, s, "/api/v1/intervals?"+window, &intervals); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if intervals.Total != 3 || len(intervals.Items[0].Intervals) != 3 {
+		t.Errorf("unexpected intervals %+v", intervals)
+	}
+}
+
+func TestBadRequest(t *testing.T) {
+	s := testServer()
+	for _, url := range []string{
+		"/api/v1/stats?from=yesterday",
+		"/api/v1/records?from=2020-01-02T00:00:00Z&to=2020-01-01T00:00:00Z",
+		"/api/v1/intervals?limit=-1",
+	} {
+		if code := get(t, s, url, nil); code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want %d", url, code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// JSON representations of records and stats, durations are in seconds
+
+type recordView struct {
+	URL         string       `json:"url"`
+	Time        time.Time    `json:"time"`
+	Source      string       `json:"source"`
+	Up          bool         `json:"up"`
+	StatusCode  int          `json:"status_code,omitempty"`
+	Duration    float64      `json:"duration"`
+	DNS         float64      `json:"dns,omitempty"`
+	Connect     float64      `json:"connect,omitempty"`
+	TLS         float64      `json:"tls,omitempty"`
+	TTFB        float64      `json:"ttfb,omitempty"`
+	Size        int64        `json:"size,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Assertion   string       `json:"assertion,omitempty"`
+	Cert        *certView    `json:"cert,omitempty"`
+	ContentHash string       `json:"content_hash,omitempty"`
+	Content     *contentView `json:"content,omitempty"`
+	Confirmed   bool         `json:"confirmed,omitempty"`
+}
+
+type certView struct {
+	Expiry     time.Time `json:"expiry"`
+	DaysLeft   int       `json:"days_left"`
+	Issuer     string    `json:"issuer"`
+	SANs       []string  `json:"sans"`
+	ChainValid bool      `json:"chain_valid"`
+	HostValid  bool      `json:"host_valid"`
+}
+
+type contentView struct {
+	Change   string `json:"change"`
+	Previous string `json:"previous"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+}
+
+func newRecordView(r *db.Record) *recordView {
+	v := &recordView{
+		URL:         r.URL,
+		Time:        r.Time,
+		Source:      r.LocalIP,
+		Up:          r.Up,
+		StatusCode:  r.StatusCode,
+		Duration:    r.Duration.Seconds(),
+		DNS:         r.DNS.Seconds(),
+		Connect:     r.Connect.Seconds(),
+		TLS:         r.TLS.Seconds(),
+		TTFB:        r.TTFB.Seconds(),
+		Size:        r.Size,
+		Error:       r.Error,
+		Assertion:   r.Assertion,
+		ContentHash: r.ContentHash,
+		Confirmed:   r.Confirmed,
+	}
+	if c := r.Cert; c != nil {
+		v.Cert = &certView{Expiry: c.Expiry, DaysLeft: c.DaysLeft(time.Now()), Issuer: c.Issuer,
+			SANs: c.SANs, ChainValid: c.ChainValid, HostValid: c.HostValid}
+	}
+	if c := r.Content; c != nil {
+		v.Content = &contentView{Change: c.Kind, Previous: c.Previous, Before: c.Before, After: c.After}
+	}
+	return v
+}
+
+type intervalView struct {
+	Up       bool      `json:"up"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Duration float64   `json:"duration"`
+}
+
+func newIntervalView(i *stat.Interval) *intervalView {
+	return &intervalView{Up: i.Up, From: i.From, To: i.To, Duration: i.Duration().Seconds()}
+}
+
+type statView struct {
+	URL           string         `json:"url"`
+	Source        string         `json:"source"`
+	WholeTime     float64        `json:"whole_time"`
+	UpTime        float64        `json:"uptime"`
+	UptimePercent float64        `json:"uptime_percent"`
+	LongestDown   *intervalView  `json:"longest_down"`
+	Failures      map[string]int `json:"failures,omitempty"`
+}
+
+func newStatView(s *stat.Stat) *statView {
+	v := &statView{
+		URL:       s.URL,
+		Source:    s.LocalIP,
+		WholeTime: s.WholeTime.Seconds(),
+		UpTime:    s.UpTime.Seconds(),
+		Failures:  s.Failures,
+	}
+	if s.WholeTime > 0 {
+		v.UptimePercent = float64(s.UpTime*100) / float64(s.WholeTime)
+	}
+	if s.LongestDown != nil {
+		v.LongestDown = newIntervalView(s.LongestDown)
+	}
+	return v
+}
+
+type timelineView struct {
+	URL       string          `json:"url"`
+	Source    string          `json:"source"`
+	Intervals []*intervalView `json:"intervals"`
+}
+
+func newTimelineView(t *stat.Timeline) *timelineView {
+	v := &timelineView{URL: t.URL, Source: t.LocalIP, Intervals: make([]*intervalView, len(t.Intervals))}
+	for i := range t.Intervals {
+		v.Intervals[i] = newIntervalView(&t.Intervals[i])
+	}
+	return v
+}
@@ -0,0 +1,125 @@
+package status
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// schemeRe matches URL scheme prefix, the same way crawler config does it
+var schemeRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+const (
+	defaultDays    = 90
+	defaultRefresh = time.Minute
+)
+
+// Config is a status page configuration, only targets listed in groups are
+// shown on the page
+type Config struct {
+	Title   string
+	Days    int           // number of days uptime bars are shown for
+	Refresh time.Duration // page is rebuilt from db at most once per refresh
+	Groups  []Group
+}
+
+// Group is a named list of targets shown together
+type Group struct {
+	Name    string   `yaml:"name"`
+	Targets []Target `yaml:"targets"`
+}
+
+// Target is a public target, Name is shown instead of URL if set
+type Target struct {
+	URL  string `yaml:"url"`
+	Name string `yaml:"name"`
+}
+
+// UnmarshalYAML allows target to be written as a plain URL
+func (t *Target) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		t.URL = n.Value
+		return nil
+	}
+	type plain Target
+	return n.Decode((*plain)(t))
+}
+
+type configSpec struct {
+	Title   string  `yaml:"title"`
+	Days    int     `yaml:"days"`
+	Refresh string  `yaml:"refresh"`
+	Groups  []Group `yaml:"groups"`
+}
+
+// ParseConfig parses YAML or JSON status page config:
+//
+//	title: Example Inc. status
+//	days: 90       # 90 by default
+//	refresh: 1m    # 1m by default
+//	groups:
+//	  - name: Website
+//	    targets:
+//	      - url: https://example.com
+//	        name: Homepage
+//	      - https://example.com/blog
+func ParseConfig(r io.Reader) (*Config, error) {
+	var spec configSpec
+
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Title:   spec.Title,
+		Days:    spec.Days,
+		Refresh: defaultRefresh,
+		Groups:  spec.Groups,
+	}
+	if len(cfg.Title) == 0 {
+		cfg.Title = "Status"
+	}
+	if cfg.Days == 0 {
+		cfg.Days = defaultDays
+	}
+	if cfg.Days < 0 {
+		return nil, fmt.Errorf("days should be positive")
+	}
+
+	if len(spec.Refresh) > 0 {
+		var err error
+		if cfg.Refresh, err = time.ParseDuration(spec.Refresh); err != nil || cfg.Refresh < 0 {
+			return nil, fmt.Errorf("invalid refresh interval %q", spec.Refresh)
+		}
+	}
+
+	if len(cfg.Groups) == 0 {
+		return nil, fmt.Errorf("no groups configured")
+	}
+	for i := range cfg.Groups {
+		g := &cfg.Groups[i]
+		if len(g.Targets) == 0 {
+			return nil, fmt.Errorf("group %q has no targets", g.Name)
+		}
+		for j := range g.Targets {
+			t := &g.Targets[j]
+			if len(t.URL) == 0 {
+				return nil, fmt.Errorf("group %q has target with empty url", g.Name)
+			}
+			// URLs are normalized the same way crawler config does it
+			if !schemeRe.MatchString(t.URL) {
+				t.URL = "http://" + t.URL
+			}
+			if len(t.Name) == 0 {
+				t.Name = t.URL
+			}
+		}
+	}
+
+	return cfg, nil
+}
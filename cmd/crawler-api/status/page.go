@@ -0,0 +1,282 @@
+package status
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+const (
+	day            = 24 * time.Hour
+	maxIncidents   = 20
+	sparkPoints    = 24 // hourly average latency for the last day
+	sparkWidth     = 120
+	sparkHeight    = 24
+	stateUp        = "up"
+	stateDown      = "down"
+	stateUnknown   = "unknown"
+	uptimeGood     = 99.9
+	uptimeDegraded = 99
+)
+
+// stateRank orders target states by severity, page state is the most severe
+// one of its targets
+var stateRank = map[string]int{stateUp: 0, stateUnknown: 1, stateDown: 2}
+
+type dayView struct {
+	Date    time.Time
+	Uptime  float64
+	HasData bool
+}
+
+// Class returns bar CSS class
+func (d dayView) Class() string {
+	switch {
+	case !d.HasData:
+		return "none"
+	case d.Uptime >= uptimeGood:
+		return "good"
+	case d.Uptime >= uptimeDegraded:
+		return "degraded"
+	default:
+		return "bad"
+	}
+}
+
+type incidentView struct {
+	Target   string
+	From, To time.Time
+	Reason   string
+	url      string
+}
+
+func (i incidentView) Duration() time.Duration {
+	return i.To.Sub(i.From)
+}
+
+type targetView struct {
+	Name      string
+	State     string
+	Uptime    float64 // whole period uptime percent, negative if there's no data
+	Days      []dayView
+	Latency   time.Duration // average latency for the last day
+	Sparkline string        // SVG polyline points
+}
+
+type groupView struct {
+	Name    string
+	Targets []targetView
+}
+
+type pageView struct {
+	Title     string
+	Updated   time.Time
+	State     string // overall state: down if any target is down, up if all targets are up
+	Days      int
+	Groups    []groupView
+	Incidents []incidentView
+}
+
+// buildPage builds status page from uptime timelines of the last cfg.Days
+// days and records of the last day ordered by url, local_ip and time.
+// Reasons are only set for incidents that started within the last day.
+func buildPage(cfg *Config, tls []stat.Timeline, recent []db.Record, now time.Time) *pageView {
+	var (
+		tlsByURL  = map[string][]stat.Timeline{}
+		recsByURL = map[string][]db.Record{}
+		p         = &pageView{Title: cfg.Title, Updated: now, State: stateUp, Days: cfg.Days}
+	)
+	for _, tl := range tls {
+		tlsByURL[tl.URL] = append(tlsByURL[tl.URL], tl)
+	}
+	for _, r := range recent {
+		recsByURL[r.URL] = append(recsByURL[r.URL], r)
+	}
+
+	for _, g := range cfg.Groups {
+		gv := groupView{Name: g.Name}
+		for _, t := range g.Targets {
+			tv, incidents := buildTarget(cfg, &t, tlsByURL[t.URL], recsByURL[t.URL], now)
+			if stateRank[tv.State] > stateRank[p.State] {
+				p.State = tv.State
+			}
+			gv.Targets = append(gv.Targets, tv)
+			p.Incidents = append(p.Incidents, incidents...)
+		}
+		p.Groups = append(p.Groups, gv)
+	}
+
+	sort.Slice(p.Incidents, func(i, j int) bool { return p.Incidents[i].From.After(p.Incidents[j].From) })
+	if len(p.Incidents) > maxIncidents {
+		p.Incidents = p.Incidents[:maxIncidents]
+	}
+	return p
+}
+
+// reason returns failed check reason: failed assertion description or error
+// class
+func reason(r *db.Record) string {
+	if len(r.Assertion) > 0 {
+		return r.Assertion
+	}
+	return r.Error
+}
+
+// buildTarget builds target view and its incidents from target timelines and
+// records of the last day, target state is unknown if it wasn't checked
+// during the last day
+func buildTarget(cfg *Config, t *Target, tls []stat.Timeline, recent []db.Record,
+	now time.Time) (targetView, []incidentView) {
+	var (
+		tv    = targetView{Name: t.Name, State: stateUnknown, Uptime: -1, Days: make([]dayView, cfg.Days)}
+		start = now.UTC().Truncate(day).Add(-time.Duration(cfg.Days-1) * day)
+		up    = make([]time.Duration, cfg.Days)
+		whole = make([]time.Duration, cfg.Days)
+		last  *db.Record
+	)
+	for i := range tv.Days {
+		tv.Days[i].Date = start.Add(time.Duration(i) * day)
+	}
+
+	reasons := map[string]string{}
+	for i, r := range recent {
+		if last == nil || r.Time.After(last.Time) {
+			last = &recent[i]
+		}
+		if !r.Up {
+			reasons[r.LocalIP+" "+r.Time.String()] = reason(&r)
+		}
+	}
+	if last != nil {
+		tv.State = stateDown
+		if last.Up {
+			tv.State = stateUp
+		}
+	}
+
+	var downs []incidentView
+	for _, tl := range tls {
+		for _, iv := range tl.Intervals {
+			addInterval(start, &iv, up, whole)
+			if !iv.Up {
+				downs = append(downs, incidentView{Target: t.Name, From: iv.From, To: iv.To,
+					Reason: reasons[tl.LocalIP+" "+iv.From.String()], url: t.URL})
+			}
+		}
+	}
+
+	var totalUp, total time.Duration
+	for i := range tv.Days {
+		if whole[i] > 0 {
+			tv.Days[i].HasData = true
+			tv.Days[i].Uptime = float64(up[i]*100) / float64(whole[i])
+		}
+		totalUp += up[i]
+		total += whole[i]
+	}
+	if total > 0 {
+		tv.Uptime = float64(totalUp*100) / float64(total)
+	}
+
+	tv.Latency, tv.Sparkline = sparkline(recent, now)
+	return tv, mergeIncidents(downs)
+}
+
+// addInterval adds interval duration to days it overlaps
+func addInterval(start time.Time, iv *stat.Interval, up, whole []time.Duration) {
+	for i := range whole {
+		from, to := start.Add(time.Duration(i)*day), start.Add(time.Duration(i+1)*day)
+		if iv.From.After(from) {
+			from = iv.From
+		}
+		if iv.To.Before(to) {
+			to = iv.To
+		}
+		if d := to.Sub(from); d > 0 {
+			whole[i] += d
+			if iv.Up {
+				up[i] += d
+			}
+		}
+	}
+}
+
+// mergeIncidents merges overlapping downtime intervals seen from different
+// sources
+func mergeIncidents(downs []incidentView) []incidentView {
+	if len(downs) == 0 {
+		return nil
+	}
+	sort.Slice(downs, func(i, j int) bool { return downs[i].From.Before(downs[j].From) })
+
+	res := []incidentView{downs[0]}
+	for _, d := range downs[1:] {
+		cur := &res[len(res)-1]
+		if d.From.After(cur.To) {
+			res = append(res, d)
+			continue
+		}
+		if d.To.After(cur.To) {
+			cur.To = d.To
+		}
+		if len(cur.Reason) == 0 {
+			cur.Reason = d.Reason
+		}
+	}
+	return res
+}
+
+// sparkline returns average latency of successful checks for the last day and
+// SVG polyline points of its hourly averages. Failures that weren't confirmed
+// are recorded as up, their latency isn't counted.
+func sparkline(recs []db.Record, now time.Time) (time.Duration, string) {
+	var (
+		start  = now.Add(-day)
+		step   = day / sparkPoints
+		sums   = make([]time.Duration, sparkPoints)
+		counts = make([]int, sparkPoints)
+		total  time.Duration
+		n      int
+	)
+	for _, r := range recs {
+		if !r.Up || len(r.Error) > 0 || r.Time.Before(start) || !r.Time.Before(now) {
+			continue
+		}
+		i := int(r.Time.Sub(start) / step)
+		sums[i] += r.Duration
+		counts[i]++
+		total += r.Duration
+		n++
+	}
+	if n == 0 {
+		return 0, ""
+	}
+
+	var max time.Duration
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= time.Duration(counts[i])
+			if sums[i] > max {
+				max = sums[i]
+			}
+		}
+	}
+
+	points := make([]string, 0, sparkPoints)
+	for i := range sums {
+		if counts[i] == 0 {
+			continue
+		}
+		y := float64(sparkHeight)
+		if max > 0 {
+			y -= float64(sums[i]) / float64(max) * (sparkHeight - 2)
+		}
+		x := float64(i) * sparkWidth / (sparkPoints - 1)
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y-1))
+	}
+	return total / time.Duration(n), strings.Join(points, " ")
+}
@@ -0,0 +1,127 @@
+// Package status implements public HTML status page
+package status
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// Page is a HTTP handler serving status page at the root path
+type Page struct {
+	cfg  *Config
+	d    db.RecordGetter
+	urls []string
+	now  func() time.Time
+
+	mu    sync.Mutex
+	html  []byte
+	built time.Time
+}
+
+// New creates status page reading records from d
+func New(cfg *Config, d db.RecordGetter) *Page {
+	p := &Page{cfg: cfg, d: d, now: time.Now}
+	seen := map[string]bool{}
+	for _, g := range cfg.Groups {
+		for _, t := range g.Targets {
+			if !seen[t.URL] {
+				seen[t.URL] = true
+				p.urls = append(p.urls, t.URL)
+			}
+		}
+	}
+	return p
+}
+
+// render returns cached page or builds a new one if it's outdated
+func (p *Page) render() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if p.html != nil && now.Sub(p.built) < p.cfg.Refresh {
+		return p.html, nil
+	}
+
+	from := now.UTC().Truncate(day).Add(-time.Duration(p.cfg.Days-1) * day)
+	tls, err := p.timelines(from, now)
+	if err != nil {
+		return nil, err
+	}
+	recent, err := p.d.GetRecords(now.Add(-day), now, p.urls...)
+	if err != nil {
+		return nil, err
+	}
+
+	pv := buildPage(p.cfg, tls, recent, now)
+	if err = p.addReasons(pv.Incidents); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err = pageTemplate.Execute(buf, pv); err != nil {
+		return nil, err
+	}
+	p.html, p.built = buf.Bytes(), now
+	return p.html, nil
+}
+
+// timelines returns uptime timelines of page targets in [from, to] time range.
+// They are built from intervals aggregated by db if it supports aggregation,
+// otherwise records are streamed, so they aren't loaded into memory at once.
+func (p *Page) timelines(from, to time.Time) ([]stat.Timeline, error) {
+	if a, ok := p.d.(db.Aggregator); ok {
+		ivs, err := a.GetIntervals(from, to, p.urls...)
+		if err != nil {
+			return nil, err
+		}
+		return stat.FromIntervals(ivs), nil
+	}
+	return stat.Stream(context.Background(), p.d, from, to, p.urls, true)
+}
+
+// addReasons sets reasons of incidents that started before the last day to
+// the reason of their first failed check
+func (p *Page) addReasons(incidents []incidentView) error {
+	for i := range incidents {
+		in := &incidents[i]
+		if len(in.Reason) > 0 {
+			continue
+		}
+		recs, err := p.d.GetRecords(in.From, in.From, in.url)
+		if err != nil {
+			return err
+		}
+		for k := range recs {
+			if !recs[k].Up {
+				in.Reason = reason(&recs[k])
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Page) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	html, err := p.render()
+	if err != nil {
+		log.Printf("Error: Failed to build status page: %s\n", err)
+		http.Error(w, "Status page is temporarily unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(html)
+}
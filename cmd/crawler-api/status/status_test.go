@@ -0,0 +1,171 @@
+package status
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+	"github.com/bpiddubnyi/crawler/db/memory"
+)
+
+const testConfig = `title: Example status
+days: 3
+groups:
+  - name: Website
+    targets:
+      - url: https://example.com
+        name: Homepage
+      - example.org
+      - example.com/r?u=http://x
+`
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %s", err)
+	}
+	if cfg.Days != 3 || cfg.Refresh != defaultRefresh || len(cfg.Groups) != 1 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	targets := cfg.Groups[0].Targets
+	if len(targets) != 3 || targets[0].Name != "Homepage" || targets[1].URL != "http://example.org" ||
+		targets[1].Name != "http://example.org" || targets[2].URL != "http://example.com/r?u=http://x" {
+		t.Errorf("unexpected targets %+v", targets)
+	}
+
+	for _, bad := range []string{"", "groups: [{name: empty}]", "days: -1\ngroups: [{targets: [a]}]", "secret: true"} {
+		if _, err := ParseConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseConfig(%q) error is nil", bad)
+		}
+	}
+}
+
+func TestBuildPage(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %s", err)
+	}
+
+	var (
+		now   = time.Date(2020, 1, 3, 12, 0, 0, 0, time.UTC)
+		start = time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+		recs  []db.Record
+	)
+	for _, src := range []string{"10.0.0.1", "10.0.0.2"} {
+		for i := 0; i < 36; i++ {
+			// down from 06:00 till 09:00 on January 2 from both sources
+			up := i < 6 || i >= 9
+			r := db.Record{URL: "https://example.com", LocalIP: src, Time: start.Add(time.Duration(i) * time.Hour),
+				Up: up, Duration: 100 * time.Millisecond}
+			if !up {
+				r.Error = "timeout"
+			}
+			recs = append(recs, r)
+		}
+	}
+	// failure that wasn't confirmed doesn't count in latency
+	recs = append(recs, db.Record{URL: "https://example.com", LocalIP: "10.0.0.1",
+		Time: start.Add(30*time.Hour + 30*time.Minute), Up: true, Duration: 10 * time.Second, Error: "timeout"})
+
+	d := memory.New()
+	d.Add(recs...)
+	page := New(cfg, d)
+	tls, err := page.timelines(start, now)
+	if err != nil {
+		t.Fatalf("timelines() error = %s", err)
+	}
+	recent, err := d.GetRecords(now.Add(-day), now)
+	if err != nil {
+		t.Fatalf("GetRecords() error = %s", err)
+	}
+
+	p := buildPage(cfg, tls, recent, now)
+	if p.State != stateUnknown {
+		t.Errorf("State = %s, want %s", p.State, stateUnknown)
+	}
+
+	home := p.Groups[0].Targets[0]
+	if home.State != stateUp || home.Latency != 100*time.Millisecond || len(home.Sparkline) == 0 {
+		t.Errorf("unexpected target %+v", home)
+	}
+	if home.Days[0].HasData || !home.Days[1].HasData || home.Days[1].Uptime != 87.5 || home.Days[2].Uptime != 100 {
+		t.Errorf("unexpected days %+v", home.Days)
+	}
+	if other := p.Groups[0].Targets[1]; other.State != stateUnknown || other.Uptime >= 0 {
+		t.Errorf("unexpected target %+v", other)
+	}
+
+	if len(p.Incidents) != 1 {
+		t.Fatalf("got %d incidents, want 1: %+v", len(p.Incidents), p.Incidents)
+	}
+	// the incident started before the last day, its reason is looked up
+	if len(p.Incidents[0].Reason) > 0 {
+		t.Errorf("reason %q is set without the last day records", p.Incidents[0].Reason)
+	}
+	if err = page.addReasons(p.Incidents); err != nil {
+		t.Fatalf("addReasons() error = %s", err)
+	}
+	if i := p.Incidents[0]; i.Duration() != 3*time.Hour || i.Reason != "timeout" || i.Target != "Homepage" {
+		t.Errorf("unexpected incident %+v", i)
+	}
+}
+
+func TestPageState(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %s", err)
+	}
+
+	now := time.Date(2020, 1, 3, 12, 0, 0, 0, time.UTC)
+	rec := func(url string, up bool) db.Record {
+		return db.Record{URL: url, LocalIP: "10.0.0.1", Time: now.Add(-time.Hour), Up: up}
+	}
+	tests := []struct {
+		name   string
+		recent []db.Record
+		want   string
+	}{
+		{"all up", []db.Record{rec("https://example.com", true), rec("http://example.org", true),
+			rec("http://example.com/r?u=http://x", true)}, stateUp},
+		{"unknown", []db.Record{rec("https://example.com", true), rec("http://example.org", true)}, stateUnknown},
+		{"down before unknown", []db.Record{rec("https://example.com", false)}, stateDown},
+		{"down after unknown", []db.Record{rec("http://example.com/r?u=http://x", false)}, stateDown},
+	}
+	for _, tt := range tests {
+		if got := buildPage(cfg, nil, tt.recent, now).State; got != tt.want {
+			t.Errorf("%s: State = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %s", err)
+	}
+
+	d := memory.New()
+	now := time.Now()
+	d.Add(db.Record{URL: "https://example.com", LocalIP: "10.0.0.1", Time: now.Add(-time.Minute), Up: true},
+		db.Record{URL: "https://secret.example.com", LocalIP: "10.0.0.1", Time: now.Add(-time.Minute), Up: true})
+
+	p := New(cfg, d)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Homepage") || strings.Contains(body, "secret") {
+		t.Errorf("unexpected page:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
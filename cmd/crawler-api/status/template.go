@@ -0,0 +1,88 @@
+package status
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+var funcs = template.FuncMap{
+	"percent": func(v float64) string {
+		if v < 0 {
+			return "no data"
+		}
+		return fmt.Sprintf("%.2f%%", v)
+	},
+	"date": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02")
+	},
+	"datetime": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	},
+	"round": func(d time.Duration) time.Duration {
+		if d > time.Minute {
+			return d - d%time.Second
+		}
+		return d - d%time.Millisecond
+	},
+}
+
+var pageTemplate = template.Must(template.New("status").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; max-width: 960px; margin: 0 auto; padding: 1em; }
+.banner { padding: 1em; border-radius: 4px; color: #fff; font-size: 1.2em; margin-bottom: 2em; }
+.banner.up { background: #2da44e; } .banner.down { background: #cf222e; } .banner.unknown { background: #8c959f; }
+.group { margin-bottom: 2em; }
+.target { border: 1px solid #d0d7de; border-radius: 4px; padding: .8em; margin-bottom: .5em; }
+.target header { display: flex; justify-content: space-between; align-items: center; }
+.state { font-weight: bold; } .state.up { color: #2da44e; } .state.down { color: #cf222e; } .state.unknown { color: #8c959f; }
+.bars { display: flex; gap: 1px; margin: .5em 0; height: 28px; }
+.bars span { flex: 1; border-radius: 1px; }
+.bars .good { background: #2da44e; } .bars .degraded { background: #d4a72c; } .bars .bad { background: #cf222e; } .bars .none { background: #d0d7de; }
+.meta { display: flex; justify-content: space-between; align-items: center; color: #57606a; font-size: .85em; }
+svg polyline { fill: none; stroke: #0969da; stroke-width: 1.5; }
+table { width: 100%; border-collapse: collapse; } td, th { text-align: left; padding: .3em; border-bottom: 1px solid #d0d7de; }
+footer { color: #57606a; font-size: .8em; margin-top: 2em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="banner {{.State}}">{{if eq .State "up"}}All systems operational{{else if eq .State "down"}}Some systems are down{{else}}Some systems have no data{{end}}</div>
+{{range .Groups}}
+<section class="group">
+{{if .Name}}<h2>{{.Name}}</h2>{{end}}
+{{range .Targets}}
+<div class="target">
+<header><span>{{.Name}}</span><span class="state {{.State}}">{{.State}}</span></header>
+<div class="bars">{{range .Days}}<span class="{{.Class}}" title="{{date .Date}}: {{if .HasData}}{{percent .Uptime}}{{else}}no data{{end}}"></span>{{end}}</div>
+<div class="meta">
+<span>{{$.Days}} days ago</span>
+<span>{{percent .Uptime}} uptime</span>
+<span>{{if .Sparkline}}<svg width="120" height="24" viewBox="0 0 120 24"><polyline points="{{.Sparkline}}"/></svg> avg {{round .Latency}}{{end}}</span>
+<span>today</span>
+</div>
+</div>
+{{end}}
+</section>
+{{end}}
+<section>
+<h2>Incidents</h2>
+{{if .Incidents}}
+<table>
+<tr><th>Target</th><th>Started</th><th>Duration</th><th>Reason</th></tr>
+{{range .Incidents}}<tr><td>{{.Target}}</td><td>{{datetime .From}}</td><td>{{round .Duration}}</td><td>{{.Reason}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>No incidents reported.</p>
+{{end}}
+</section>
+<footer>Updated {{datetime .Updated}}</footer>
+</body>
+</html>
+`))
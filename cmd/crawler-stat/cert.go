@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+)
+
+// certFormats write certificate report, certificate status is evaluated at
+// now with o.certWarnDays threshold
+var certFormats = map[string]func(w io.Writer, certs []stat.Cert, now time.Time, o *options) error{
+	formatText:     writeCertText,
+	formatJSON:     writeCertJSON,
+	formatCSV:      writeCertCSV,
+	formatMarkdown: writeCertMarkdown,
+	formatCompact:  writeCertCompact,
+}
+
+var certColumns = []string{"url", "source", "expiry", "days_left", "status", "issuer", "sans",
+	"chain_valid", "host_valid", "checked"}
+
+func certRow(c *stat.Cert, now time.Time, o *options) []string {
+	return []string{c.URL, c.LocalIP, formatTime(c.Expiry, o.location), fmt.Sprint(c.DaysLeft(now)),
+		c.Status(now, o.certWarnDays), c.Issuer, strings.Join(c.SANs, " "), fmt.Sprint(c.ChainValid),
+		fmt.Sprint(c.HostValid), formatTime(c.Time, o.location)}
+}
+
+func writeCertText(w io.Writer, certs []stat.Cert, now time.Time, o *options) error {
+	for i := range certs {
+		c := &certs[i]
+		fmt.Fprintf(w, "%s [from %s]: %s\n\texpires: %s (%d days)\n\tissuer: %s\n\tsans: %s\n"+
+			"\tchain valid: %t, host valid: %t\n\tchecked: %s\n",
+			c.URL, c.LocalIP, c.Status(now, o.certWarnDays), c.Expiry.In(o.location), c.DaysLeft(now), c.Issuer,
+			strings.Join(c.SANs, ", "), c.ChainValid, c.HostValid, c.Time.In(o.location))
+	}
+	return nil
+}
+
+type certJSON struct {
+	URL        string    `json:"url"`
+	Source     string    `json:"source"`
+	Expiry     time.Time `json:"expiry"`
+	DaysLeft   int       `json:"days_left"`
+	Status     string    `json:"status"`
+	Issuer     string    `json:"issuer"`
+	SANs       []string  `json:"sans"`
+	ChainValid bool      `json:"chain_valid"`
+	HostValid  bool      `json:"host_valid"`
+	Checked    time.Time `json:"checked"`
+}
+
+func writeCertJSON(w io.Writer, certs []stat.Cert, now time.Time, o *options) error {
+	res := make([]certJSON, len(certs))
+	for i := range certs {
+		c := &certs[i]
+		res[i] = certJSON{
+			URL:        c.URL,
+			Source:     c.LocalIP,
+			Expiry:     c.Expiry,
+			DaysLeft:   c.DaysLeft(now),
+			Status:     c.Status(now, o.certWarnDays),
+			Issuer:     c.Issuer,
+			SANs:       c.SANs,
+			ChainValid: c.ChainValid,
+			HostValid:  c.HostValid,
+			Checked:    c.Time,
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+func writeCertCSV(w io.Writer, certs []stat.Cert, now time.Time, o *options) error {
+	cw := csv.NewWriter(w)
+	cw.Write(certColumns)
+	for i := range certs {
+		cw.Write(certRow(&certs[i], now, o))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCertMarkdown(w io.Writer, certs []stat.Cert, now time.Time, o *options) error {
+	rows := make([][]string, len(certs))
+	for i := range certs {
+		rows[i] = certRow(&certs[i], now, o)
+	}
+	writeMarkdownTable(w, certColumns, rows)
+	return nil
+}
+
+func writeCertCompact(w io.Writer, certs []stat.Cert, now time.Time, o *options) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tSOURCE\tDAYS LEFT\tEXPIRES\tSTATUS\tISSUER")
+	for i := range certs {
+		c := &certs[i]
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", c.URL, c.LocalIP, c.DaysLeft(now),
+			c.Expiry.In(o.location).Format("2006-01-02"), c.Status(now, o.certWarnDays), c.Issuer)
+	}
+	return tw.Flush()
+}
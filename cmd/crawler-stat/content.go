@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+)
+
+// contentFormats write content changes report, times are shown in o.location
+var contentFormats = map[string]func(w io.Writer, changes []stat.Change, o *options) error{
+	formatText:     writeContentText,
+	formatJSON:     writeContentJSON,
+	formatCSV:      writeContentCSV,
+	formatMarkdown: writeContentMarkdown,
+	formatCompact:  writeContentCompact,
+}
+
+var contentColumns = []string{"url", "source", "time", "change", "hash", "previous", "before", "after"}
+
+func contentRow(c *stat.Change, loc *time.Location) []string {
+	return []string{c.URL, c.LocalIP, formatTime(c.Time, loc), c.Kind, c.Hash, c.Previous, c.Before, c.After}
+}
+
+func writeContentText(w io.Writer, changes []stat.Change, o *options) error {
+	for i := range changes {
+		c := &changes[i]
+		fmt.Fprintf(w, "%s [from %s]: %s at %s\n\thash: %s\n\tprevious: %s\n\tbefore: %q\n\tafter: %q\n",
+			c.URL, c.LocalIP, c.Kind, c.Time.In(o.location), c.Hash, c.Previous, c.Before, c.After)
+	}
+	return nil
+}
+
+type contentJSON struct {
+	URL      string    `json:"url"`
+	Source   string    `json:"source"`
+	Time     time.Time `json:"time"`
+	Change   string    `json:"change"`
+	Hash     string    `json:"hash"`
+	Previous string    `json:"previous"`
+	Before   string    `json:"before"`
+	After    string    `json:"after"`
+}
+
+func writeContentJSON(w io.Writer, changes []stat.Change, o *options) error {
+	res := make([]contentJSON, len(changes))
+	for i := range changes {
+		c := &changes[i]
+		res[i] = contentJSON{
+			URL:      c.URL,
+			Source:   c.LocalIP,
+			Time:     c.Time,
+			Change:   c.Kind,
+			Hash:     c.Hash,
+			Previous: c.Previous,
+			Before:   c.Before,
+			After:    c.After,
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+func writeContentCSV(w io.Writer, changes []stat.Change, o *options) error {
+	cw := csv.NewWriter(w)
+	cw.Write(contentColumns)
+	for i := range changes {
+		cw.Write(contentRow(&changes[i], o.location))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeContentMarkdown(w io.Writer, changes []stat.Change, o *options) error {
+	rows := make([][]string, len(changes))
+	for i := range changes {
+		rows[i] = contentRow(&changes[i], o.location)
+	}
+	writeMarkdownTable(w, contentColumns, rows)
+	return nil
+}
+
+func writeContentCompact(w io.Writer, changes []stat.Change, o *options) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tSOURCE\tTIME\tCHANGE\tHASH")
+	for i := range changes {
+		c := &changes[i]
+		hash := c.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.URL, c.LocalIP, formatTime(c.Time, o.location), c.Kind, hash)
+	}
+	return tw.Flush()
+}
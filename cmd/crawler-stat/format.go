@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+)
+
+// Output formats
+const (
+	formatText     = "text"
+	formatJSON     = "json"
+	formatCSV      = "csv"
+	formatMarkdown = "markdown"
+	formatCompact  = "compact"
+)
+
+// options are report options set by flags
+type options struct {
+	intervals    bool           // include uptime/downtime intervals
+	slo          float64        // SLO target to evaluate stats against, 0 if disabled
+	buckets      stat.Period    // period stats are split into, 0 if disabled
+	location     *time.Location // time zone times and bucket boundaries are in
+	certWarnDays int            // days before expiry certificate is reported as expiring at
+}
+
+// formats write stats of timelines, intervals are included if o.intervals
+// is set and SLO evaluation if o.slo target is positive
+var formats = map[string]func(w io.Writer, tls []stat.Timeline, o *options) error{
+	formatText:     writeText,
+	formatJSON:     writeJSON,
+	formatCSV:      writeCSV,
+	formatMarkdown: writeMarkdown,
+	formatCompact:  writeCompact,
+}
+
+func uptimePercent(s *stat.Stat) float64 {
+	if s.WholeTime == 0 {
+		return 0
+	}
+	return float64(s.UpTime*100) / float64(s.WholeTime)
+}
+
+// failureReasons returns failure reasons sorted by count desc
+func failureReasons(s *stat.Stat) []string {
+	reasons := make([]string, 0, len(s.Failures))
+	for r := range s.Failures {
+		reasons = append(reasons, r)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if s.Failures[reasons[i]] != s.Failures[reasons[j]] {
+			return s.Failures[reasons[i]] > s.Failures[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	return reasons
+}
+
+func formatFailures(s *stat.Stat) string {
+	reasons := failureReasons(s)
+	for i, r := range reasons {
+		reasons[i] = fmt.Sprintf("%s: %d", r, s.Failures[r])
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func formatTime(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// column is a stat table column
+type column struct {
+	name  string
+	value func(s *stat.Stat) string
+}
+
+// statColumns returns stat table columns, times are formatted in loc
+func statColumns(loc *time.Location) []column {
+	return []column{
+		{"url", func(s *stat.Stat) string { return s.URL }},
+		{"source", func(s *stat.Stat) string { return s.LocalIP }},
+		{"whole_time", func(s *stat.Stat) string { return seconds(s.WholeTime) }},
+		{"uptime", func(s *stat.Stat) string { return seconds(s.UpTime) }},
+		{"uptime_percent", func(s *stat.Stat) string { return fmt.Sprintf("%.2f", uptimePercent(s)) }},
+		{"longest_down", func(s *stat.Stat) string {
+			if s.LongestDown == nil {
+				return ""
+			}
+			return seconds(s.LongestDown.Duration())
+		}},
+		{"longest_down_from", func(s *stat.Stat) string {
+			if s.LongestDown == nil {
+				return ""
+			}
+			return formatTime(s.LongestDown.From, loc)
+		}},
+		{"longest_down_to", func(s *stat.Stat) string {
+			if s.LongestDown == nil {
+				return ""
+			}
+			return formatTime(s.LongestDown.To, loc)
+		}},
+		{"failures", formatFailures},
+		{"latency_p50", func(s *stat.Stat) string { return seconds(s.Latency.P50) }},
+		{"latency_p90", func(s *stat.Stat) string { return seconds(s.Latency.P90) }},
+		{"latency_p99", func(s *stat.Stat) string { return seconds(s.Latency.P99) }},
+		{"incidents", func(s *stat.Stat) string { return fmt.Sprint(s.Incidents) }},
+		{"mtbf", func(s *stat.Stat) string { return seconds(s.MTBF) }},
+		{"mttr", func(s *stat.Stat) string { return seconds(s.MTTR) }},
+	}
+}
+
+// sloColumns returns columns added to stat columns if SLO target is set
+func sloColumns(target float64) []column {
+	return []column{
+		{"slo_target", func(s *stat.Stat) string { return fmt.Sprint(target) }},
+		{"slo_met", func(s *stat.Stat) string { o := s.SLO(target); return fmt.Sprint(o.Met) }},
+		{"error_budget", func(s *stat.Stat) string { o := s.SLO(target); return seconds(o.ErrorBudget) }},
+		{"error_budget_remaining", func(s *stat.Stat) string { o := s.SLO(target); return seconds(o.BudgetRemaining) }},
+		{"burn_rate", func(s *stat.Stat) string { o := s.SLO(target); return fmt.Sprintf("%.2f", o.BurnRate) }},
+	}
+}
+
+// columns returns stat table columns for report options
+func columns(o *options) []column {
+	cols := statColumns(o.location)
+	if o.slo > 0 {
+		return append(cols, sloColumns(o.slo)...)
+	}
+	return cols
+}
+
+var intervalColumns = []string{"url", "source", "up", "from", "to", "duration"}
+
+var bucketColumns = []string{"url", "source", "from", "to", "whole_time", "uptime", "uptime_percent"}
+
+func bucketPercent(b *stat.Bucket) float64 {
+	if b.WholeTime == 0 {
+		return 0
+	}
+	return float64(b.UpTime*100) / float64(b.WholeTime)
+}
+
+func bucketRows(tls []stat.Timeline, o *options) [][]string {
+	var rows [][]string
+	for i := range tls {
+		for _, b := range tls[i].Buckets(o.buckets, o.location) {
+			rows = append(rows, []string{tls[i].URL, tls[i].LocalIP, formatTime(b.From, o.location),
+				formatTime(b.To, o.location),
+				seconds(b.WholeTime), seconds(b.UpTime), fmt.Sprintf("%.2f", bucketPercent(&b))})
+		}
+	}
+	return rows
+}
+
+func intervalRow(tl *stat.Timeline, iv *stat.Interval, loc *time.Location) []string {
+	return []string{tl.URL, tl.LocalIP, fmt.Sprint(iv.Up), formatTime(iv.From, loc), formatTime(iv.To, loc),
+		seconds(iv.Duration())}
+}
+
+func statRow(s *stat.Stat, o *options) []string {
+	cols := columns(o)
+	row := make([]string, len(cols))
+	for i, c := range cols {
+		row[i] = c.value(s)
+	}
+	return row
+}
+
+func statHeader(o *options) []string {
+	cols := columns(o)
+	header := make([]string, len(cols))
+	for i, c := range cols {
+		header[i] = c.name
+	}
+	return header
+}
+
+func writeText(w io.Writer, tls []stat.Timeline, o *options) error {
+	loc := o.location
+	for i := range tls {
+		s := tls[i].Stat()
+		fmt.Fprintf(w, "%s [from %s]:\n\twhole time: %s \n\tuptime: %s (%.2f%%)\n",
+			s.URL, s.LocalIP, s.WholeTime, s.UpTime, uptimePercent(&s))
+
+		if s.LongestDown != nil {
+			fmt.Fprintf(w, "\tlongest downtime %s:\n\t\tfrom: %s\n\t\tto:   %s\n", s.LongestDown.Duration(),
+				s.LongestDown.From.In(loc), s.LongestDown.To.In(loc))
+		}
+
+		if s.Latency != (stat.Latency{}) {
+			fmt.Fprintf(w, "\tlatency: p50 %s, p90 %s, p99 %s\n", s.Latency.P50, s.Latency.P90, s.Latency.P99)
+		}
+		if s.Incidents > 0 {
+			fmt.Fprintf(w, "\tincidents: %d (MTBF %s, MTTR %s)\n", s.Incidents, s.MTBF, s.MTTR)
+		}
+		if o.slo > 0 {
+			so := s.SLO(o.slo)
+			met := "met"
+			if !so.Met {
+				met = "violated"
+			}
+			fmt.Fprintf(w, "\tSLO %g%%: %s, error budget %s, remaining %s (%.2f%%), burn rate %.2f\n",
+				so.Target, met, so.ErrorBudget, so.BudgetRemaining, so.BudgetRemainingPercent(), so.BurnRate)
+		}
+
+		if len(s.Failures) > 0 {
+			fmt.Fprintf(w, "\tfailures:\n")
+			for _, r := range failureReasons(&s) {
+				fmt.Fprintf(w, "\t\t%s: %d\n", r, s.Failures[r])
+			}
+		}
+
+		if o.buckets != 0 {
+			fmt.Fprintf(w, "\tbuckets:\n")
+			for _, b := range tls[i].Buckets(o.buckets, loc) {
+				fmt.Fprintf(w, "\t\t%s: %.2f%% of %s\n", b.From.Format("2006-01-02 15:04 MST"), bucketPercent(&b),
+					b.WholeTime)
+			}
+		}
+
+		if o.intervals {
+			fmt.Fprintf(w, "\tintervals:\n")
+			for _, iv := range tls[i].Intervals {
+				state := "up"
+				if !iv.Up {
+					state = "down"
+				}
+				fmt.Fprintf(w, "\t\t%-4s %s - %s (%s)\n", state, iv.From.In(loc), iv.To.In(loc),
+					iv.Duration())
+			}
+		}
+	}
+	return nil
+}
+
+type intervalJSON struct {
+	Up       bool      `json:"up"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Duration float64   `json:"duration"`
+}
+
+type latencyJSON struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+type bucketJSON struct {
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	WholeTime     float64   `json:"whole_time"`
+	UpTime        float64   `json:"uptime"`
+	UptimePercent float64   `json:"uptime_percent"`
+}
+
+type sloJSON struct {
+	Target          float64 `json:"target"`
+	Met             bool    `json:"met"`
+	ErrorBudget     float64 `json:"error_budget"`
+	BudgetRemaining float64 `json:"error_budget_remaining"`
+	BurnRate        float64 `json:"burn_rate"`
+}
+
+type statJSON struct {
+	URL           string         `json:"url"`
+	Source        string         `json:"source"`
+	WholeTime     float64        `json:"whole_time"`
+	UpTime        float64        `json:"uptime"`
+	UptimePercent float64        `json:"uptime_percent"`
+	LongestDown   *intervalJSON  `json:"longest_down"`
+	Failures      map[string]int `json:"failures,omitempty"`
+	Latency       latencyJSON    `json:"latency"`
+	Incidents     int            `json:"incidents"`
+	MTBF          float64        `json:"mtbf"`
+	MTTR          float64        `json:"mttr"`
+	SLO           *sloJSON       `json:"slo,omitempty"`
+	Buckets       []bucketJSON   `json:"buckets,omitempty"`
+	Intervals     []intervalJSON `json:"intervals"`
+}
+
+func newIntervalJSON(iv *stat.Interval) intervalJSON {
+	return intervalJSON{Up: iv.Up, From: iv.From, To: iv.To, Duration: iv.Duration().Seconds()}
+}
+
+// writeJSON writes stats as JSON array, intervals are always included
+func writeJSON(w io.Writer, tls []stat.Timeline, o *options) error {
+	res := make([]statJSON, len(tls))
+	for i := range tls {
+		s := tls[i].Stat()
+		res[i] = statJSON{
+			URL:           s.URL,
+			Source:        s.LocalIP,
+			WholeTime:     s.WholeTime.Seconds(),
+			UpTime:        s.UpTime.Seconds(),
+			UptimePercent: uptimePercent(&s),
+			Failures:      s.Failures,
+			Latency: latencyJSON{
+				P50: s.Latency.P50.Seconds(),
+				P90: s.Latency.P90.Seconds(),
+				P99: s.Latency.P99.Seconds(),
+			},
+			Incidents: s.Incidents,
+			MTBF:      s.MTBF.Seconds(),
+			MTTR:      s.MTTR.Seconds(),
+			Intervals: make([]intervalJSON, len(tls[i].Intervals)),
+		}
+		if o.slo > 0 {
+			so := s.SLO(o.slo)
+			res[i].SLO = &sloJSON{
+				Target:          so.Target,
+				Met:             so.Met,
+				ErrorBudget:     so.ErrorBudget.Seconds(),
+				BudgetRemaining: so.BudgetRemaining.Seconds(),
+				BurnRate:        so.BurnRate,
+			}
+		}
+		if s.LongestDown != nil {
+			ld := newIntervalJSON(s.LongestDown)
+			res[i].LongestDown = &ld
+		}
+		for j := range tls[i].Intervals {
+			res[i].Intervals[j] = newIntervalJSON(&tls[i].Intervals[j])
+		}
+		if o.buckets != 0 {
+			for _, b := range tls[i].Buckets(o.buckets, o.location) {
+				res[i].Buckets = append(res[i].Buckets, bucketJSON{
+					From:          b.From,
+					To:            b.To,
+					WholeTime:     b.WholeTime.Seconds(),
+					UpTime:        b.UpTime.Seconds(),
+					UptimePercent: bucketPercent(&b),
+				})
+			}
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+// writeCSV writes a row per target or, if bucket period is set, a row per
+// bucket or, if intervals is set, a row per interval
+func writeCSV(w io.Writer, tls []stat.Timeline, o *options) error {
+	cw := csv.NewWriter(w)
+	if o.buckets != 0 {
+		cw.Write(bucketColumns)
+		cw.WriteAll(bucketRows(tls, o))
+	} else if o.intervals {
+		cw.Write(intervalColumns)
+		for i := range tls {
+			for j := range tls[i].Intervals {
+				cw.Write(intervalRow(&tls[i], &tls[i].Intervals[j], o.location))
+			}
+		}
+	} else {
+		cw.Write(statHeader(o))
+		for i := range tls {
+			s := tls[i].Stat()
+			cw.Write(statRow(&s, o))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\n", " ")
+
+func writeMarkdownRow(w io.Writer, row []string) {
+	for i := range row {
+		row[i] = markdownEscaper.Replace(row[i])
+	}
+	fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | "))
+}
+
+func writeMarkdownTable(w io.Writer, header []string, rows [][]string) {
+	writeMarkdownRow(w, header)
+	sep := make([]string, len(header))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	writeMarkdownRow(w, sep)
+	for _, row := range rows {
+		writeMarkdownRow(w, row)
+	}
+}
+
+// writeMarkdown writes stats table followed by buckets table if bucket period
+// is set and intervals table if intervals is set
+func writeMarkdown(w io.Writer, tls []stat.Timeline, o *options) error {
+	header := statHeader(o)
+	rows := make([][]string, len(tls))
+	for i := range tls {
+		s := tls[i].Stat()
+		rows[i] = statRow(&s, o)
+	}
+	writeMarkdownTable(w, header, rows)
+
+	if o.buckets != 0 {
+		fmt.Fprintln(w)
+		writeMarkdownTable(w, bucketColumns, bucketRows(tls, o))
+	}
+
+	if o.intervals {
+		rows = rows[:0]
+		for i := range tls {
+			for j := range tls[i].Intervals {
+				rows = append(rows, intervalRow(&tls[i], &tls[i].Intervals[j], o.location))
+			}
+		}
+		fmt.Fprintln(w)
+		writeMarkdownTable(w, intervalColumns, rows)
+	}
+	return nil
+}
+
+// writeCompact writes aligned table with a line per target followed by a table
+// with a line per bucket if bucket period is set
+func writeCompact(w io.Writer, tls []stat.Timeline, o *options) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	header := "URL\tSOURCE\tUPTIME\tWHOLE TIME\tLONGEST DOWN\tFAILURES\tINCIDENTS\tP99"
+	if o.slo > 0 {
+		header += "\tBUDGET LEFT"
+	}
+	fmt.Fprintln(tw, header)
+	for i := range tls {
+		s := tls[i].Stat()
+		longest := "-"
+		if s.LongestDown != nil {
+			longest = s.LongestDown.Duration().String()
+		}
+		var failures int
+		for _, n := range s.Failures {
+			failures += n
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.2f%%\t%s\t%s\t%d\t%d\t%s", s.URL, s.LocalIP, uptimePercent(&s), s.WholeTime,
+			longest, failures, s.Incidents, s.Latency.P99)
+		if o.slo > 0 {
+			so := s.SLO(o.slo)
+			fmt.Fprintf(tw, "\t%.2f%%", so.BudgetRemainingPercent())
+		}
+		fmt.Fprintln(tw)
+	}
+
+	if o.buckets != 0 {
+		fmt.Fprintf(tw, "\nURL\tSOURCE\tBUCKET\tUPTIME\tWHOLE TIME\n")
+		for i := range tls {
+			for _, b := range tls[i].Buckets(o.buckets, o.location) {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f%%\t%s\n", tls[i].URL, tls[i].LocalIP,
+					b.From.Format("2006-01-02 15:04 MST"), bucketPercent(&b), b.WholeTime)
+			}
+		}
+	}
+	return tw.Flush()
+}
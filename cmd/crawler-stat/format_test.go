@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+func testTimelines() []stat.Timeline {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []stat.Timeline{{
+		URL:     "http://a|b",
+		LocalIP: "10.0.0.1",
+		Intervals: []stat.Interval{
+			{Up: true, From: start, To: start.Add(3 * time.Minute)},
+			{Up: false, From: start.Add(3 * time.Minute), To: start.Add(4 * time.Minute)},
+		},
+		Failures: map[string]int{"timeout": 2, "dns": 1},
+	}}
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format    string
+		intervals bool
+		slo       float64
+		want      []string
+	}{
+		{formatText, true, 0, []string{"uptime: 3m0s (75.00%)", "\t\ttimeout: 2\n\t\tdns: 1\n", "down",
+			"incidents: 1 (MTBF 3m0s, MTTR 1m0s)"}},
+		{formatText, false, 90, []string{"SLO 90%: violated, error budget 24s, remaining -36s (-150.00%), burn rate 2.50"}},
+		{formatCSV, false, 0, []string{"url,source,whole_time", "http://a|b,10.0.0.1,240.000,180.000,75.00,60.000,",
+			",timeout: 2; dns: 1,0.000,0.000,0.000,1,180.000,60.000\n"}},
+		{formatCSV, false, 75, []string{",mttr,slo_target,slo_met,error_budget,error_budget_remaining,burn_rate\n",
+			",75,true,60.000,0.000,1.00\n"}},
+		{formatCSV, true, 0, []string{"url,source,up,from,to,duration\n", ",false,", ",60.000\n"}},
+		{formatMarkdown, true, 0, []string{"| url | source |", "| --- |", `| http://a\|b | 10.0.0.1 |`, "| up | from |"}},
+		{formatCompact, false, 80, []string{"URL", "75.00%", "1m0s", "BUDGET LEFT", "-25.00%\n"}},
+	}
+	for _, tt := range tests {
+		buf := &bytes.Buffer{}
+		o := &options{intervals: tt.intervals, slo: tt.slo, location: time.UTC}
+		if err := formats[tt.format](buf, testTimelines(), o); err != nil {
+			t.Fatalf("%s: error = %s", tt.format, err)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(buf.String(), w) {
+				t.Errorf("%s output doesn't contain %q:\n%s", tt.format, w, buf)
+			}
+		}
+	}
+}
+
+// TestFormatsEmpty checks that timeline without intervals, e.g. of a target
+// checked once, has zero uptime percent in every format
+func TestFormatsEmpty(t *testing.T) {
+	tls := []stat.Timeline{{URL: "http://a", LocalIP: "10.0.0.1"}}
+	for name, write := range formats {
+		buf := &bytes.Buffer{}
+		if err := write(buf, tls, &options{intervals: true, slo: 99, location: time.UTC}); err != nil {
+			t.Fatalf("%s: error = %s", name, err)
+		}
+		if strings.Contains(buf.String(), "NaN") {
+			t.Errorf("%s output contains NaN:\n%s", name, buf)
+		}
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := writeJSON(buf, testTimelines(), &options{location: time.UTC}); err != nil {
+		t.Fatalf("writeJSON() error = %s", err)
+	}
+
+	var res []statJSON
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("Failed to decode output: %s", err)
+	}
+	if len(res) != 1 || res[0].UptimePercent != 75 || res[0].LongestDown == nil ||
+		res[0].LongestDown.Duration != 60 || len(res[0].Intervals) != 2 || res[0].Failures["dns"] != 1 {
+		t.Errorf("unexpected output %+v", res)
+	}
+}
+
+func TestFormatBuckets(t *testing.T) {
+	o := &options{buckets: stat.Hour, location: time.FixedZone("IST", 5*60*60+30*60)}
+	buf := &bytes.Buffer{}
+	if err := writeCSV(buf, testTimelines(), o); err != nil {
+		t.Fatalf("writeCSV() error = %s", err)
+	}
+	want := "url,source,from,to,whole_time,uptime,uptime_percent\n" +
+		"http://a|b,10.0.0.1,2020-01-01T05:00:00+05:30,2020-01-01T06:00:00+05:30,240.000,180.000,75.00\n"
+	if buf.String() != want {
+		t.Errorf("writeCSV() = %q, want %q", buf, want)
+	}
+}
+
+func TestCertFormats(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	certs := []stat.Cert{{URL: "https://a", LocalIP: "10.0.0.1", Time: now, Certificate: db.Certificate{
+		Expiry: now.AddDate(0, 0, 10), Issuer: "Test CA", SANs: []string{"a", "www.a"}, ChainValid: true, HostValid: true}}}
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{formatText, []string{"https://a [from 10.0.0.1]: expiring", "(10 days)", "issuer: Test CA", "sans: a, www.a"}},
+		{formatCSV, []string{"url,source,expiry,days_left,status", ",10,expiring,Test CA,a www.a,true,true,"}},
+		{formatMarkdown, []string{"| url | source |", "| 10 | expiring |"}},
+		{formatCompact, []string{"DAYS LEFT", "2020-01-11"}},
+		{formatJSON, []string{`"days_left": 10`, `"status": "expiring"`}},
+	}
+	for _, tt := range tests {
+		buf := &bytes.Buffer{}
+		if err := certFormats[tt.format](buf, certs, now, &options{location: time.UTC, certWarnDays: 14}); err != nil {
+			t.Fatalf("%s: error = %s", tt.format, err)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(buf.String(), w) {
+				t.Errorf("%s output doesn't contain %q:\n%s", tt.format, w, buf)
+			}
+		}
+	}
+}
+
+func TestContentFormats(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	changes := []stat.Change{{URL: "https://a", LocalIP: "10.0.0.1", Time: now, Hash: "0123456789abcdef",
+		ContentChange: db.ContentChange{Kind: db.ContentChanged, Previous: "fedcba", Before: "old|\n", After: "new"}}}
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{formatText, []string{"https://a [from 10.0.0.1]: changed", "previous: fedcba", `before: "old|\n"`}},
+		{formatCSV, []string{"url,source,time,change,hash", ",changed,0123456789abcdef,fedcba,"}},
+		{formatMarkdown, []string{"| url | source |", `| changed | 0123456789abcdef | fedcba | old\|  | new |`}},
+		{formatCompact, []string{"CHANGE", "0123456789ab\n"}},
+		{formatJSON, []string{`"change": "changed"`, `"after": "new"`}},
+	}
+	for _, tt := range tests {
+		buf := &bytes.Buffer{}
+		if err := contentFormats[tt.format](buf, changes, &options{location: time.UTC}); err != nil {
+			t.Fatalf("%s: error = %s", tt.format, err)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(buf.String(), w) {
+				t.Errorf("%s output doesn't contain %q:\n%s", tt.format, w, buf)
+			}
+		}
+	}
+}
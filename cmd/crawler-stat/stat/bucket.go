@@ -0,0 +1,100 @@
+package stat
+
+import (
+	"fmt"
+	"time"
+)
+
+// Period is a stats bucket size
+type Period int
+
+// Bucket periods
+const (
+	Hour Period = iota + 1
+	Day
+	Week
+)
+
+// ParsePeriod parses bucket period: 1h, 1d or 1w
+func ParsePeriod(s string) (Period, error) {
+	switch s {
+	case "1h", "hour":
+		return Hour, nil
+	case "1d", "day":
+		return Day, nil
+	case "1w", "week":
+		return Week, nil
+	}
+	return 0, fmt.Errorf("unknown period %q, should be 1h, 1d or 1w", s)
+}
+
+// start returns start of the bucket t belongs to. Days start at midnight and
+// weeks on Monday midnight in loc, so DST changes are taken into account.
+// Hours are truncated in absolute time, so hours repeated when DST ends are
+// separate buckets.
+func (p Period) start(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch p {
+	case Hour:
+		// offset is applied for zones that aren't whole hours off UTC
+		_, offset := t.Zone()
+		shift := time.Duration(offset) * time.Second
+		return t.Add(shift).Truncate(time.Hour).Add(-shift)
+	case Week:
+		offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
+
+// next returns start of the bucket following the one starting at start
+func (p Period) next(start time.Time) time.Time {
+	switch p {
+	case Hour:
+		return start.Add(time.Hour)
+	case Week:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Bucket is a server uptime within a time bucket
+type Bucket struct {
+	From      time.Time
+	To        time.Time
+	WholeTime time.Duration // time data is available for within the bucket
+	UpTime    time.Duration
+}
+
+// Buckets splits timeline intervals at bucket boundaries and returns uptime
+// per bucket, buckets with no data are omitted
+func (u *Timeline) Buckets(p Period, loc *time.Location) []Bucket {
+	var res []Bucket
+	for _, iv := range u.Intervals {
+		for from := iv.From; from.Before(iv.To); {
+			start := p.start(from, loc)
+			end := p.next(start)
+			// local midnight may be skipped or repeated by DST change
+			for !end.After(from) {
+				start, end = end, p.next(end)
+			}
+			to := iv.To
+			if end.Before(to) {
+				to = end
+			}
+
+			if len(res) == 0 || !res[len(res)-1].From.Equal(start) {
+				res = append(res, Bucket{From: start, To: end})
+			}
+			b := &res[len(res)-1]
+			b.WholeTime += to.Sub(from)
+			if iv.Up {
+				b.UpTime += to.Sub(from)
+			}
+			from = to
+		}
+	}
+	return res
+}
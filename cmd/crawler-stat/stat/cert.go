@@ -0,0 +1,65 @@
+package stat
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// Certificate statuses, the first matching one is reported
+const (
+	CertInvalid  = "invalid"  // chain is not trusted or expired
+	CertMismatch = "mismatch" // certificate is not valid for the host
+	CertExpiring = "expiring" // expires within the warning period
+	CertOK       = "ok"
+)
+
+// Cert is the latest certificate of a server checked from a source
+type Cert struct {
+	URL     string
+	LocalIP string
+	Time    time.Time // time of the check certificate was received by
+	db.Certificate
+}
+
+// Status returns certificate status at now, certificate is expiring if it
+// expires in less than warn days
+func (c *Cert) Status(now time.Time, warn int) string {
+	switch {
+	case !c.ChainValid || c.DaysLeft(now) < 0:
+		return CertInvalid
+	case !c.HostValid:
+		return CertMismatch
+	case c.DaysLeft(now) < warn:
+		return CertExpiring
+	}
+	return CertOK
+}
+
+// Certs returns the latest certificate of every server and source with
+// certificates in [from, to] time range, sorted by expiry, the soonest first
+func Certs(ctx context.Context, g db.RecordGetter, from, to time.Time, url []string) ([]Cert, error) {
+	var res []Cert
+	err := db.StreamRecords(ctx, g, from, to, url, func(r *db.Record) error {
+		if r.Cert == nil {
+			return nil
+		}
+		c := Cert{URL: r.URL, LocalIP: r.LocalIP, Time: r.Time, Certificate: *r.Cert}
+		if n := len(res); n > 0 && res[n-1].URL == r.URL && res[n-1].LocalIP == r.LocalIP {
+			res[n-1] = c
+		} else {
+			res = append(res, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Expiry.Before(res[j].Expiry)
+	})
+	return res, nil
+}
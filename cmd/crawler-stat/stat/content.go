@@ -0,0 +1,34 @@
+package stat
+
+import (
+	"context"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// Change is a content change flagged by a check
+type Change struct {
+	URL     string
+	LocalIP string
+	Time    time.Time
+	Hash    string // hash of the new content
+	db.ContentChange
+}
+
+// Changes returns content changes in [from, to] time range by URL and
+// source, in time order
+func Changes(ctx context.Context, g db.RecordGetter, from, to time.Time, url []string) ([]Change, error) {
+	var res []Change
+	err := db.StreamRecords(ctx, g, from, to, url, func(r *db.Record) error {
+		if r.Content != nil {
+			res = append(res, Change{URL: r.URL, LocalIP: r.LocalIP, Time: r.Time, Hash: r.ContentHash,
+				ContentChange: *r.Content})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,110 @@
+package stat
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// exactSamples is the number of latency samples percentiles are exact for,
+	// samples are folded into buckets afterwards
+	exactSamples = 1000
+	// bucketGrowth is the relative width of latency bucket, percentiles
+	// error is within half of it
+	bucketGrowth = 1.02
+)
+
+var logGrowth = math.Log(bucketGrowth)
+
+// Latency is a response time percentiles
+type Latency struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// rank returns nearest-rank index of p-th percentile among n samples
+func rank(n, p int) int {
+	r := (p*n + 99) / 100
+	if r < 1 {
+		r = 1
+	}
+	return r - 1
+}
+
+// latencies collects response times in bounded memory: samples are kept as
+// is until there are exactSamples of them, then they're counted in
+// logarithmic buckets
+type latencies struct {
+	exact   []time.Duration
+	buckets map[int]int
+	n       int
+}
+
+func bucket(d time.Duration) int {
+	if d < time.Microsecond {
+		return -1
+	}
+	return int(math.Log(float64(d/time.Microsecond)) / logGrowth)
+}
+
+// bucketValue returns bucket midpoint rounded to microseconds
+func bucketValue(b int) time.Duration {
+	if b < 0 {
+		return 0
+	}
+	return time.Duration(math.Pow(bucketGrowth, float64(b)+0.5)) * time.Microsecond
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.n++
+	if l.buckets == nil {
+		l.exact = append(l.exact, d)
+		if len(l.exact) <= exactSamples {
+			return
+		}
+		l.buckets = map[int]int{}
+		for _, e := range l.exact {
+			l.buckets[bucket(e)]++
+		}
+		l.exact = nil
+		return
+	}
+	l.buckets[bucket(d)]++
+}
+
+func (l *latencies) percentiles() Latency {
+	if l.n == 0 {
+		return Latency{}
+	}
+
+	if l.buckets == nil {
+		sorted := make([]time.Duration, len(l.exact))
+		copy(sorted, l.exact)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		return Latency{
+			P50: sorted[rank(l.n, 50)],
+			P90: sorted[rank(l.n, 90)],
+			P99: sorted[rank(l.n, 99)],
+		}
+	}
+
+	keys := make([]int, 0, len(l.buckets))
+	for b := range l.buckets {
+		keys = append(keys, b)
+	}
+	sort.Ints(keys)
+
+	value := func(p int) time.Duration {
+		r, seen := rank(l.n, p), 0
+		for _, b := range keys {
+			seen += l.buckets[b]
+			if seen > r {
+				return bucketValue(b)
+			}
+		}
+		return bucketValue(keys[len(keys)-1])
+	}
+	return Latency{P50: value(50), P90: value(90), P99: value(99)}
+}
@@ -0,0 +1,45 @@
+package stat
+
+import (
+	"time"
+)
+
+// SLO is an availability service level objective evaluation
+type SLO struct {
+	Target          float64       // availability objective, percent
+	Availability    float64       // actual availability, percent
+	Met             bool          // whether availability satisfies the objective
+	ErrorBudget     time.Duration // downtime allowed by the objective within whole time
+	BudgetRemaining time.Duration // error budget left, negative if it's exhausted
+	BurnRate        float64       // actual downtime to allowed downtime ratio, 1 means budget is spent exactly
+}
+
+// BudgetRemainingPercent returns remaining error budget in percents of the
+// whole budget
+func (o *SLO) BudgetRemainingPercent() float64 {
+	if o.ErrorBudget == 0 {
+		if o.BudgetRemaining < 0 {
+			return -100
+		}
+		return 0
+	}
+	return float64(o.BudgetRemaining*100) / float64(o.ErrorBudget)
+}
+
+// SLO evaluates availability objective target, e.g. 99.9
+func (s *Stat) SLO(target float64) SLO {
+	o := SLO{Target: target, Availability: 100}
+	if s.WholeTime > 0 {
+		o.Availability = float64(s.UpTime*100) / float64(s.WholeTime)
+	}
+	o.Met = o.Availability >= target
+
+	down := s.WholeTime - s.UpTime
+	// rounded, so float error doesn't show up in reports
+	o.ErrorBudget = time.Duration(float64(s.WholeTime) * (100 - target) / 100).Round(time.Millisecond)
+	o.BudgetRemaining = o.ErrorBudget - down
+	if o.ErrorBudget > 0 {
+		o.BurnRate = float64(down) / float64(o.ErrorBudget)
+	}
+	return o
+}
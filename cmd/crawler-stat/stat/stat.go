@@ -1,6 +1,7 @@
 package stat
 
 import (
+	"context"
 	"time"
 
 	"github.com/bpiddubnyi/crawler/db"
@@ -25,84 +26,226 @@ type Stat struct {
 	WholeTime   time.Duration // WholeTime shows total time data available for
 	UpTime      time.Duration
 	LongestDown *Interval
+	Failures    map[string]int // number of failed checks by failure reason
+	Latency     Latency        // response time of successful checks
+	Incidents   int            // number of downtime intervals
+	MTBF        time.Duration  // mean time between failures, zero if there were no incidents
+	MTTR        time.Duration  // mean time to recovery, zero if there were no incidents
 }
 
-type serverUptime struct {
+// add accounts interval in stats
+func (s *Stat) add(iv *Interval) {
+	s.WholeTime += iv.Duration()
+	if iv.Up {
+		s.UpTime += iv.Duration()
+		return
+	}
+	s.Incidents++
+	if s.LongestDown == nil || s.LongestDown.Duration() < iv.Duration() {
+		s.LongestDown = iv
+	}
+}
+
+// Timeline is a sequence of server uptime/downtime intervals
+type Timeline struct {
 	URL       string
 	LocalIP   string
 	Intervals []Interval
+	Failures  map[string]int // number of failed checks by failure reason
+	latencies latencies
+	latency   *Latency // precomputed by db, latencies are not collected then
+	folded    Stat     // stats of intervals that are not kept in Intervals
 }
 
-func (u *serverUptime) Stat() Stat {
-	s := Stat{URL: u.URL, LocalIP: u.LocalIP}
-	for i, iv := range u.Intervals {
-		s.WholeTime += iv.Duration()
-		if iv.Up {
-			s.UpTime += iv.Duration()
-		} else {
-			if s.LongestDown == nil || s.LongestDown.Duration() < iv.Duration() {
-				s.LongestDown = &u.Intervals[i]
-			}
-		}
+// addRecord collects latency of successful check or counts failed check by
+// its reason: failed assertion description or error class. Latency of
+// failures that weren't confirmed isn't collected.
+func (u *Timeline) addRecord(r *db.Record) {
+	if r.Up && len(r.Error) == 0 {
+		u.latencies.add(r.Duration)
+	}
+	reason := r.Assertion
+	if len(reason) == 0 {
+		reason = r.Error
+	}
+	if r.Up || len(reason) == 0 {
+		return
+	}
+	if u.Failures == nil {
+		u.Failures = map[string]int{}
+	}
+	u.Failures[reason]++
+}
+
+// Stat aggregates timeline intervals
+func (u *Timeline) Stat() Stat {
+	s := u.folded
+	s.URL, s.LocalIP, s.Failures = u.URL, u.LocalIP, u.Failures
+	if u.latency != nil {
+		s.Latency = *u.latency
+	} else {
+		s.Latency = u.latencies.percentiles()
+	}
+	for i := range u.Intervals {
+		s.add(&u.Intervals[i])
+	}
+	if s.Incidents > 0 {
+		s.MTBF = s.UpTime / time.Duration(s.Incidents)
+		s.MTTR = (s.WholeTime - s.UpTime) / time.Duration(s.Incidents)
 	}
 	return s
 }
 
-// Aggregate takes uptime log records from db, assuming that they're ordered by
-// url, local_ip, time asc and aggregates uptime stats from them.
-func Aggregate(recs []db.Record) []Stat {
-	if len(recs) == 0 {
-		return nil
+// Accumulator aggregates records one by one, records should be added ordered
+// by url, local_ip and time. Memory use per server doesn't depend on number
+// of records: latency percentiles are estimated once there are too many
+// samples and, unless intervals are kept, intervals are folded into stats as
+// soon as they end.
+type Accumulator struct {
+	keep       bool
+	res        []Timeline
+	cur        *Timeline
+	interval   *Interval
+	incomplete bool
+	closed     int // number of intervals of the current timeline
+}
+
+// NewAccumulator creates accumulator, if keepIntervals is false resulting
+// timelines have no Intervals and can only be used to get stats
+func NewAccumulator(keepIntervals bool) *Accumulator {
+	return &Accumulator{keep: keepIntervals}
+}
+
+// closeInterval adds current interval to the current timeline
+func (a *Accumulator) closeInterval() {
+	a.closed++
+	if a.keep {
+		a.cur.Intervals = append(a.cur.Intervals, *a.interval)
+		return
 	}
+	iv := *a.interval
+	a.cur.folded.add(&iv)
+}
 
-	var (
-		curUptime        *serverUptime
-		curInterval      *Interval
-		curIntIncomplete bool
-	)
+// finish completes current timeline, timelines with no intervals are skipped
+func (a *Accumulator) finish() {
+	if a.interval != nil && !a.incomplete {
+		a.closeInterval()
+	}
+	if a.closed > 0 {
+		a.res = append(a.res, *a.cur)
+	}
+	a.cur, a.interval, a.closed = nil, nil, 0
+}
 
+// Add adds next record
+func (a *Accumulator) Add(r *db.Record) {
+	if a.cur != nil && (a.cur.URL != r.URL || a.cur.LocalIP != r.LocalIP) {
+		a.finish()
+	}
+
+	if a.cur == nil {
+		a.cur = &Timeline{URL: r.URL, LocalIP: r.LocalIP, Intervals: []Interval{}}
+		a.interval = &Interval{Up: r.Up, From: r.Time}
+		a.incomplete = true
+		a.cur.addRecord(r)
+		return
+	}
+	a.cur.addRecord(r)
+
+	a.incomplete = false
+	a.interval.To = r.Time
+	if a.interval.Up != r.Up {
+		a.closeInterval()
+		a.interval = &Interval{Up: r.Up, From: r.Time}
+		a.incomplete = true
+	}
+}
+
+// Timelines completes accumulation and returns server timelines
+func (a *Accumulator) Timelines() []Timeline {
+	if a.cur != nil {
+		a.finish()
+	}
+	return a.res
+}
+
+// Timelines takes uptime log records from db, assuming that they're ordered by
+// url, local_ip, time asc and splits them into server uptime/downtime
+// intervals. Servers with less than two records have no intervals and are
+// skipped.
+func Timelines(recs []db.Record) []Timeline {
+	a := NewAccumulator(true)
+	for i := range recs {
+		a.Add(&recs[i])
+	}
+	return a.Timelines()
+}
+
+// Aggregate takes uptime log records from db, assuming that they're ordered by
+// url, local_ip, time asc and aggregates uptime stats from them.
+func Aggregate(recs []db.Record) []Stat {
 	var stat []Stat
-	for _, r := range recs {
-		if curUptime != nil && (curUptime.URL != r.URL || (curUptime.LocalIP != r.LocalIP)) {
-			if curInterval != nil && !curIntIncomplete {
-				curUptime.Intervals = append(curUptime.Intervals, *curInterval)
-			}
-			if len(curUptime.Intervals) != 0 {
-				stat = append(stat, curUptime.Stat())
-			}
+	for _, t := range Timelines(recs) {
+		stat = append(stat, t.Stat())
+	}
+	return stat
+}
 
-		}
+// Stream aggregates records streamed from g in [from, to] time range, see
+// Accumulator
+func Stream(ctx context.Context, g db.RecordGetter, from, to time.Time, url []string,
+	keepIntervals bool) ([]Timeline, error) {
+	a := NewAccumulator(keepIntervals)
+	err := db.StreamRecords(ctx, g, from, to, url, func(r *db.Record) error {
+		a.Add(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.Timelines(), nil
+}
 
-		if curUptime == nil || (curUptime.URL != r.URL || (curUptime.LocalIP != r.LocalIP)) {
-			curUptime = &serverUptime{URL: r.URL, LocalIP: r.LocalIP, Intervals: []Interval{}}
-			curInterval = &Interval{Up: r.Up, From: r.Time}
-			curIntIncomplete = true
+// FromAggregator builds timelines from intervals and summaries aggregated by
+// db, so records don't have to be loaded into memory
+func FromAggregator(a db.Aggregator, from, to time.Time, url ...string) ([]Timeline, error) {
+	ivs, err := a.GetIntervals(from, to, url...)
+	if err != nil {
+		return nil, err
+	}
+	sums, err := a.GetSummaries(from, to, url...)
+	if err != nil {
+		return nil, err
+	}
 
-			continue
-		}
+	bySource := make(map[[2]string]*db.Summary, len(sums))
+	for i := range sums {
+		bySource[[2]string{sums[i].URL, sums[i].LocalIP}] = &sums[i]
+	}
 
-		if curInterval.Up == r.Up {
-			if curIntIncomplete {
-				curIntIncomplete = false
-			}
-			curInterval.To = r.Time
-		} else {
-			if curIntIncomplete {
-				curIntIncomplete = false
-			}
-			curInterval.To = r.Time
-			curUptime.Intervals = append(curUptime.Intervals, *curInterval)
-
-			curInterval = &Interval{Up: r.Up, From: r.Time}
-			curIntIncomplete = true
+	res := FromIntervals(ivs)
+	for i := range res {
+		t := &res[i]
+		t.latency = &Latency{}
+		if s, ok := bySource[[2]string{t.URL, t.LocalIP}]; ok {
+			t.Failures = s.Failures
+			t.latency = &Latency{P50: s.P50, P90: s.P90, P99: s.P99}
 		}
 	}
+	return res, nil
+}
 
-	if curInterval != nil && !curIntIncomplete {
-		curUptime.Intervals = append(curUptime.Intervals, *curInterval)
-	}
-	if len(curUptime.Intervals) != 0 {
-		stat = append(stat, curUptime.Stat())
+// FromIntervals groups intervals aggregated by db, ordered by url, local_ip
+// and time, into timelines. Timelines have no failures and latency.
+func FromIntervals(ivs []db.Interval) []Timeline {
+	var res []Timeline
+	for _, iv := range ivs {
+		if len(res) == 0 || res[len(res)-1].URL != iv.URL || res[len(res)-1].LocalIP != iv.LocalIP {
+			res = append(res, Timeline{URL: iv.URL, LocalIP: iv.LocalIP, Intervals: []Interval{}})
+		}
+		t := &res[len(res)-1]
+		t.Intervals = append(t.Intervals, Interval{Up: iv.Up, From: iv.From, To: iv.To})
 	}
-	return stat
+	return res
 }
@@ -1,11 +1,14 @@
 package stat
 
 import (
+	"context"
+	"math/rand"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/bpiddubnyi/crawler/db"
+	"github.com/bpiddubnyi/crawler/db/memory"
 )
 
 const timeFormat = "02.01.2006 15:04:05"
@@ -95,6 +98,8 @@ func TestAggregate(t *testing.T) {
 						From: getTime("01.01.1972 00:00:00", t),
 						To:   getTime("01.01.1972 00:02:00", t),
 					},
+					Incidents: 1,
+					MTTR:      2 * time.Minute,
 				},
 			},
 		},
@@ -162,6 +167,9 @@ func TestAggregate(t *testing.T) {
 						From: getTime("01.01.1972 00:00:00", t),
 						To:   getTime("01.01.1972 00:02:00", t),
 					},
+					Incidents: 1,
+					MTBF:      1 * time.Minute,
+					MTTR:      2 * time.Minute,
 				},
 				{
 					URL:       "http://shmest.com",
@@ -172,6 +180,63 @@ func TestAggregate(t *testing.T) {
 						From: getTime("01.01.1972 00:02:00", t),
 						To:   getTime("01.01.1972 00:03:00", t),
 					},
+					Incidents: 1,
+					MTBF:      2 * time.Minute,
+					MTTR:      1 * time.Minute,
+				},
+			},
+		},
+		{
+			name: "failure reasons",
+			args: args{
+				recs: []db.Record{
+					{
+						URL:     "http://test.com",
+						Up:      false,
+						LocalIP: "127.0.0.1",
+						Time:    getTime("01.01.1972 00:00:00", t),
+						Error:   "dns",
+					},
+					{
+						URL:       "http://test.com",
+						Up:        false,
+						LocalIP:   "127.0.0.1",
+						Time:      getTime("01.01.1972 00:01:00", t),
+						Error:     "status",
+						Assertion: "status 503 not in 200-299",
+					},
+					{
+						URL:     "http://test.com",
+						Up:      true,
+						LocalIP: "127.0.0.1",
+						Time:    getTime("01.01.1972 00:02:00", t),
+					},
+					{
+						URL:     "http://test.com",
+						Up:      false,
+						LocalIP: "127.0.0.1",
+						Time:    getTime("01.01.1972 00:03:00", t),
+						Error:   "dns",
+					},
+				},
+			},
+			want: []Stat{
+				{
+					URL:       "http://test.com",
+					LocalIP:   "127.0.0.1",
+					WholeTime: 3 * time.Minute,
+					UpTime:    1 * time.Minute,
+					LongestDown: &Interval{
+						From: getTime("01.01.1972 00:00:00", t),
+						To:   getTime("01.01.1972 00:02:00", t),
+					},
+					Failures: map[string]int{
+						"dns":                       2,
+						"status 503 not in 200-299": 1,
+					},
+					Incidents: 1,
+					MTBF:      1 * time.Minute,
+					MTTR:      2 * time.Minute,
 				},
 			},
 		},
@@ -184,3 +249,306 @@ func TestAggregate(t *testing.T) {
 		})
 	}
 }
+
+func TestLatency(t *testing.T) {
+	var recs []db.Record
+	start := getTime("01.01.1972 00:00:00", t)
+	for i := 1; i <= 100; i++ {
+		recs = append(recs, db.Record{
+			URL:      "http://test.com",
+			Up:       i%10 != 0,
+			LocalIP:  "127.0.0.1",
+			Time:     start.Add(time.Duration(i) * time.Minute),
+			Duration: time.Duration(i) * time.Millisecond,
+		})
+	}
+
+	stats := Aggregate(recs)
+	if len(stats) != 1 {
+		t.Fatalf("Aggregate() returned %d stats, want 1", len(stats))
+	}
+	want := Latency{P50: 49 * time.Millisecond, P90: 89 * time.Millisecond, P99: 99 * time.Millisecond}
+	if stats[0].Latency != want {
+		t.Errorf("Latency = %+v, want %+v", stats[0].Latency, want)
+	}
+	if stats[0].Incidents != 9 {
+		t.Errorf("Incidents = %d, want 9", stats[0].Incidents)
+	}
+}
+
+func TestLatenciesBounded(t *testing.T) {
+	var l latencies
+	for i := 0; i < 100000; i++ {
+		l.add(time.Duration(1+i%1000) * time.Millisecond)
+	}
+	if l.exact != nil || len(l.buckets) > 500 {
+		t.Fatalf("%d exact samples and %d buckets are kept", len(l.exact), len(l.buckets))
+	}
+
+	// percentiles are estimated within bucket precision
+	got := l.percentiles()
+	for _, p := range []struct {
+		got, want time.Duration
+	}{{got.P50, 500 * time.Millisecond}, {got.P90, 900 * time.Millisecond}, {got.P99, 990 * time.Millisecond}} {
+		if diff := p.got - p.want; diff < -p.want/50 || diff > p.want/50 {
+			t.Errorf("latency percentile = %s, want about %s", p.got, p.want)
+		}
+	}
+}
+
+func TestSLO(t *testing.T) {
+	s := Stat{WholeTime: 1000 * time.Minute, UpTime: 999*time.Minute + 30*time.Second}
+
+	o := s.SLO(99.9)
+	if !o.Met || o.ErrorBudget != time.Minute || o.BudgetRemaining != 30*time.Second ||
+		o.BurnRate != 0.5 || o.BudgetRemainingPercent() != 50 {
+		t.Errorf("SLO(99.9) = %+v", o)
+	}
+
+	o = s.SLO(99.99)
+	if o.Met || o.BudgetRemaining >= 0 || o.BurnRate != 5 {
+		t.Errorf("SLO(99.99) = %+v", o)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	kyiv := time.FixedZone("EET", 2*60*60)
+	tl := Timeline{
+		Intervals: []Interval{
+			// 2017-12-31 23:00 - 2018-01-01 01:00 in EET, Sunday to Monday
+			{Up: true, From: getTime("31.12.2017 21:00:00", t), To: getTime("31.12.2017 22:30:00", t)},
+			{Up: false, From: getTime("31.12.2017 22:30:00", t), To: getTime("31.12.2017 23:00:00", t)},
+		},
+	}
+
+	tests := []struct {
+		period Period
+		want   []Bucket
+	}{
+		{
+			period: Hour,
+			want: []Bucket{
+				{From: getTime("31.12.2017 21:00:00", t), To: getTime("31.12.2017 22:00:00", t),
+					WholeTime: time.Hour, UpTime: time.Hour},
+				{From: getTime("31.12.2017 22:00:00", t), To: getTime("31.12.2017 23:00:00", t),
+					WholeTime: time.Hour, UpTime: 30 * time.Minute},
+			},
+		},
+		{
+			period: Day,
+			want: []Bucket{
+				{From: getTime("30.12.2017 22:00:00", t), To: getTime("31.12.2017 22:00:00", t),
+					WholeTime: time.Hour, UpTime: time.Hour},
+				{From: getTime("31.12.2017 22:00:00", t), To: getTime("01.01.2018 22:00:00", t),
+					WholeTime: time.Hour, UpTime: 30 * time.Minute},
+			},
+		},
+		{
+			period: Week,
+			want: []Bucket{
+				{From: getTime("24.12.2017 22:00:00", t), To: getTime("31.12.2017 22:00:00", t),
+					WholeTime: time.Hour, UpTime: time.Hour},
+				{From: getTime("31.12.2017 22:00:00", t), To: getTime("07.01.2018 22:00:00", t),
+					WholeTime: time.Hour, UpTime: 30 * time.Minute},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := tl.Buckets(tt.period, kyiv)
+		if len(got) != len(tt.want) {
+			t.Fatalf("Buckets(%d) = %+v, want %+v", tt.period, got, tt.want)
+		}
+		for i := range got {
+			w := tt.want[i]
+			if !got[i].From.Equal(w.From) || !got[i].To.Equal(w.To) || got[i].WholeTime != w.WholeTime ||
+				got[i].UpTime != w.UpTime {
+				t.Errorf("Buckets(%d)[%d] = %+v, want %+v", tt.period, i, got[i], w)
+			}
+		}
+	}
+}
+
+func TestBucketsDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("No tz database: %s", err)
+	}
+	utc := func(s string) time.Time { return getTime(s, t) }
+
+	tests := []struct {
+		name   string
+		iv     Interval
+		period Period
+		want   [][2]time.Time
+	}{
+		{
+			// clocks go back from 02:00 EDT to 01:00 EST at 06:00 UTC
+			name:   "fall back hours",
+			iv:     Interval{Up: true, From: utc("05.11.2017 04:30:00"), To: utc("05.11.2017 07:30:00")},
+			period: Hour,
+			want: [][2]time.Time{
+				{utc("05.11.2017 04:00:00"), utc("05.11.2017 05:00:00")},
+				{utc("05.11.2017 05:00:00"), utc("05.11.2017 06:00:00")},
+				{utc("05.11.2017 06:00:00"), utc("05.11.2017 07:00:00")},
+				{utc("05.11.2017 07:00:00"), utc("05.11.2017 08:00:00")},
+			},
+		},
+		{
+			name:   "fall back day",
+			iv:     Interval{Up: true, From: utc("05.11.2017 04:30:00"), To: utc("05.11.2017 07:30:00")},
+			period: Day,
+			want:   [][2]time.Time{{utc("05.11.2017 04:00:00"), utc("06.11.2017 05:00:00")}},
+		},
+		{
+			// clocks go forward from 02:00 EST to 03:00 EDT at 07:00 UTC
+			name:   "spring forward hours",
+			iv:     Interval{Up: true, From: utc("12.03.2017 06:30:00"), To: utc("12.03.2017 08:30:00")},
+			period: Hour,
+			want: [][2]time.Time{
+				{utc("12.03.2017 06:00:00"), utc("12.03.2017 07:00:00")},
+				{utc("12.03.2017 07:00:00"), utc("12.03.2017 08:00:00")},
+				{utc("12.03.2017 08:00:00"), utc("12.03.2017 09:00:00")},
+			},
+		},
+		{
+			name:   "spring forward day",
+			iv:     Interval{Up: true, From: utc("12.03.2017 06:30:00"), To: utc("12.03.2017 08:30:00")},
+			period: Day,
+			want:   [][2]time.Time{{utc("12.03.2017 05:00:00"), utc("13.03.2017 04:00:00")}},
+		},
+	}
+
+	for _, tt := range tests {
+		tl := Timeline{Intervals: []Interval{tt.iv}}
+		got := tl.Buckets(tt.period, ny)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: Buckets() = %+v, want %v", tt.name, got, tt.want)
+		}
+		var whole time.Duration
+		for i, b := range got {
+			if !b.From.Equal(tt.want[i][0]) || !b.To.Equal(tt.want[i][1]) {
+				t.Errorf("%s: bucket %d is %s - %s, want %s - %s", tt.name, i, b.From.UTC(), b.To.UTC(),
+					tt.want[i][0], tt.want[i][1])
+			}
+			whole += b.WholeTime
+		}
+		if whole != tt.iv.Duration() {
+			t.Errorf("%s: buckets whole time %s, want %s", tt.name, whole, tt.iv.Duration())
+		}
+	}
+}
+
+func TestAccumulator(t *testing.T) {
+	var (
+		rnd   = rand.New(rand.NewSource(1))
+		start = getTime("01.01.1972 00:00:00", t)
+		recs  []db.Record
+	)
+	for _, url := range []string{"http://a", "http://b", "http://c"} {
+		n := 5000
+		if url == "http://b" {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			recs = append(recs, db.Record{
+				URL:      url,
+				LocalIP:  "127.0.0.1",
+				Time:     start.Add(time.Duration(i) * time.Minute),
+				Up:       rnd.Intn(5) > 0,
+				Duration: time.Duration(1+rnd.Intn(1000)) * time.Millisecond,
+			})
+		}
+	}
+
+	want := Aggregate(recs)
+	a := NewAccumulator(false)
+	for i := range recs {
+		a.Add(&recs[i])
+	}
+	var got []Stat
+	for _, tl := range a.Timelines() {
+		if len(tl.Intervals) != 0 {
+			t.Errorf("%s intervals are kept", tl.URL)
+		}
+		got = append(got, tl.Stat())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Accumulator stats = %+v, want %+v", got, want)
+	}
+
+	// latencies are uniform in [1ms, 1s], so percentiles are estimated
+	// within bucket precision
+	l := got[0].Latency
+	for _, p := range []struct {
+		got, want time.Duration
+	}{{l.P50, 500 * time.Millisecond}, {l.P90, 900 * time.Millisecond}, {l.P99, 990 * time.Millisecond}} {
+		if diff := p.got - p.want; diff < -p.want/20 || diff > p.want/20 {
+			t.Errorf("latency percentile = %s, want about %s", p.got, p.want)
+		}
+	}
+}
+
+func TestCerts(t *testing.T) {
+	var (
+		start = getTime("01.01.1972 00:00:00", t)
+		m     = memory.New()
+		cert  = func(days int, chain, host bool) *db.Certificate {
+			return &db.Certificate{Expiry: start.AddDate(0, 0, days), ChainValid: chain, HostValid: host}
+		}
+	)
+	m.Add(
+		db.Record{URL: "https://a", LocalIP: "10.0.0.1", Time: start, Cert: cert(5, true, true)},
+		// renewed certificate replaces the old one
+		db.Record{URL: "https://a", LocalIP: "10.0.0.1", Time: start.Add(time.Minute), Cert: cert(90, true, true)},
+		db.Record{URL: "https://a", LocalIP: "10.0.0.2", Time: start, Cert: cert(10, true, false)},
+		db.Record{URL: "https://b", LocalIP: "10.0.0.1", Time: start, Cert: cert(-1, true, true)},
+		db.Record{URL: "tcp://c:22", LocalIP: "10.0.0.1", Time: start},
+	)
+
+	certs, err := Certs(context.Background(), m, start, start.Add(time.Hour), nil)
+	if err != nil {
+		t.Fatalf("Certs() error = %s", err)
+	}
+
+	want := []struct {
+		url, source, status string
+	}{
+		{"https://b", "10.0.0.1", CertInvalid},
+		{"https://a", "10.0.0.2", CertMismatch},
+		{"https://a", "10.0.0.1", CertOK},
+	}
+	if len(certs) != len(want) {
+		t.Fatalf("Certs() = %+v, want %d certificates", certs, len(want))
+	}
+	for i, w := range want {
+		c := certs[i]
+		if c.URL != w.url || c.LocalIP != w.source || c.Status(start, 14) != w.status {
+			t.Errorf("certificate %d: %s from %s %s, want %+v", i, c.URL, c.LocalIP, c.Status(start, 14), w)
+		}
+	}
+	if s := certs[2].Status(start, 100); s != CertExpiring {
+		t.Errorf("status with 100 days warning = %s, want %s", s, CertExpiring)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	start := getTime("01.01.1972 00:00:00", t)
+	m := memory.New()
+	m.Add(
+		db.Record{URL: "https://a", LocalIP: "10.0.0.1", Time: start, ContentHash: "1"},
+		db.Record{URL: "https://a", LocalIP: "10.0.0.1", Time: start.Add(time.Minute), ContentHash: "2",
+			Content: &db.ContentChange{Kind: db.ContentChanged, Previous: "1"}},
+		db.Record{URL: "https://a", LocalIP: "10.0.0.2", Time: start, ContentHash: "3",
+			Content: &db.ContentChange{Kind: db.ContentDiffers, Previous: "1"}},
+	)
+
+	changes, err := Changes(context.Background(), m, start, start.Add(time.Hour), nil)
+	if err != nil {
+		t.Fatalf("Changes() error = %s", err)
+	}
+	if len(changes) != 2 || changes[0].Hash != "2" || changes[0].Kind != db.ContentChanged ||
+		changes[1].LocalIP != "10.0.0.2" || changes[1].Kind != db.ContentDiffers {
+		t.Errorf("Changes() = %+v, want changed and differs", changes)
+	}
+}
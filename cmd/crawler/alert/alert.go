@@ -0,0 +1,306 @@
+// Package alert tracks targets state from check records and notifies about
+// its changes
+package alert
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// Event kinds
+const (
+	KindDown     = "down"
+	KindUp       = "up"
+	KindReminder = "reminder"
+	KindCert     = "cert_expiry"
+	KindContent  = "content"
+)
+
+// queueSize is a size of records and per notifier events queues, records and
+// events are dropped if queue is full
+const queueSize = 1000
+
+// Event is a target state change notification
+type Event struct {
+	Kind       string    `json:"kind"`
+	URL        string    `json:"url"`
+	Source     string    `json:"source"`
+	Time       time.Time `json:"time"`
+	Since      time.Time `json:"since"`            // time of the first failed check
+	Reason     string    `json:"reason,omitempty"` // last failed assertion or error class
+	StatusCode int       `json:"status_code,omitempty"`
+	Failures   int       `json:"failures"` // number of consecutive failed checks
+	Cert       *Cert     `json:"cert,omitempty"`
+	Content    *Content  `json:"content,omitempty"`
+}
+
+// Cert is a certificate expiry warning details
+type Cert struct {
+	Expiry   time.Time `json:"expiry"`
+	DaysLeft int       `json:"days_left"`
+	Issuer   string    `json:"issuer"`
+}
+
+// Content is a content change details, Before and After are snippets around
+// the first difference
+type Content struct {
+	Change   string `json:"change"` // db.ContentChanged or db.ContentDiffers
+	Hash     string `json:"hash"`
+	Previous string `json:"previous"` // hash of the content compared with
+	Before   string `json:"before"`
+	After    string `json:"after"`
+}
+
+// Subject returns short event description
+func (e *Event) Subject() string {
+	switch e.Kind {
+	case KindDown:
+		return fmt.Sprintf("DOWN %s from %s", e.URL, e.Source)
+	case KindUp:
+		return fmt.Sprintf("UP %s from %s after %s", e.URL, e.Source, e.Time.Sub(e.Since))
+	case KindCert:
+		if e.Cert.DaysLeft < 0 {
+			return fmt.Sprintf("CERT EXPIRED %s from %s", e.URL, e.Source)
+		}
+		return fmt.Sprintf("CERT EXPIRES %s from %s in %d days", e.URL, e.Source, e.Cert.DaysLeft)
+	case KindContent:
+		if e.Content.Change == db.ContentDiffers {
+			return fmt.Sprintf("CONTENT DIFFERS %s from %s", e.URL, e.Source)
+		}
+		return fmt.Sprintf("CONTENT CHANGED %s from %s", e.URL, e.Source)
+	}
+	return fmt.Sprintf("STILL DOWN %s from %s for %s", e.URL, e.Source, e.Time.Sub(e.Since))
+}
+
+// Text returns full event description
+func (e *Event) Text() string {
+	if e.Kind == KindCert {
+		return fmt.Sprintf("%s\n\nExpires: %s\nIssuer: %s\nTime: %s\n", e.Subject(),
+			e.Cert.Expiry.Format(time.RFC1123), e.Cert.Issuer, e.Time.Format(time.RFC1123))
+	}
+	if e.Kind == KindContent {
+		return fmt.Sprintf("%s\n\nTime: %s\nHash: %s\nPrevious hash: %s\n\nBefore:\n%s\n\nAfter:\n%s\n",
+			e.Subject(), e.Time.Format(time.RFC1123), e.Content.Hash, e.Content.Previous,
+			e.Content.Before, e.Content.After)
+	}
+	text := fmt.Sprintf("%s\n\nDown since: %s\nTime: %s\nFailed checks: %d\n",
+		e.Subject(), e.Since.Format(time.RFC1123), e.Time.Format(time.RFC1123), e.Failures)
+	if len(e.Reason) > 0 {
+		text += fmt.Sprintf("Reason: %s\n", e.Reason)
+	}
+	if e.StatusCode != 0 {
+		text += fmt.Sprintf("Status code: %d\n", e.StatusCode)
+	}
+	return text
+}
+
+// state is a per URL and source target state
+type state struct {
+	down     bool
+	fails    int
+	succs    int
+	since    time.Time
+	notified time.Time
+	reason   string
+	status   int
+	expiry   time.Time // expiry of the certificate warned about
+}
+
+// Manager consumes check records, detects target state transitions and
+// delivers events to the configured notifiers
+type Manager struct {
+	cfg       *Config
+	notifiers []Notifier
+	rC        chan *db.Record
+	states    map[string]*state
+}
+
+// New creates alert manager
+func New(cfg *Config) *Manager {
+	return &Manager{
+		cfg:       cfg,
+		notifiers: newNotifiers(cfg),
+		rC:        make(chan *db.Record, queueSize),
+		states:    map[string]*state{},
+	}
+}
+
+// Observe queues check record for processing, it never blocks
+func (m *Manager) Observe(r *db.Record) {
+	select {
+	case m.rC <- r:
+	default:
+		log.Printf("Warning: Alert queue is full, dropping %s record\n", r.URL)
+	}
+}
+
+// state returns record target state, creating it if needed
+func (m *Manager) state(r *db.Record) *state {
+	key := r.URL + " " + r.LocalIP
+	s, ok := m.states[key]
+	if !ok {
+		s = &state{}
+		m.states[key] = s
+	}
+	return s
+}
+
+// process updates target state with check record and returns event if state
+// has changed or reminder is due. Target goes down after cfg.Failures
+// consecutive failures and up again after cfg.Recoveries consecutive
+// successes, so single flapping checks don't cause alerts.
+func (m *Manager) process(r *db.Record) *Event {
+	s := m.state(r)
+
+	var kind string
+	if !r.Up {
+		s.succs = 0
+		if s.fails == 0 {
+			s.since = r.Time
+		}
+		s.fails++
+		s.reason, s.status = r.Assertion, r.StatusCode
+		if len(s.reason) == 0 {
+			s.reason = r.Error
+		}
+
+		if !s.down && s.fails >= m.cfg.Failures {
+			s.down = true
+			kind = KindDown
+		} else if s.down && m.cfg.Remind > 0 && r.Time.Sub(s.notified) >= m.cfg.Remind {
+			kind = KindReminder
+		}
+	} else {
+		s.succs++
+		if !s.down {
+			s.fails = 0
+		} else if s.succs >= m.cfg.Recoveries {
+			s.down = false
+			kind = KindUp
+		}
+	}
+
+	if len(kind) == 0 {
+		return nil
+	}
+
+	s.notified = r.Time
+	e := &Event{
+		Kind:       kind,
+		URL:        r.URL,
+		Source:     r.LocalIP,
+		Time:       r.Time,
+		Since:      s.since,
+		Reason:     s.reason,
+		StatusCode: s.status,
+		Failures:   s.fails,
+	}
+	if kind == KindUp {
+		s.fails = 0
+	}
+	return e
+}
+
+// certEvent returns warning if certificate of the check record expires in
+// cfg.CertExpiry days or less, once per certificate
+func (m *Manager) certEvent(r *db.Record) *Event {
+	c := r.Cert
+	if m.cfg.CertExpiry == 0 || c == nil {
+		return nil
+	}
+	left := c.DaysLeft(r.Time)
+	if left >= m.cfg.CertExpiry {
+		return nil
+	}
+
+	s := m.state(r)
+	if s.expiry.Equal(c.Expiry) {
+		return nil
+	}
+	s.expiry = c.Expiry
+	return &Event{
+		Kind:   KindCert,
+		URL:    r.URL,
+		Source: r.LocalIP,
+		Time:   r.Time,
+		Since:  r.Time,
+		Cert:   &Cert{Expiry: c.Expiry, DaysLeft: left, Issuer: c.Issuer},
+	}
+}
+
+// contentEvent returns notification if the check record is flagged with
+// content change, records are flagged once per change by the client
+func (m *Manager) contentEvent(r *db.Record) *Event {
+	c := r.Content
+	if c == nil {
+		return nil
+	}
+	return &Event{
+		Kind:   KindContent,
+		URL:    r.URL,
+		Source: r.LocalIP,
+		Time:   r.Time,
+		Since:  r.Time,
+		Content: &Content{
+			Change:   c.Kind,
+			Hash:     r.ContentHash,
+			Previous: c.Previous,
+			Before:   c.Before,
+			After:    c.After,
+		},
+	}
+}
+
+// Run processes observed records until shutdownC is closed, events are
+// delivered asynchronously by every notifier in the order they occurred
+func (m *Manager) Run(shutdownC <-chan struct{}) {
+	var (
+		wg     sync.WaitGroup
+		queues = make([]chan *Event, len(m.notifiers))
+	)
+
+	for i, n := range m.notifiers {
+		queues[i] = make(chan *Event, queueSize)
+		wg.Add(1)
+		go func(n Notifier, eC <-chan *Event) {
+			defer wg.Done()
+			for e := range eC {
+				if err := n.Notify(e); err != nil {
+					log.Printf("Error: Failed to deliver %q alert: %s\n", e.Subject(), err)
+				}
+			}
+		}(n, queues[i])
+	}
+
+theLoop:
+	for {
+		select {
+		case <-shutdownC:
+			break theLoop
+		case r := <-m.rC:
+			for _, e := range []*Event{m.process(r), m.certEvent(r), m.contentEvent(r)} {
+				if e == nil {
+					continue
+				}
+
+				log.Printf("Info: Alert: %s\n", e.Subject())
+				for _, q := range queues {
+					select {
+					case q <- e:
+					default:
+						log.Printf("Warning: Alert delivery queue is full, dropping %q alert\n", e.Subject())
+					}
+				}
+			}
+		}
+	}
+
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,137 @@
+package alert
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+func TestProcess(t *testing.T) {
+	m := New(&Config{Failures: 2, Recoveries: 2, Remind: 3 * time.Minute})
+	base := time.Date(1972, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Up/down sequence for a single target, one check per minute
+	checks := []struct {
+		up   bool
+		want string
+	}{
+		{true, ""},
+		{false, ""}, // single failure is damped
+		{true, ""},
+		{false, ""},
+		{false, KindDown},
+		{true, ""}, // single success is damped
+		{false, ""},
+		{false, KindReminder}, // 3 minutes after down event
+		{false, ""},
+		{true, ""},
+		{true, KindUp},
+		{false, ""},
+		{true, ""},
+	}
+
+	for i, c := range checks {
+		r := &db.Record{URL: "http://test.com", LocalIP: "127.0.0.1", Up: c.up, Time: base.Add(time.Duration(i) * time.Minute)}
+		if !c.up {
+			r.Error = "dns"
+		}
+
+		e := m.process(r)
+		kind := ""
+		if e != nil {
+			kind = e.Kind
+		}
+		if kind != c.want {
+			t.Fatalf("check %d: got %q event, want %q", i, kind, c.want)
+		}
+
+		if e != nil && !e.Since.Equal(base.Add(3*time.Minute)) {
+			t.Errorf("check %d: event since %s, want first failure time", i, e.Since)
+		}
+		if e != nil && e.Kind == KindUp && e.Failures != 5 {
+			t.Errorf("check %d: up event has %d failures, want 5", i, e.Failures)
+		}
+	}
+
+	// Other source of the same URL has its own state
+	e := m.process(&db.Record{URL: "http://test.com", LocalIP: "127.0.0.2", Time: base})
+	if e != nil {
+		t.Errorf("got %q event for the first failure from another source", e.Kind)
+	}
+}
+
+func TestCertEvent(t *testing.T) {
+	m := New(&Config{Failures: 1, Recoveries: 1, CertExpiry: 14})
+	base := time.Date(1972, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	checks := []struct {
+		expiry time.Time
+		want   bool
+	}{
+		{base.AddDate(0, 1, 0), false},
+		{base.AddDate(0, 0, 10), true},
+		{base.AddDate(0, 0, 10), false}, // warned once per certificate
+		{base.AddDate(0, 0, 12), true},  // renewed, but still expires soon
+		{base.AddDate(0, 0, -1), true},
+	}
+	for i, c := range checks {
+		r := &db.Record{URL: "https://test.com", LocalIP: "127.0.0.1", Up: true, Time: base,
+			Cert: &db.Certificate{Expiry: c.expiry}}
+		e := m.certEvent(r)
+		if (e != nil) != c.want {
+			t.Fatalf("check %d: got event %+v, want %t", i, e, c.want)
+		}
+		if e != nil && (e.Kind != KindCert || !e.Cert.Expiry.Equal(c.expiry)) {
+			t.Errorf("check %d: unexpected event %+v", i, e)
+		}
+	}
+	if e := m.certEvent(&db.Record{URL: "http://test.com", Time: base}); e != nil {
+		t.Errorf("got event %+v for record without certificate", e)
+	}
+}
+
+func TestContentEvent(t *testing.T) {
+	m := New(&Config{Failures: 1, Recoveries: 1})
+	r := &db.Record{URL: "http://test.com", LocalIP: "127.0.0.1", Up: true, ContentHash: "b"}
+	if e := m.contentEvent(r); e != nil {
+		t.Errorf("got event %+v for record without content change", e)
+	}
+
+	r.Content = &db.ContentChange{Kind: db.ContentDiffers, Previous: "a", Before: "old", After: "new"}
+	e := m.contentEvent(r)
+	if e == nil || e.Kind != KindContent || e.Content.Hash != "b" || e.Content.Previous != "a" {
+		t.Fatalf("got event %+v, want content event", e)
+	}
+	if want := "CONTENT DIFFERS http://test.com from 127.0.0.1"; e.Subject() != want {
+		t.Errorf("Subject() = %q, want %q", e.Subject(), want)
+	}
+	if text := e.Text(); !strings.Contains(text, "old") || !strings.Contains(text, "new") {
+		t.Errorf("Text() = %q, want snippets", text)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(`
+failures: 3
+remind: 1h
+cert_expiry: 14
+webhooks:
+  - url: http://example.com/hook
+commands: [echo]
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %s", err)
+	}
+	if cfg.Failures != 3 || cfg.Recoveries != 1 || cfg.Remind != time.Hour || cfg.CertExpiry != 14 ||
+		len(cfg.Webhooks) != 1 || len(cfg.Commands) != 1 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	for _, bad := range []string{"failures: 3\n", "remind: soon\ncommands: [echo]\n", "command: echo\n"} {
+		if _, err = ParseConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseConfig(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package alert
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Config is an alerting configuration
+type Config struct {
+	Failures   int           // consecutive failures before target is considered down
+	Recoveries int           // consecutive successes before target is considered up again
+	Remind     time.Duration // reminder interval while target is down, zero disables reminders
+	CertExpiry int           // days before certificate expiry to warn at, zero disables warnings
+	Webhooks   []Webhook
+	SMTP       *SMTP
+	Commands   []string
+}
+
+// Webhook is a URL event JSON is POSTed to
+type Webhook struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// SMTP is an email delivery configuration
+type SMTP struct {
+	Addr     string   `yaml:"addr"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+type configSpec struct {
+	Failures   int       `yaml:"failures"`
+	Recoveries int       `yaml:"recoveries"`
+	Remind     string    `yaml:"remind"`
+	CertExpiry int       `yaml:"cert_expiry"`
+	Webhooks   []Webhook `yaml:"webhooks"`
+	SMTP       *SMTP     `yaml:"smtp"`
+	Commands   []string  `yaml:"commands"`
+}
+
+// ParseConfig parses YAML or JSON alerting config:
+//
+//	failures: 3      # 1 by default
+//	recoveries: 2    # 1 by default
+//	remind: 1h
+//	cert_expiry: 14  # days
+//	webhooks:
+//	  - url: https://hooks.example.com/crawler
+//	    headers: {Authorization: Bearer secret}
+//	smtp:
+//	  addr: smtp.example.com:587
+//	  username: crawler
+//	  password: secret
+//	  from: crawler@example.com
+//	  to: [ops@example.com]
+//	commands:
+//	  - /usr/local/bin/page-oncall
+func ParseConfig(r io.Reader) (*Config, error) {
+	var spec configSpec
+
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Failures:   spec.Failures,
+		Recoveries: spec.Recoveries,
+		CertExpiry: spec.CertExpiry,
+		Webhooks:   spec.Webhooks,
+		SMTP:       spec.SMTP,
+		Commands:   spec.Commands,
+	}
+	if cfg.Failures == 0 {
+		cfg.Failures = 1
+	}
+	if cfg.Recoveries == 0 {
+		cfg.Recoveries = 1
+	}
+	if cfg.Failures < 0 || cfg.Recoveries < 0 {
+		return nil, fmt.Errorf("failures and recoveries should be positive")
+	}
+	if cfg.CertExpiry < 0 {
+		return nil, fmt.Errorf("cert_expiry should not be negative")
+	}
+
+	if len(spec.Remind) > 0 {
+		var err error
+		if cfg.Remind, err = time.ParseDuration(spec.Remind); err != nil {
+			return nil, fmt.Errorf("invalid remind interval %q", spec.Remind)
+		}
+	}
+
+	for _, w := range cfg.Webhooks {
+		if len(w.URL) == 0 {
+			return nil, fmt.Errorf("webhook url is empty")
+		}
+	}
+	if cfg.SMTP != nil && (len(cfg.SMTP.Addr) == 0 || len(cfg.SMTP.From) == 0 || len(cfg.SMTP.To) == 0) {
+		return nil, fmt.Errorf("smtp addr, from and to should be set")
+	}
+	if len(cfg.Webhooks) == 0 && cfg.SMTP == nil && len(cfg.Commands) == 0 {
+		return nil, fmt.Errorf("no webhooks, smtp or commands configured")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,129 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// deliveryTimeout limits single notification delivery time
+const deliveryTimeout = 30 * time.Second
+
+// Notifier delivers events
+type Notifier interface {
+	Notify(e *Event) error
+}
+
+type webhook struct {
+	Webhook
+	c *http.Client
+}
+
+// Notify POSTs event JSON to the webhook URL
+func (w *webhook) Notify(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+type mailer struct {
+	*SMTP
+}
+
+// Notify sends event email
+func (m *mailer) Notify(e *Event) error {
+	var auth smtp.Auth
+	if len(m.Username) > 0 {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [crawler] %s\r\n\r\n%s\r\n",
+		m.From, strings.Join(m.To, ", "), e.Subject(), e.Text())
+	return smtp.SendMail(m.Addr, auth, m.From, m.To, []byte(msg))
+}
+
+type command struct {
+	cmd string
+}
+
+// Notify runs command with sh -c, passing event JSON to stdin and event
+// fields in ALERT_* environment variables
+func (c *command) Notify(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.cmd)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"ALERT_KIND="+e.Kind,
+		"ALERT_URL="+e.URL,
+		"ALERT_SOURCE="+e.Source,
+		"ALERT_SINCE="+e.Since.Format(time.RFC3339),
+		"ALERT_ERROR="+e.Reason,
+	)
+	if e.Cert != nil {
+		cmd.Env = append(cmd.Env, "ALERT_CERT_EXPIRY="+e.Cert.Expiry.Format(time.RFC3339))
+	}
+	if e.Content != nil {
+		cmd.Env = append(cmd.Env, "ALERT_CONTENT_CHANGE="+e.Content.Change)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// newNotifiers creates notifiers for every configured delivery method
+func newNotifiers(cfg *Config) []Notifier {
+	var res []Notifier
+	c := &http.Client{Timeout: deliveryTimeout}
+	for _, w := range cfg.Webhooks {
+		res = append(res, &webhook{Webhook: w, c: c})
+	}
+	if cfg.SMTP != nil {
+		res = append(res, &mailer{cfg.SMTP})
+	}
+	for _, cmd := range cfg.Commands {
+		res = append(res, &command{cmd: cmd})
+	}
+	return res
+}
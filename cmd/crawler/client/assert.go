@@ -0,0 +1,74 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+)
+
+// ErrAssertion is an error class for responses that failed target assertions
+// other than status code
+const ErrAssertion = "assertion"
+
+// maxBody limits the part of response body kept in memory for assertions
+const maxBody = 1 << 20
+
+// assert evaluates target assertions against the response and returns error
+// class and description of the first failed assertion, latency is asserted
+// for every kind of target separately
+func assert(a *config.Assertions, resp *http.Response, body []byte) (string, string) {
+	status := a.Status
+	if len(status) == 0 {
+		status = config.DefaultStatus
+	}
+	ok := false
+	for _, r := range status {
+		if r.Contains(resp.StatusCode) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		ranges := make([]string, len(status))
+		for i, r := range status {
+			ranges[i] = r.String()
+		}
+		return ErrStatus, fmt.Sprintf("status %d not in %s", resp.StatusCode, strings.Join(ranges, ","))
+	}
+
+	for _, h := range a.Headers {
+		v, ok := resp.Header[http.CanonicalHeaderKey(h.Name)]
+		if !ok {
+			return ErrAssertion, fmt.Sprintf("header %s is missing", h.Name)
+		}
+		if len(h.Value) > 0 && !strings.Contains(strings.Join(v, ", "), h.Value) {
+			return ErrAssertion, fmt.Sprintf("header %s does not contain %q", h.Name, h.Value)
+		}
+	}
+
+	for _, s := range a.BodyContains {
+		if !bytes.Contains(body, []byte(s)) {
+			return ErrAssertion, fmt.Sprintf("body does not contain %q", s)
+		}
+	}
+	for _, s := range a.BodyExcludes {
+		if bytes.Contains(body, []byte(s)) {
+			return ErrAssertion, fmt.Sprintf("body contains %q", s)
+		}
+	}
+	for _, re := range a.BodyMatch {
+		if !re.Match(body) {
+			return ErrAssertion, fmt.Sprintf("body does not match %q", re)
+		}
+	}
+	for _, re := range a.BodyNotMatch {
+		if re.Match(body) {
+			return ErrAssertion, fmt.Sprintf("body matches %q", re)
+		}
+	}
+
+	return "", ""
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+)
+
+func TestAssert(t *testing.T) {
+	resp := &http.Response{StatusCode: 302, Header: http.Header{
+		"Content-Type": {"text/html; charset=utf-8"},
+		"X-Id":         {"a", "b"},
+	}}
+	body := []byte("<h1>Welcome home</h1> v12")
+
+	tests := []struct {
+		name string
+		a    config.Assertions
+		want string // error class
+	}{
+		{"default status", config.Assertions{}, ""},
+		{"status range", config.Assertions{Status: []config.StatusRange{{From: 300, To: 399}}}, ""},
+		{"status list", config.Assertions{Status: []config.StatusRange{{From: 200, To: 200}, {From: 302, To: 302}}}, ""},
+		{"status mismatch", config.Assertions{Status: []config.StatusRange{{From: 200, To: 299}}}, ErrStatus},
+		{"header present", config.Assertions{Headers: []config.Header{{Name: "x-id"}}}, ""},
+		{"header value", config.Assertions{Headers: []config.Header{{Name: "content-type", Value: "text/html"}}}, ""},
+		{"header joined values", config.Assertions{Headers: []config.Header{{Name: "X-Id", Value: "a, b"}}}, ""},
+		{"header missing", config.Assertions{Headers: []config.Header{{Name: "X-Missing"}}}, ErrAssertion},
+		{"header value mismatch", config.Assertions{Headers: []config.Header{{Name: "Content-Type", Value: "json"}}}, ErrAssertion},
+		{"body contains", config.Assertions{BodyContains: []string{"Welcome", "v12"}}, ""},
+		{"body does not contain", config.Assertions{BodyContains: []string{"Welcome", "Goodbye"}}, ErrAssertion},
+		{"body excludes", config.Assertions{BodyExcludes: []string{"error"}}, ""},
+		{"forbidden substring", config.Assertions{BodyExcludes: []string{"home"}}, ErrAssertion},
+		{"body matches", config.Assertions{BodyMatch: []*regexp.Regexp{regexp.MustCompile(`v\d+`)}}, ""},
+		{"body does not match", config.Assertions{BodyMatch: []*regexp.Regexp{regexp.MustCompile(`^v\d+`)}}, ErrAssertion},
+		{"body not matches", config.Assertions{BodyNotMatch: []*regexp.Regexp{regexp.MustCompile(`(?i)error`)}}, ""},
+		{"body matches forbidden", config.Assertions{BodyNotMatch: []*regexp.Regexp{regexp.MustCompile(`h[0-9]`)}}, ErrAssertion},
+		{"status checked first", config.Assertions{
+			Status:       []config.StatusRange{{From: 200, To: 200}},
+			BodyContains: []string{"Goodbye"},
+		}, ErrStatus},
+	}
+	for _, tt := range tests {
+		got, desc := assert(&tt.a, resp, body)
+		if got != tt.want || (len(got) > 0) != (len(desc) > 0) {
+			t.Errorf("%s: assert() = %q, %q, want %q", tt.name, got, desc, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"crypto/x509"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// inspect summarizes the leaf of the certificate chain server presented and
+// verifies the chain against system roots and host name. Verification error,
+// if any, is returned along with the summary.
+func inspect(certs []*x509.Certificate, host string) (*db.Certificate, error) {
+	leaf := certs[0]
+	c := &db.Certificate{
+		Expiry: leaf.NotAfter,
+		Issuer: leaf.Issuer.CommonName,
+		SANs:   append([]string{}, leaf.DNSNames...),
+	}
+	if len(c.Issuer) == 0 && len(leaf.Issuer.Organization) > 0 {
+		c.Issuer = leaf.Issuer.Organization[0]
+	}
+	for _, ip := range leaf.IPAddresses {
+		c.SANs = append(c.SANs, ip.String())
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, ic := range certs[1:] {
+		intermediates.AddCert(ic)
+	}
+	_, chainErr := leaf.Verify(x509.VerifyOptions{Intermediates: intermediates})
+	hostErr := leaf.VerifyHostname(host)
+	c.ChainValid, c.HostValid = chainErr == nil, hostErr == nil
+
+	if chainErr != nil {
+		return c, chainErr
+	}
+	return c, hostErr
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// Confirmation modes
+const (
+	ConfirmRetry  = "retry"  // failed check is repeated from the same source
+	ConfirmSource = "source" // failed check is repeated from another target source, if it has one
+)
+
+// confirmation repeats failed checks, so one-off failures of a source don't
+// make target down. Repeated checks are scheduled by Crawl after delay, so
+// workers aren't blocked while waiting.
+type confirmation struct {
+	other bool
+	delay time.Duration
+
+	c    chan *check   // failed checks to be scheduled for confirmation
+	stop chan struct{} // closed once Crawl stops scheduling
+}
+
+// check is a job check queued to a source worker. Repeated check confirming
+// the failure carries failed check records.
+type check struct {
+	j    *job
+	recs []*db.Record // failed check records, nil unless check confirms them
+	from *client      // source to repeat failed check from
+	due  time.Time    // time to repeat failed check at
+}
+
+// SetConfirmation enables confirmation of failed checks in one of the modes,
+// failed check is repeated after delay. It should be called before Crawl.
+func (c *Client) SetConfirmation(mode string, delay time.Duration) error {
+	switch mode {
+	case ConfirmRetry, ConfirmSource:
+	default:
+		return fmt.Errorf("Unknown confirmation mode %s, should be %s or %s", mode, ConfirmRetry, ConfirmSource)
+	}
+	if delay < 0 {
+		return fmt.Errorf("Confirmation delay should not be negative")
+	}
+	c.confirm = &confirmation{other: mode == ConfirmSource, delay: delay}
+	return nil
+}
+
+// start returns a copy of conf failed checks are scheduled with during a
+// crawl, nil if failures aren't confirmed
+func (conf *confirmation) start() *confirmation {
+	if conf == nil {
+		return nil
+	}
+	return &confirmation{other: conf.other, delay: conf.delay, c: make(chan *check), stop: make(chan struct{})}
+}
+
+// schedule sends failed check of job j made by c to be repeated after delay,
+// it returns false if Crawl doesn't schedule checks anymore
+func (conf *confirmation) schedule(c *client, j *job, recs []*db.Record) bool {
+	from := c
+	if conf.other {
+		from = j.other(c)
+	}
+	select {
+	case conf.c <- &check{j: j, recs: recs, from: from, due: time.Now().Add(conf.delay)}:
+		return true
+	case <-conf.stop:
+		return false
+	}
+}
+
+// run checks target, multi-step targets produce a record per step followed by
+// the target record
+func (c *client) run(j *job) []*db.Record {
+	if len(j.t.Steps) > 0 {
+		return c.transaction(j.t)
+	}
+	return []*db.Record{c.check(j.t)}
+}
+
+// confirm repeats failed check of job from c and returns records of both
+// checks. Failures are confirmed if the repeated check fails as well,
+// otherwise failed check is considered up, keeping its error.
+func confirm(c *client, j *job, recs []*db.Record) []*db.Record {
+	again := c.run(j)
+
+	confirmed := !again[len(again)-1].Up
+	recs = append(recs, again...)
+	for _, r := range recs {
+		if r.Up {
+			continue
+		}
+		if confirmed {
+			r.Confirmed = true
+		} else {
+			r.Up = true
+		}
+	}
+	return recs
+}
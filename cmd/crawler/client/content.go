@@ -0,0 +1,158 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+const (
+	// snippetSize is the max size of content snippets around the first
+	// difference
+	snippetSize = 200
+	// headSize is the max size of content kept to find the first difference
+	// in, the previous snippet of differences past it is cut at its end
+	headSize = 4 * 1024
+)
+
+// content is the last content of a target received from a source
+type content struct {
+	hash  string
+	head  []byte    // up to headSize first bytes
+	since time.Time // time the content was first received
+	last  time.Time // time of the last check
+
+	differs string // hash of other source content difference was flagged with
+}
+
+// contentTracker keeps the last normalized content of every watched target
+// per source and detects its changes. It's shared by all the source clients,
+// so content received from different sources can be compared.
+type contentTracker struct {
+	mu      sync.Mutex
+	targets map[string]map[string]*content // by target key and source
+}
+
+func newContentTracker() *contentTracker {
+	return &contentTracker{targets: map[string]map[string]*content{}}
+}
+
+// observe hashes normalized body of successful check and flags the record if
+// content differs from the previous check from the same source or from the
+// content other source received since this one was first received, the
+// latter is flagged once per other content. Targets are told by key, see
+// targetKey. Nil tracker only hashes content.
+func (t *contentTracker) observe(key string, r *db.Record, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	r.ContentHash = hex.EncodeToString(sum[:])
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sources, ok := t.targets[key]
+	if !ok {
+		sources = map[string]*content{}
+		t.targets[key] = sources
+	}
+
+	prev := sources[r.LocalIP]
+	if prev != nil && prev.hash == r.ContentHash {
+		prev.last = now
+		for src, other := range sources {
+			if src != r.LocalIP && other.hash != prev.hash && other.hash != prev.differs &&
+				other.last.After(prev.since) {
+				r.Content = diff(db.ContentDiffers, other, body)
+				prev.differs = other.hash
+				break
+			}
+		}
+		return
+	}
+
+	if prev != nil {
+		r.Content = diff(db.ContentChanged, prev, body)
+	}
+	head := body
+	if len(head) > headSize {
+		head = head[:headSize]
+	}
+	// body may be a part of a bigger buffer, so it's copied
+	sources[r.LocalIP] = &content{hash: r.ContentHash, head: append([]byte{}, head...), since: now, last: now}
+}
+
+// forget drops content of targets that are not watched anymore
+func (t *contentTracker) forget(keys map[string]bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	for key := range t.targets {
+		if !keys[key] {
+			delete(t.targets, key)
+		}
+	}
+	t.mu.Unlock()
+}
+
+// diff describes difference of body from prev content
+func diff(kind string, prev *content, body []byte) *db.ContentChange {
+	off := 0
+	for off < len(prev.head) && off < len(body) && prev.head[off] == body[off] {
+		off++
+	}
+	return &db.ContentChange{
+		Kind:     kind,
+		Previous: prev.hash,
+		Before:   snippet(prev.head, off),
+		After:    snippet(body, off),
+	}
+}
+
+// snippet returns up to snippetSize bytes of body starting a bit before off,
+// cut at UTF-8 character boundaries, see validUTF8
+func snippet(body []byte, off int) string {
+	start := off - snippetSize/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetSize
+	if end > len(body) {
+		end = len(body)
+	}
+	for start < end && !utf8.RuneStart(body[start]) {
+		start++
+	}
+	for end < len(body) && end > start && !utf8.RuneStart(body[end]) {
+		end--
+	}
+	return validUTF8(body[start:end])
+}
+
+// validUTF8 replaces runs of invalid UTF-8 bytes with U+FFFD and drops NUL
+// characters, so snippet can be saved as text by any backend
+func validUTF8(b []byte) string {
+	res := make([]byte, 0, len(b))
+	invalid := false
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			if !invalid {
+				res = append(res, string(utf8.RuneError)...)
+			}
+			invalid = true
+		case r != 0:
+			res = append(res, b[:size]...)
+			invalid = false
+		}
+		b = b[size:]
+	}
+	return string(res)
+}
@@ -1,37 +1,145 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
 	"github.com/bpiddubnyi/crawler/db"
 )
 
+// defaultRedirects is the max number of redirects followed if -follow is set
+// and target doesn't specify its own limit
+const defaultRedirects = 10
+
+type redirectsKey struct{}
+
 type client struct {
-	c *http.Client
-	a string
+	probes  map[string]Probe
+	timeout time.Duration
+	proxy   *url.URL // nil if checks are made from local address
+	a       string
+	src     string // IP address or proxy URL as configured
 }
 
-func (c *client) Check(urlC <-chan string, rC chan<- *db.Record) {
-	for url := range urlC {
-		resp, err := c.c.Get(url)
-		if err == nil {
-			io.Copy(ioutil.Discard, resp.Body)
-			resp.Body.Close()
+// newClient creates client checking targets from local address addr or
+// through proxy, timeout is the default check timeout
+func newClient(timeout time.Duration, addr *net.TCPAddr, proxy *url.URL, follow bool, a, src string,
+	content *contentTracker) *client {
+	var local net.Addr
+	if addr != nil {
+		local = addr
+	}
+	hc := setupClient(timeout, local, proxy, follow)
+	return &client{
+		probes:  newProbes(hc, addr, proxy, timeout, content),
+		timeout: timeout,
+		proxy:   proxy,
+		a:       a,
+		src:     src,
+	}
+}
+
+// Check checks jobs from jC, failed checks are scheduled to be repeated to
+// confirm the failure if conf is set. Job is in progress till its failure is
+// confirmed.
+func (c *client) Check(jC <-chan *check, rC chan<- *db.Record, observers []Observer, conf *confirmation) {
+	for ch := range jC {
+		if ch.recs != nil {
+			recs := confirm(c, ch.j, ch.recs)
+			ch.j.confirmed()
+			emit(recs, rC, observers)
+			continue
+		}
+
+		recs := c.run(ch.j)
+		if conf != nil && !recs[len(recs)-1].Up {
+			ch.j.waitConfirmation()
+			if conf.schedule(c, ch.j, recs) {
+				continue
+			}
+			ch.j.confirmed()
 		} else {
-			log.Println(err)
+			ch.j.done()
+		}
+		emit(recs, rC, observers)
+	}
+}
+
+// emit notifies observers about target records and sends records to rC
+func emit(recs []*db.Record, rC chan<- *db.Record, observers []Observer) {
+	for _, r := range recs {
+		if len(r.Step) == 0 {
+			for _, o := range observers {
+				o.Observe(r)
+			}
 		}
-		rC <- &db.Record{URL: url, Time: time.Now(), LocalIP: c.a, Up: err == nil}
+		rC <- r
 	}
 }
 
+func (c *client) check(target *config.Target) *db.Record {
+	r := &db.Record{URL: target.URL, LocalIP: c.a}
+	start := time.Now()
+
+	timeout := target.Timeout
+	if timeout == 0 {
+		timeout = c.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	err := c.probe(ctx, target, r)
+	cancel()
+
+	if err != nil {
+		log.Println(err)
+	}
+	finish(r, start, target.Assert.MaxLatency)
+
+	return r
+}
+
+// finish sets check record time and duration and evaluates max latency
+// assertion, the record is up if check succeeded
+func finish(r *db.Record, start time.Time, max time.Duration) {
+	r.Time = time.Now()
+	r.Duration = r.Time.Sub(start)
+
+	if len(r.Error) == 0 && max > 0 && r.Duration > max {
+		r.Error, r.Assertion = ErrAssertion, fmt.Sprintf("latency %s exceeds %s", r.Duration, max)
+	}
+	r.Up = len(r.Error) == 0
+}
+
+func newRequest(t *config.Target) (*http.Request, error) {
+	var body io.Reader
+	if len(t.Body) > 0 {
+		body = strings.NewReader(t.Body)
+	}
+
+	req, err := http.NewRequest(t.Method, t.URL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range t.Headers {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
 func getLocalAddr() (*net.TCPAddr, error) {
 	conn, err := net.Dial("udp", "8.8.8.8:53")
 	if err != nil {
@@ -42,10 +150,21 @@ func getLocalAddr() (*net.TCPAddr, error) {
 
 func setupClient(timeout time.Duration, addr net.Addr, proxy *url.URL, follow bool) *http.Client {
 	checkRedirect := func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
-	}
-	if follow {
-		checkRedirect = nil
+		max := 0
+		if follow {
+			max = defaultRedirects
+		}
+		if n, ok := req.Context().Value(redirectsKey{}).(int); ok {
+			max = n
+		}
+
+		if max == 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > max {
+			return fmt.Errorf("stopped after %d redirects", max)
+		}
+		return nil
 	}
 
 	p := http.ProxyFromEnvironment
@@ -71,11 +190,63 @@ func setupClient(timeout time.Duration, addr net.Addr, proxy *url.URL, follow bo
 	}
 }
 
-// Client is a HTTP client wrapper
+// Observer is notified about every target check record before it's saved,
+// step records of multi-step targets are only saved. Observe shouldn't block.
+type Observer interface {
+	Observe(r *db.Record)
+}
+
+// ReloadObserver is an Observer that is notified about URLs of targets
+// checked after every targets (re)load, so it can drop state of the removed
+// ones. Reload shouldn't block.
+type ReloadObserver interface {
+	Observer
+	Reload(urls []string)
+}
+
+// Client checks targets from one or more sources with probes of their kinds
 type Client struct {
-	clients []*client
-	period  time.Duration
-	w       db.Writer
+	clients   []*client
+	period    time.Duration
+	w         db.Writer
+	observers []Observer
+	content   *contentTracker
+	confirm   *confirmation // nil if failures aren't confirmed
+
+	mu  sync.Mutex // protects fields below, set once Crawl starts
+	s   *scheduler
+	jCs map[*client]chan *check
+	rC  chan *db.Record
+}
+
+// Stats is a snapshot of crawler runtime state
+type Stats struct {
+	Queued   int    // checks waiting for a worker
+	Pending  int    // records waiting to be written to db
+	Overruns uint64 // check rounds skipped because of overruns
+}
+
+// Stats returns current crawler state, it's safe to call concurrently with
+// Crawl
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var res Stats
+	if c.s == nil {
+		return res
+	}
+	for _, jC := range c.jCs {
+		res.Queued += len(jC)
+	}
+	res.Pending = len(c.rC)
+	res.Overruns = atomic.LoadUint64(&c.s.overruns)
+	return res
+}
+
+// AddObserver adds check records observer, it should be called before Crawl
+func (c *Client) AddObserver(o Observer) {
+	c.observers = append(c.observers, o)
 }
 
 // New creates new crawler with one or more HTTP clients depending on number of ip addresses
@@ -85,8 +256,9 @@ type Client struct {
 // If follow is true, HTTP client will follow HTTP redirects.
 func New(ips []string, proxies []string, period time.Duration, follow bool, w db.Writer) (*Client, error) {
 	res := &Client{
-		period: period,
-		w:      w,
+		period:  period,
+		w:       w,
+		content: newContentTracker(),
 	}
 
 	if len(ips) > 0 {
@@ -97,7 +269,7 @@ func New(ips []string, proxies []string, period time.Duration, follow bool, w db
 				return nil, fmt.Errorf("Failed to resolve tcp address %s: %s", ip, err)
 			}
 
-			res.clients[i] = &client{c: setupClient(period, addr, nil, follow), a: addr.String()}
+			res.clients[i] = newClient(period, addr, nil, follow, addr.String(), ip, res.content)
 		}
 	}
 
@@ -107,7 +279,7 @@ func New(ips []string, proxies []string, period time.Duration, follow bool, w db
 			if err != nil {
 				return nil, fmt.Errorf("Failed to parse proxy URL %s: %s", proxy, err)
 			}
-			res.clients = append(res.clients, &client{c: setupClient(period, nil, proxyURL, follow), a: proxyURL.String()})
+			res.clients = append(res.clients, newClient(period, nil, proxyURL, follow, proxyURL.String(), proxy, res.content))
 		}
 	}
 
@@ -118,53 +290,261 @@ func New(ips []string, proxies []string, period time.Duration, follow bool, w db
 		}
 
 		res.clients = make([]*client, 1)
-		res.clients[0] = &client{c: setupClient(period-period/3, nil, nil, follow), a: addr.String()}
+		res.clients[0] = newClient(period-period/3, nil, nil, follow, addr.String(), "", res.content)
+	}
+
+	return res, nil
+}
+
+// sourceClients returns clients target should be checked from, DNS targets
+// can't be checked through proxies
+func (c *Client) sourceClients(t *config.Target) ([]*client, error) {
+	dns := t.Scheme() == "dns"
+	if len(t.Sources) == 0 {
+		if !dns {
+			return c.clients, nil
+		}
+		var res []*client
+		for _, cl := range c.clients {
+			if cl.proxy == nil {
+				res = append(res, cl)
+			}
+		}
+		if len(res) == 0 {
+			return nil, fmt.Errorf("No source for %s, DNS targets can't be checked through proxies", t.URL)
+		}
+		return res, nil
 	}
 
+	res := make([]*client, 0, len(t.Sources))
+	for _, src := range t.Sources {
+		found := false
+		for _, cl := range c.clients {
+			if cl.src == src {
+				if dns && cl.proxy != nil {
+					return nil, fmt.Errorf("Source %s for %s is a proxy, DNS targets can't be checked through proxies", src, t.URL)
+				}
+				res = append(res, cl)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown source %s for %s, should be one of -ips or -proxies", src, t.URL)
+		}
+	}
 	return res, nil
 }
 
-// Crawl periodically creates HTTP GET requests to urls, checks if it's
-// possible to get any correct HTTP response, and saves result (is server up
-// or down) to db.
-func (c *Client) Crawl(urls []string, flushPeriod time.Duration, nWorkers int, shutdownC <-chan struct{}) error {
+// newJob creates target check job
+func (c *Client) newJob(t *config.Target) (*job, error) {
+	sources, err := c.sourceClients(t)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := t.Interval
+	if interval == 0 {
+		interval = c.period
+	}
+	return newJob(t, interval, sources), nil
+}
+
+// targetKey identifies target across config reloads
+func targetKey(t *config.Target) string {
+	return t.Method + " " + t.URL
+}
+
+// reload updates scheduled jobs to match targets list: removed targets
+// are unscheduled, added ones scheduled with random initial delay, existing
+// ones are replaced by new jobs keeping their schedule. Duplicate targets are
+// checked once. If any of the targets is invalid, the old set of jobs is kept
+// intact.
+func (c *Client) reload(s *scheduler, jobs map[string]*job, targets []config.Target) error {
+	var (
+		newJobs = make(map[string]*job, len(targets))
+		watched = map[string]bool{}
+	)
+	for i := range targets {
+		j, err := c.newJob(&targets[i])
+		if err != nil {
+			return err
+		}
+		key := targetKey(j.t)
+		if _, ok := newJobs[key]; ok {
+			log.Printf("Warning: Duplicate target %s, checking it once\n", key)
+			continue
+		}
+		if j.t.Content != nil {
+			watched[key] = true
+		}
+		newJobs[key] = j
+	}
+
+	var added, removed, kept int
+	now := time.Now()
+	for key, j := range jobs {
+		if _, ok := newJobs[key]; !ok {
+			s.remove(j)
+			delete(jobs, key)
+			removed++
+		}
+	}
+	for key, nj := range newJobs {
+		j, ok := jobs[key]
+		if !ok {
+			s.add(nj, now)
+			jobs[key] = nj
+			added++
+			continue
+		}
+
+		s.replace(j, nj)
+		jobs[key] = nj
+		if limit := now.Add(nj.interval); j.interval != nj.interval && nj.due.After(limit) {
+			nj.due = limit
+			s.fix(nj)
+		}
+		kept++
+	}
+
+	c.content.forget(watched)
+
+	urls := make([]string, 0, len(newJobs))
+	for _, j := range newJobs {
+		urls = append(urls, j.t.URL)
+	}
+	for _, o := range c.observers {
+		if ro, ok := o.(ReloadObserver); ok {
+			ro.Reload(urls)
+		}
+	}
+
+	log.Printf("Info: Targets updated: %d added, %d removed, %d kept\n", added, removed, kept)
+	return nil
+}
+
+// Crawl periodically creates HTTP requests to targets, checks if the
+// response satisfies target assertions, and saves result (is server up
+// or down) to db. Every target is checked once per its interval or crawler
+// period, first checks are spread randomly across the interval. Each check
+// is done by one of the target source clients, chosen in round-robin fashion.
+// New target lists received from updateC replace the current one without
+// interrupting checks in progress, invalid lists are logged and ignored.
+func (c *Client) Crawl(targets []config.Target, flushPeriod time.Duration, nWorkers int,
+	updateC <-chan []config.Target, shutdownC <-chan struct{}) error {
+	s := newScheduler()
+	jobs := map[string]*job{}
+	if err := c.reload(s, jobs, targets); err != nil {
+		return err
+	}
+
 	rC := make(chan *db.Record, 500)
 	errC := make(chan error)
-	urlC := make(chan string, 500)
-	t := time.NewTicker(c.period)
-	defer t.Stop()
+	jCs := make(map[*client]chan *check, len(c.clients))
+	observers, conf := c.observers, c.confirm.start()
+	var confirmC chan *check // nil if failures aren't confirmed
+	if conf != nil {
+		confirmC = conf.c
+	}
 
 	go c.w.Write(flushPeriod, rC, errC)
 
 	wg := sync.WaitGroup{}
 
 	for _, cl := range c.clients {
+		jC := make(chan *check, 500)
+		jCs[cl] = jC
 		for i := 0; i < nWorkers; i++ {
 			wg.Add(1)
 			go func(c *client) {
-				c.Check(urlC, rC)
+				c.Check(jC, rC, observers, conf)
 				wg.Done()
 			}(cl)
 		}
 	}
 
-	var err error
+	c.mu.Lock()
+	c.s, c.jCs, c.rC = s, jCs, rC
+	c.mu.Unlock()
+
+	var (
+		err   error
+		timer *time.Timer
+	)
+
+	// send queues check to worker, failed checks scheduled for confirmation
+	// meanwhile are received, so workers don't block on them. It returns
+	// false if crawling should stop.
+	send := func(jC chan<- *check, ch *check) bool {
+		for {
+			select {
+			case <-shutdownC:
+				return false
+			case err = <-errC:
+				close(errC)
+				errC = nil
+				return false
+			case cc := <-confirmC:
+				s.confirm(cc)
+			case jC <- ch:
+				return true
+			}
+		}
+	}
 
 theLoop:
 	for {
-		for _, url := range urls {
-			select {
-			case _, ok := <-shutdownC:
-				if !ok {
-					break theLoop
-				}
-			case urlC <- url:
+		now := time.Now()
+		for ch := s.popConfirm(now); ch != nil; ch = s.popConfirm(now) {
+			if !send(jCs[ch.from], ch) {
+				// it's saved unconfirmed on shutdown
+				s.confirm(ch)
+				break theLoop
+			}
+		}
+		for j, skip := s.pop(now); j != nil; j, skip = s.pop(now) {
+			if skip {
 				continue
 			}
+
+			j.start()
+			if !send(jCs[j.source()], &check{j: j}) {
+				j.done()
+				break theLoop
+			}
+		}
+
+		wait, ok := s.wait(time.Now())
+		if !ok {
+			wait = c.period
+		}
+		if timer == nil {
+			timer = time.NewTimer(wait)
+			defer timer.Stop()
+		} else {
+			timer.Reset(wait)
 		}
 
 		select {
-		case <-t.C:
+		case <-timer.C:
+			continue theLoop
+		case cc := <-confirmC:
+			s.confirm(cc)
+			if !timer.Stop() {
+				<-timer.C
+			}
+			continue theLoop
+		case newTargets := <-updateC:
+			c.mu.Lock()
+			err := c.reload(s, jobs, newTargets)
+			c.mu.Unlock()
+			if err != nil {
+				log.Printf("Error: Failed to reload targets, keeping the old ones: %s\n", err)
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
 			continue theLoop
 		case _, ok := <-shutdownC:
 			if !ok {
@@ -177,8 +557,18 @@ theLoop:
 		}
 	}
 
-	close(urlC)
+	if conf != nil {
+		close(conf.stop)
+	}
+	for _, jC := range jCs {
+		close(jC)
+	}
 	wg.Wait()
+	// failures that weren't confirmed yet are saved as they are
+	for _, ch := range s.confirms {
+		ch.j.confirmed()
+		emit(ch.recs, rC, observers)
+	}
 	close(rC)
 	if errC != nil {
 		err = <-errC
@@ -0,0 +1,304 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+	"github.com/bpiddubnyi/crawler/db/memory"
+)
+
+func TestReload(t *testing.T) {
+	var (
+		c    = &Client{clients: []*client{{src: "10.0.0.1"}}, period: time.Minute}
+		s    = newScheduler()
+		jobs = map[string]*job{}
+	)
+
+	old := []config.Target{
+		{URL: "http://a.com", Method: "GET"},
+		{URL: "http://b.com", Method: "GET"},
+	}
+	if err := c.reload(s, jobs, old); err != nil {
+		t.Fatalf("reload() error = %s", err)
+	}
+	kept := jobs["GET http://b.com"]
+	due := kept.due
+
+	bad := []config.Target{
+		{URL: "http://c.com", Method: "GET", Sources: []string{"10.0.0.2"}},
+	}
+	if err := c.reload(s, jobs, bad); err == nil {
+		t.Fatalf("reload() with unknown source succeeded")
+	}
+	if len(jobs) != 2 || len(s.jobs) != 2 {
+		t.Fatalf("failed reload changed jobs: %d jobs, %d scheduled", len(jobs), len(s.jobs))
+	}
+
+	cur := []config.Target{
+		{URL: "http://b.com", Method: "GET", Sources: []string{"10.0.0.1"}},
+		{URL: "http://c.com", Method: "GET"},
+		{URL: "http://c.com", Method: "GET", Interval: time.Hour},
+	}
+	if err := c.reload(s, jobs, cur); err != nil {
+		t.Fatalf("reload() error = %s", err)
+	}
+	if len(jobs) != 2 || len(s.jobs) != 2 {
+		t.Fatalf("got %d jobs, %d scheduled, want 2", len(jobs), len(s.jobs))
+	}
+	if _, ok := jobs["GET http://a.com"]; ok {
+		t.Errorf("removed target is still scheduled")
+	}
+	nj := jobs["GET http://b.com"]
+	if nj == kept || !nj.due.Equal(due) || nj.t != &cur[0] || nj.running != kept.running {
+		t.Errorf("kept target job is not replaced keeping its schedule")
+	}
+	if kept.t != &old[1] || kept.index != -1 {
+		t.Errorf("replaced job is modified or still scheduled")
+	}
+	if j, ok := jobs["GET http://c.com"]; !ok || j.t != &cur[1] {
+		t.Errorf("added target is not scheduled once")
+	}
+}
+
+func TestCrawl(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	var (
+		period = 50 * time.Millisecond
+		d      = memory.New()
+		c      = &Client{
+			clients: []*client{newClient(period, nil, nil, false, "127.0.0.1", "", nil)},
+			period:  period,
+			w:       d,
+		}
+		targets = []config.Target{
+			{URL: srv.URL + "/up", Method: "GET"},
+			{URL: srv.URL + "/down", Method: "GET"},
+		}
+		shutdownC = make(chan struct{})
+	)
+
+	time.AfterFunc(10*period, func() { close(shutdownC) })
+	if err := c.Crawl(targets, period, 2, nil, shutdownC); err != nil {
+		t.Fatalf("Crawl() error = %s", err)
+	}
+
+	recs, err := d.GetRecords(time.Now().Add(-time.Minute), time.Now())
+	if err != nil {
+		t.Fatalf("GetRecords() error = %s", err)
+	}
+
+	counts := map[string]int{}
+	for _, r := range recs {
+		counts[r.URL]++
+		switch r.URL {
+		case srv.URL + "/up":
+			if !r.Up || r.StatusCode != http.StatusOK || r.Size != 5 {
+				t.Errorf("unexpected record %+v", r)
+			}
+		case srv.URL + "/down":
+			if r.Up || r.StatusCode != http.StatusServiceUnavailable || r.Error != ErrStatus {
+				t.Errorf("unexpected record %+v", r)
+			}
+		}
+	}
+	for _, tgt := range targets {
+		if counts[tgt.URL] < 5 {
+			t.Errorf("%s checked %d times, want at least 5", tgt.URL, counts[tgt.URL])
+		}
+	}
+
+	stats := stat.Aggregate(recs)
+	if len(stats) != 2 || stats[0].UpTime != 0 || stats[1].UpTime != stats[1].WholeTime {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	var fails int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fails, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	var (
+		a      = newClient(time.Second, nil, nil, false, "10.0.0.1", "", nil)
+		b      = newClient(time.Second, nil, nil, false, "10.0.0.2", "", nil)
+		target = &config.Target{URL: srv.URL, Method: http.MethodGet}
+		conf   = (&confirmation{other: true, delay: time.Minute}).start()
+		start  = time.Now()
+	)
+
+	tests := []struct {
+		fails   int32
+		sources []*client
+		want    []string // record sources, failed ones are suffixed with their state
+	}{
+		{1, []*client{a, b}, []string{"10.0.0.1 unconfirmed", "10.0.0.2"}},
+		{2, []*client{a, b}, []string{"10.0.0.1 confirmed", "10.0.0.2 confirmed"}},
+		{2, []*client{a}, []string{"10.0.0.1 confirmed", "10.0.0.1 confirmed"}},
+	}
+	for i, tt := range tests {
+		atomic.StoreInt32(&fails, tt.fails)
+		j := newJob(target, time.Minute, tt.sources)
+		go conf.schedule(a, j, a.run(j))
+		ch := <-conf.c
+		if ch.j != j || ch.due.Before(start.Add(conf.delay)) {
+			t.Fatalf("test %d: scheduled %+v, want job due after %s", i, ch, conf.delay)
+		}
+		recs := confirm(ch.from, j, ch.recs)
+
+		got := make([]string, len(recs))
+		for k, r := range recs {
+			got[k] = r.LocalIP
+			switch {
+			case r.Confirmed && !r.Up:
+				got[k] += " confirmed"
+			case r.Up && len(r.Error) > 0:
+				got[k] += " unconfirmed"
+			case !r.Up || r.Confirmed:
+				got[k] += " invalid"
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test %d: records %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+// TestCrawlReload reloads targets while their checks are in progress, it's
+// meant to be run with -race
+func TestCrawlReload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	var (
+		period = 20 * time.Millisecond
+		d      = memory.New()
+		c      = &Client{
+			clients: []*client{
+				newClient(period, nil, nil, false, "10.0.0.1", "10.0.0.1", nil),
+				newClient(period, nil, nil, false, "10.0.0.2", "10.0.0.2", nil),
+			},
+			period:  period,
+			w:       d,
+			content: newContentTracker(),
+		}
+		updateC   = make(chan []config.Target)
+		shutdownC = make(chan struct{})
+		errC      = make(chan error)
+	)
+	if err := c.SetConfirmation(ConfirmSource, 0); err != nil {
+		t.Fatalf("SetConfirmation() error = %s", err)
+	}
+
+	targets := func(i int) []config.Target {
+		res := make([]config.Target, 10)
+		for k := range res {
+			res[k] = config.Target{URL: fmt.Sprintf("%s/%d", srv.URL, k), Method: http.MethodGet}
+			if i%2 == 0 {
+				res[k].Sources = []string{"10.0.0.2"}
+				res[k].Interval = 2 * period
+			}
+		}
+		return res
+	}
+
+	go func() { errC <- c.Crawl(targets(1), period, 2, updateC, shutdownC) }()
+	for i := 0; i < 50; i++ {
+		time.Sleep(period / 4)
+		updateC <- targets(i)
+	}
+	close(shutdownC)
+	if err := <-errC; err != nil {
+		t.Fatalf("Crawl() error = %s", err)
+	}
+
+	recs, err := d.GetRecords(time.Now().Add(-time.Minute), time.Now())
+	if err != nil {
+		t.Fatalf("GetRecords() error = %s", err)
+	}
+	if len(recs) == 0 {
+		t.Errorf("no checks made")
+	}
+}
+
+// TestCrawlConfirmDelay checks that workers aren't blocked by confirmation
+// delay, confirmation outlasting the interval isn't an overrun and failures
+// that weren't confirmed by shutdown are saved
+func TestCrawlConfirmDelay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	var (
+		period = 20 * time.Millisecond
+		d      = memory.New()
+		c      = &Client{
+			clients: []*client{newClient(period, nil, nil, false, "10.0.0.1", "10.0.0.1", nil)},
+			period:  period,
+			w:       d,
+			content: newContentTracker(),
+		}
+		shutdownC = make(chan struct{})
+		errC      = make(chan error)
+		targets   = []config.Target{
+			{URL: srv.URL + "/down", Method: http.MethodGet},
+			{URL: srv.URL + "/up", Method: http.MethodGet},
+		}
+	)
+	if err := c.SetConfirmation(ConfirmRetry, time.Hour); err != nil {
+		t.Fatalf("SetConfirmation() error = %s", err)
+	}
+
+	go func() { errC <- c.Crawl(targets, period, 1, nil, shutdownC) }()
+	time.Sleep(10 * period)
+	overruns := c.Stats().Overruns
+	close(shutdownC)
+	if err := <-errC; err != nil {
+		t.Fatalf("Crawl() error = %s", err)
+	}
+	if overruns != 0 {
+		t.Errorf("got %d overruns, want none", overruns)
+	}
+
+	recs, err := d.GetRecords(time.Now().Add(-time.Minute), time.Now())
+	if err != nil {
+		t.Fatalf("GetRecords() error = %s", err)
+	}
+	var down, up int
+	for _, r := range recs {
+		switch {
+		case r.URL == targets[1].URL && r.Up:
+			up++
+		case r.URL == targets[0].URL && !r.Up && !r.Confirmed:
+			down++
+		default:
+			t.Errorf("unexpected record %+v", r)
+		}
+	}
+	if down != 1 || up < 2 {
+		t.Errorf("got %d unconfirmed failures and %d successful checks, want 1 and more", down, up)
+	}
+}
@@ -0,0 +1,295 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+const (
+	defaultTLSPort = "443"
+	defaultDNSPort = "53"
+)
+
+// dialer opens TCP connections from client source: local address or HTTP
+// proxy, connections through proxy are tunneled with CONNECT method
+type dialer struct {
+	d     *net.Dialer
+	proxy *url.URL
+}
+
+// dial connects to addr filling record DNS and connect timings and error
+// class. Host is resolved locally unless connection is made through proxy.
+func (d *dialer) dial(ctx context.Context, addr string, r *db.Record) (net.Conn, error) {
+	if d.proxy != nil {
+		start := time.Now()
+		conn, err := d.tunnel(ctx, addr)
+		r.Connect = time.Since(start)
+		r.Error = errorClass(err, phaseConnect)
+		return conn, err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		r.Error = ErrOther
+		return nil, err
+	}
+
+	start := time.Now()
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	r.DNS = time.Since(start)
+	if err != nil {
+		r.Error = errorClass(err, phaseDNS)
+		return nil, err
+	}
+
+	start = time.Now()
+	conn, err := d.d.DialContext(ctx, "tcp", net.JoinHostPort(pickIP(ips).String(), port))
+	r.Connect = time.Since(start)
+	r.Error = errorClass(err, phaseConnect)
+	return conn, err
+}
+
+// pickIP prefers IPv4 address, as source addresses usually are
+func pickIP(ips []net.IPAddr) net.IP {
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			return ip.IP
+		}
+	}
+	return ips[0].IP
+}
+
+// tunnel connects to addr through HTTP proxy. Proxy response is read with a
+// buffered reader, that's safe as long as server doesn't speak first, which
+// holds for TLS and TCP connect checks.
+func (d *dialer) tunnel(ctx context.Context, addr string) (net.Conn, error) {
+	host := d.proxy.Host
+	if len(d.proxy.Port()) == 0 {
+		host = net.JoinHostPort(d.proxy.Hostname(), "80")
+		if d.proxy.Scheme == "https" {
+			host = net.JoinHostPort(d.proxy.Hostname(), defaultTLSPort)
+		}
+	}
+
+	conn, err := d.d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if d.proxy.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u := d.proxy.User; u != nil {
+		pass, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s refused to connect to %s: %s", d.proxy.Host, addr, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// targetHost returns target URL host with port, defPort is used if URL has
+// no port
+func targetHost(t *config.Target, defPort string) (*url.URL, string, error) {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = defPort
+	}
+	return u, net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// tcpProbe checks that TCP connection can be established
+type tcpProbe struct {
+	d *dialer
+}
+
+func (p *tcpProbe) Probe(ctx context.Context, t *config.Target, r *db.Record) error {
+	_, addr, err := targetHost(t, "")
+	if err != nil {
+		r.Error = ErrOther
+		return err
+	}
+
+	conn, err := p.d.dial(ctx, addr, r)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// handshake connects to addr and makes TLS handshake with host, server
+// certificate is verified after the handshake, so it's recorded even if it's
+// invalid
+func (d *dialer) handshake(ctx context.Context, addr, host string, r *db.Record) error {
+	conn, err := d.dial(ctx, addr, r)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	start := time.Now()
+	tc := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+	err = tc.Handshake()
+	r.TLS = time.Since(start)
+	if err != nil {
+		r.Error = errorClass(err, phaseTLS)
+		return err
+	}
+
+	r.Cert, err = inspect(tc.ConnectionState().PeerCertificates, host)
+	if err != nil {
+		r.Error = ErrTLS
+	}
+	return err
+}
+
+// tlsProbe checks that TLS handshake succeeds and server certificate is valid
+type tlsProbe struct {
+	d *dialer
+}
+
+func (p *tlsProbe) Probe(ctx context.Context, t *config.Target, r *db.Record) error {
+	u, addr, err := targetHost(t, defaultTLSPort)
+	if err != nil {
+		r.Error = ErrOther
+		return err
+	}
+	return p.d.handshake(ctx, addr, u.Hostname(), r)
+}
+
+// dnsProbe resolves name with the resolver from target URL or the system one,
+// resolver is queried from the source address. Proxies can't be used for
+// DNS checks.
+type dnsProbe struct {
+	local net.IP
+}
+
+func (p *dnsProbe) Probe(ctx context.Context, t *config.Target, r *db.Record) error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		r.Error = ErrOther
+		return err
+	}
+
+	var (
+		server = u.Host
+		name   = strings.Trim(u.Path, "/")
+		res    = &net.Resolver{PreferGo: true}
+	)
+	if len(server) > 0 && len(u.Port()) == 0 {
+		server = net.JoinHostPort(u.Hostname(), defaultDNSPort)
+	}
+	res.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		var d net.Dialer
+		if p.local != nil {
+			if strings.HasPrefix(network, "udp") {
+				d.LocalAddr = &net.UDPAddr{IP: p.local}
+			} else {
+				d.LocalAddr = &net.TCPAddr{IP: p.local}
+			}
+		}
+		if len(server) > 0 {
+			address = server
+		}
+		return d.DialContext(ctx, network, address)
+	}
+
+	start := time.Now()
+	n, err := lookup(ctx, res, strings.ToUpper(u.Query().Get("type")), name)
+	r.DNS = time.Since(start)
+	r.Size = int64(n)
+	if err != nil {
+		r.Error = ErrDNS
+		return err
+	}
+	return nil
+}
+
+// lookup queries records of type typ and returns number of answers, lookup
+// without answers fails
+func lookup(ctx context.Context, res *net.Resolver, typ, name string) (int, error) {
+	var (
+		n   int
+		err error
+	)
+
+	switch typ {
+	case "", "A", "AAAA":
+		var ips []net.IPAddr
+		if ips, err = res.LookupIPAddr(ctx, name); err != nil {
+			return 0, err
+		}
+		for _, ip := range ips {
+			v4 := ip.IP.To4() != nil
+			if typ == "" || (typ == "A") == v4 {
+				n++
+			}
+		}
+	case "CNAME":
+		if _, err = res.LookupCNAME(ctx, name); err == nil {
+			n = 1
+		}
+	case "MX":
+		var mx []*net.MX
+		mx, err = res.LookupMX(ctx, name)
+		n = len(mx)
+	case "NS":
+		var ns []*net.NS
+		ns, err = res.LookupNS(ctx, name)
+		n = len(ns)
+	case "TXT":
+		var txt []string
+		txt, err = res.LookupTXT(ctx, name)
+		n = len(txt)
+	default:
+		return 0, fmt.Errorf("Unsupported DNS record type %s", typ)
+	}
+
+	if err == nil && n == 0 {
+		err = fmt.Errorf("No %s records found for %s", typ, name)
+	}
+	return n, err
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// Probe checks target of a particular kind from a single source. It fills
+// record timings, error class and kind specific fields, the rest of the
+// record is filled by the caller. Returned error is logged.
+type Probe interface {
+	Probe(ctx context.Context, t *config.Target, r *db.Record) error
+}
+
+// newProbes creates probes for every supported target URL scheme checked
+// from the source: local address or proxy, content tracker is shared by all
+// the sources
+func newProbes(hc *http.Client, addr *net.TCPAddr, proxy *url.URL, timeout time.Duration,
+	content *contentTracker) map[string]Probe {
+	var (
+		d     = &dialer{d: &net.Dialer{Timeout: timeout}, proxy: proxy}
+		local net.IP
+	)
+	if addr != nil {
+		d.d.LocalAddr = addr
+		local = addr.IP
+	}
+
+	hp := &httpProbe{c: hc, d: d, content: content}
+	return map[string]Probe{
+		"http":  hp,
+		"https": hp,
+		"tcp":   &tcpProbe{d: d},
+		"tls":   &tlsProbe{d: d},
+		"dns":   &dnsProbe{local: local},
+	}
+}
+
+// httpProbe sends HTTP request and evaluates target assertions against the
+// response, server certificate of HTTPS targets and, if target content is
+// watched, content hash are recorded as well
+type httpProbe struct {
+	c       *http.Client
+	d       *dialer // used to inspect certificates that failed verification
+	content *contentTracker
+}
+
+func (p *httpProbe) Probe(ctx context.Context, target *config.Target, r *db.Record) error {
+	_, body, err := p.do(ctx, target, r, target.Assert.NeedBody() || target.Content != nil)
+	if err != nil {
+		return err
+	}
+	if target.Content != nil && len(r.Error) == 0 {
+		p.content.observe(targetKey(target), r, target.Content.Normalize(body), time.Now())
+	}
+	return nil
+}
+
+// do sends target request and evaluates its assertions, response body is
+// returned if readBody is set, otherwise it's discarded
+func (p *httpProbe) do(ctx context.Context, target *config.Target, r *db.Record,
+	readBody bool) (*http.Response, []byte, error) {
+	t := &timings{start: time.Now()}
+
+	var (
+		resp *http.Response
+		body []byte
+	)
+	req, err := newRequest(target)
+	if err == nil {
+		ctx = httptrace.WithClientTrace(ctx, t.trace())
+		if target.Redirects != nil {
+			ctx = context.WithValue(ctx, redirectsKey{}, *target.Redirects)
+		}
+		req = req.WithContext(ctx)
+
+		resp, err = p.c.Do(req)
+		if err == nil {
+			if readBody {
+				body, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxBody))
+				r.Size = int64(len(body))
+			}
+			if err == nil {
+				var n int64
+				n, err = io.Copy(ioutil.Discard, resp.Body)
+				r.Size += n
+			}
+			resp.Body.Close()
+		}
+	}
+
+	t.mu.Lock()
+	r.DNS, r.Connect, r.TLS, r.TTFB = t.dns, t.connect, t.tls, t.ttfb
+	r.Error = errorClass(err, t.phase)
+	certs := t.certs
+	t.mu.Unlock()
+
+	if req != nil && req.URL.Scheme == "https" {
+		r.Cert = p.cert(ctx, req.URL, certs, r.Error == ErrTLS)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	r.StatusCode = resp.StatusCode
+	r.Error, r.Assertion = assert(&target.Assert, resp, body)
+	return resp, body, nil
+}
+
+// cert inspects certificate chain target server presented or, if handshake
+// failed, makes another handshake without verification to get the certificate.
+// Verified chains are told apart from the proxy and redirect ones by host name.
+func (p *httpProbe) cert(ctx context.Context, u *url.URL, chains [][]*x509.Certificate, failed bool) *db.Certificate {
+	for _, certs := range chains {
+		if certs[0].VerifyHostname(u.Hostname()) == nil {
+			c, _ := inspect(certs, u.Hostname())
+			return c
+		}
+	}
+	if !failed {
+		return nil
+	}
+
+	port := u.Port()
+	if len(port) == 0 {
+		port = defaultTLSPort
+	}
+	r := &db.Record{}
+	p.d.handshake(ctx, net.JoinHostPort(u.Hostname(), port), u.Hostname(), r)
+	return r.Cert
+}
+
+// probe checks target with the probe of its URL scheme
+func (c *client) probe(ctx context.Context, t *config.Target, r *db.Record) error {
+	p, ok := c.probes[t.Scheme()]
+	if !ok {
+		r.Error = ErrOther
+		return fmt.Errorf("No probe for %s", t.URL)
+	}
+	return p.Probe(ctx, t, r)
+}
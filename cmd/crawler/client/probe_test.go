@@ -0,0 +1,408 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// connectProxy is a HTTP proxy test server supporting CONNECT method only
+func connectProxy(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack connection: %s", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+		buf.Flush()
+
+		go io.Copy(upstream, bufio.NewReader(conn))
+		io.Copy(conn, upstream)
+	}))
+}
+
+func TestProbes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsSrv.Close()
+	proxy := connectProxy(t)
+	defer proxy.Close()
+
+	// closed port to get connection refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	var (
+		host      = srv.Listener.Addr().String()
+		tlsHost   = tlsSrv.Listener.Addr().String()
+		proxyURL  = &url.URL{Scheme: "http", Host: proxy.Listener.Addr().String()}
+		direct    = newClient(time.Second, nil, nil, false, "127.0.0.1", "", nil)
+		proxied   = newClient(time.Second, nil, proxyURL, false, proxyURL.String(), proxyURL.String(), nil)
+		localhost = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+		sourced   = newClient(time.Second, localhost, nil, false, "127.0.0.1", "127.0.0.1", nil)
+	)
+
+	tests := []struct {
+		c    *client
+		url  string
+		want string
+	}{
+		{direct, "tcp://" + host, ""},
+		{sourced, "tcp://" + host, ""},
+		{proxied, "tcp://" + host, ""},
+		{direct, "tcp://" + closed, ErrRefused},
+		{proxied, "tcp://" + closed, ErrConnect},
+		{direct, "tcp://nonexistent.invalid:80", ErrDNS},
+		// self-signed certificate
+		{direct, "tls://" + tlsHost, ErrTLS},
+		{proxied, "tls://" + tlsHost, ErrTLS},
+		// not a TLS server
+		{direct, "tls://" + host, ErrTLS},
+		{direct, "dns://127.0.0.1:1/example.com", ErrDNS},
+		{direct, "http://" + host, ""},
+	}
+
+	for _, tt := range tests {
+		target := &config.Target{URL: tt.url, Method: http.MethodGet}
+		r := tt.c.check(target)
+		if r.Error != tt.want || r.Up != (len(tt.want) == 0) {
+			t.Errorf("%s from %s: error %q, up %t, want %q", tt.url, tt.c.a, r.Error, r.Up, tt.want)
+		}
+	}
+}
+
+// timeoutError is a net.Error timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err   error
+		phase phase
+		want  string
+	}{
+		{timeoutError{}, phaseStart, ErrTimeout},
+		{timeoutError{}, phaseDNS, ErrDNS},
+		{timeoutError{}, phaseConnect, ErrConnectTimeout},
+		{timeoutError{}, phaseTLS, ErrTLSTimeout},
+		{timeoutError{}, phaseWait, ErrTimeout},
+		{&net.DNSError{Err: "no such host", Name: "a"}, phaseStart, ErrDNS},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, phaseConnect, ErrRefused},
+		{nil, phaseBody, ""},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err, tt.phase); got != tt.want {
+			t.Errorf("errorClass(%v, %d) = %q, want %q", tt.err, tt.phase, got, tt.want)
+		}
+	}
+}
+
+func TestLatencyAssertion(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer l.Close()
+
+	var (
+		c      = newClient(time.Second, nil, nil, false, "127.0.0.1", "", nil)
+		target = &config.Target{URL: "tcp://" + l.Addr().String(), Method: http.MethodGet}
+	)
+	target.Assert.MaxLatency = time.Nanosecond
+	r := c.check(target)
+	if r.Up || r.Error != ErrAssertion || len(r.Assertion) == 0 {
+		t.Errorf("unexpected record %+v", r)
+	}
+
+	// probe of unknown scheme fails without panic
+	r = &db.Record{}
+	if err = c.probe(context.Background(), &config.Target{URL: "ftp://example.com"}, r); err == nil {
+		t.Errorf("probe() of unknown scheme succeeded")
+	}
+}
+
+func TestCertificates(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var (
+		host   = srv.Listener.Addr().String()
+		leaf   = srv.Certificate()
+		c      = newClient(time.Second, nil, nil, false, "127.0.0.1", "", nil)
+		target = func(url string) *config.Target { return &config.Target{URL: url, Method: http.MethodGet} }
+	)
+
+	// self-signed certificate is recorded even though check fails
+	for _, url := range []string{"tls://" + host, "https://" + host} {
+		r := c.check(target(url))
+		if r.Error != ErrTLS || r.Cert == nil {
+			t.Fatalf("%s: error %q, certificate %+v, want tls error with certificate", url, r.Error, r.Cert)
+		}
+		if !r.Cert.Expiry.Equal(leaf.NotAfter) || r.Cert.ChainValid || !r.Cert.HostValid {
+			t.Errorf("%s: unexpected certificate %+v", url, r.Cert)
+		}
+	}
+
+	// certificate of successful HTTPS check
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	c.probes["https"].(*httpProbe).c.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: roots}
+	r := c.check(target("https://" + host))
+	if !r.Up || r.Cert == nil || !r.Cert.HostValid || len(r.Cert.SANs) == 0 {
+		t.Errorf("unexpected record %+v, certificate %+v", r, r.Cert)
+	}
+
+	// plain HTTP check has no certificate
+	if r = c.check(target("http://" + host)); r.Cert != nil {
+		t.Errorf("http check certificate %+v, want none", r.Cert)
+	}
+}
+
+func TestContent(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		page = "<h1>Welcome</h1>"
+		tick int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tick++
+		fmt.Fprintf(w, "<p>time %d</p>%s", tick, page)
+	}))
+	defer srv.Close()
+
+	var (
+		tracker = newContentTracker()
+		a       = newClient(time.Second, nil, nil, false, "10.0.0.1", "", tracker)
+		b       = newClient(time.Second, nil, nil, false, "10.0.0.2", "", tracker)
+		target  = &config.Target{URL: srv.URL, Method: http.MethodGet,
+			Content: &config.Content{Ignore: []*regexp.Regexp{regexp.MustCompile(`<p>time \d+</p>`)}}}
+		set = func(s string) {
+			mu.Lock()
+			page = s
+			mu.Unlock()
+		}
+	)
+
+	checks := []struct {
+		c      *client
+		page   string
+		change string
+	}{
+		{a, "<h1>Welcome</h1>", ""},
+		{b, "<h1>Welcome</h1>", ""},
+		{a, "<h1>Welcome</h1>", ""}, // ignored region changes only
+		{a, "<h1>Hacked</h1>", db.ContentChanged},
+		{b, "<h1>Welcome</h1>", db.ContentDiffers}, // stale source
+		{b, "<h1>Welcome</h1>", ""},                // flagged once
+		{b, "<h1>Hacked</h1>", db.ContentChanged},
+		{a, "<h1>Hacked</h1>", ""},
+	}
+	for i, c := range checks {
+		set(c.page)
+		r := c.c.check(target)
+		if !r.Up || len(r.ContentHash) != 64 {
+			t.Fatalf("check %d: unexpected record %+v", i, r)
+		}
+		kind := ""
+		if r.Content != nil {
+			kind = r.Content.Kind
+		}
+		if kind != c.change {
+			t.Fatalf("check %d: content change %q, want %q", i, kind, c.change)
+		}
+	}
+
+	set("<h1>Welcome</h1>")
+	r := a.check(target)
+	if r.Content == nil || r.Content.Before != "<h1>Hacked</h1>" || r.Content.After != "<h1>Welcome</h1>" {
+		t.Errorf("content change %+v, want snippets of both versions", r.Content)
+	}
+
+	// content of unwatched targets is dropped
+	tracker.forget(map[string]bool{})
+	if r = a.check(target); r.Content != nil {
+		t.Errorf("content change %+v after target was forgotten", r.Content)
+	}
+}
+
+func TestContentTracker(t *testing.T) {
+	var (
+		tracker = newContentTracker()
+		now     = time.Now()
+		long    = strings.Repeat("a", 2*headSize)
+		observe = func(key, body string) *db.Record {
+			r := &db.Record{URL: "http://a", LocalIP: "10.0.0.1"}
+			tracker.observe(key, r, []byte(body), now)
+			return r
+		}
+	)
+
+	// targets with the same URL and different methods are tracked apart
+	observe("GET http://a", "get")
+	if r := observe("POST http://a", "post"); r.Content != nil {
+		t.Errorf("content change %+v of another target", r.Content)
+	}
+
+	// only the head of content is kept
+	observe("GET http://a", long+"x")
+	if n := len(tracker.targets["GET http://a"]["10.0.0.1"].head); n != headSize {
+		t.Errorf("kept %d bytes, want %d", n, headSize)
+	}
+	r := observe("GET http://a", long+"y")
+	if r.Content == nil || r.Content.Kind != db.ContentChanged || len(r.Content.After) == 0 {
+		t.Errorf("content change %+v past the kept head", r.Content)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		body string
+		off  int
+		want string
+	}{
+		{"<h1>Welcome</h1>", 4, "<h1>Welcome</h1>"},
+		{"a\x00b", 0, "ab"},
+		{"a\xff\xfeb\xc0", 0, "a\uFFFDb\uFFFD"},
+		{strings.Repeat("я", snippetSize), 0, strings.Repeat("я", snippetSize/2)},
+	}
+	for _, tt := range tests {
+		if got := snippet([]byte(tt.body), tt.off); got != tt.want {
+			t.Errorf("snippet(%q, %d) = %q, want %q", tt.body, tt.off, got, tt.want)
+		}
+	}
+}
+
+// observed is an Observer collecting records
+type observed struct {
+	recs []*db.Record
+}
+
+func (o *observed) Observe(r *db.Record) {
+	o.recs = append(o.recs, r)
+}
+
+func TestTransaction(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		broken bool
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+		fmt.Fprint(w, `{"auth": {"token": "t1"}, "items": [{"id": 7}]}`)
+	})
+	mux.HandleFunc("/cart", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		c, err := r.Cookie("session")
+		if broken || err != nil || c.Value != "s1" || r.Header.Get("Authorization") != "Bearer t1" ||
+			r.URL.Query().Get("item") != "7" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `<div data-order="42">`)
+	})
+	mux.HandleFunc("/orders/42", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "confirmed")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	targets, err := config.Parse(strings.NewReader(`
+- url: ` + srv.URL + `
+  steps:
+    - name: login
+      url: /login
+      method: POST
+      extract:
+        token: {json: auth.token}
+        item: {json: items.0.id}
+    - name: cart
+      url: /cart?item=${item}
+      headers: {Authorization: 'Bearer ${token}'}
+      assert: {status: 200}
+      extract:
+        order: {regexp: 'data-order="(\d+)"'}
+    - url: /orders/${order}
+      assert: {body: confirmed}
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %s", err)
+	}
+
+	c := newClient(time.Second, nil, nil, false, "127.0.0.1", "", nil)
+	recs := c.transaction(&targets[0])
+	want := []string{"login", "cart", "3", ""}
+	if len(recs) != len(want) {
+		t.Fatalf("transaction() returned %d records, want %d", len(recs), len(want))
+	}
+	for i, r := range recs {
+		if r.URL != srv.URL || r.Step != want[i] || !r.Up {
+			t.Errorf("record %d: %+v, want step %q up", i, r, want[i])
+		}
+	}
+
+	// only the target record is observed, step ones are saved
+	var (
+		jC  = make(chan *check, 1)
+		rC  = make(chan *db.Record, len(want))
+		obs = &observed{}
+	)
+	jC <- &check{j: newJob(&targets[0], time.Minute, []*client{c})}
+	close(jC)
+	c.Check(jC, rC, []Observer{obs}, nil)
+	if len(rC) != len(want) || len(obs.recs) != 1 || len(obs.recs[0].Step) > 0 {
+		t.Errorf("saved %d records, observed %+v, want %d saved and the target one observed",
+			len(rC), obs.recs, len(want))
+	}
+
+	mu.Lock()
+	broken = true
+	mu.Unlock()
+	recs = c.transaction(&targets[0])
+	if len(recs) != 3 || !recs[0].Up || recs[1].Up {
+		t.Fatalf("transaction() = %+v, want login up, cart down and no more steps", recs)
+	}
+	if r := recs[2]; r.Up || r.URL != srv.URL || len(r.Step) > 0 || r.Error != ErrStatus || !strings.HasPrefix(r.Assertion, "step cart: ") {
+		t.Errorf("target record %+v, want failed cart step", r)
+	}
+}
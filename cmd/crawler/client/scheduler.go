@@ -0,0 +1,203 @@
+package client
+
+import (
+	"container/heap"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+)
+
+// job is a scheduled target check. Jobs are read by workers concurrently, so
+// they aren't modified once scheduled except for the fields used by the
+// scheduler only, reloaded targets get new jobs.
+type job struct {
+	t        *config.Target
+	interval time.Duration
+	due      time.Time
+	sources  []*client
+	next     int    // next source client index
+	index    int    // index in schedule heap
+
+	// Counters shared with replaced jobs, accessed atomically
+	running    *int32 // number of checks in progress
+	confirming *int32 // number of failed checks waiting for confirmation or being confirmed
+}
+
+// newJob creates job checking t every interval from sources
+func newJob(t *config.Target, interval time.Duration, sources []*client) *job {
+	return &job{t: t, interval: interval, sources: sources, running: new(int32), confirming: new(int32)}
+}
+
+// source returns next client to check the target from
+func (j *job) source() *client {
+	cl := j.sources[j.next%len(j.sources)]
+	j.next++
+	return cl
+}
+
+// other returns job source other than c to confirm its check from, c itself
+// if it's the only one
+func (j *job) other(c *client) *client {
+	for i, cl := range j.sources {
+		if cl == c {
+			return j.sources[(i+1)%len(j.sources)]
+		}
+	}
+	return c
+}
+
+func (j *job) start() {
+	atomic.AddInt32(j.running, 1)
+}
+
+func (j *job) done() {
+	atomic.AddInt32(j.running, -1)
+}
+
+// waitConfirmation marks check in progress as waiting for confirmation
+func (j *job) waitConfirmation() {
+	atomic.AddInt32(j.confirming, 1)
+	j.done()
+}
+
+// confirmed marks failed check confirmation finished
+func (j *job) confirmed() {
+	atomic.AddInt32(j.confirming, -1)
+}
+
+// schedule is a min-heap of jobs ordered by due time
+type schedule []*job
+
+func (s schedule) Len() int           { return len(s) }
+func (s schedule) Less(i, j int) bool { return s[i].due.Before(s[j].due) }
+
+func (s schedule) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	s[i].index = i
+	s[j].index = j
+}
+
+func (s *schedule) Push(x interface{}) {
+	j := x.(*job)
+	j.index = len(*s)
+	*s = append(*s, j)
+}
+
+func (s *schedule) Pop() interface{} {
+	old := *s
+	j := old[len(old)-1]
+	*s = old[:len(old)-1]
+	j.index = -1
+	return j
+}
+
+// scheduler keeps track of next due time of every target. Initial due times
+// are spread randomly across target interval, so checks don't come in bursts.
+// Confirmations of failed checks are scheduled as well.
+type scheduler struct {
+	jobs     schedule
+	confirms []*check // ordered by due time, as confirmation delay is the same for all
+	rnd      *rand.Rand
+	overruns uint64 // accessed atomically
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// add schedules new job with random initial delay within its interval
+func (s *scheduler) add(j *job, now time.Time) {
+	j.due = now.Add(time.Duration(s.rnd.Int63n(int64(j.interval))))
+	heap.Push(&s.jobs, j)
+}
+
+// remove unschedules the job
+func (s *scheduler) remove(j *job) {
+	if j.index >= 0 && j.index < len(s.jobs) && s.jobs[j.index] == j {
+		heap.Remove(&s.jobs, j.index)
+	}
+}
+
+// replace schedules nj instead of j, nj takes over j schedule and checks in
+// progress, which finish using j
+func (s *scheduler) replace(j, nj *job) {
+	nj.due, nj.next, nj.running, nj.confirming = j.due, j.next, j.running, j.confirming
+	if j.index >= 0 && j.index < len(s.jobs) && s.jobs[j.index] == j {
+		nj.index = j.index
+		s.jobs[j.index] = nj
+		j.index = -1
+	}
+}
+
+// fix restores heap order after job due time change
+func (s *scheduler) fix(j *job) {
+	if j.index >= 0 && j.index < len(s.jobs) && s.jobs[j.index] == j {
+		heap.Fix(&s.jobs, j.index)
+	}
+}
+
+// confirm schedules repeated check confirming the failure at its due time
+func (s *scheduler) confirm(ch *check) {
+	s.confirms = append(s.confirms, ch)
+}
+
+// popConfirm returns the next repeated check if it's due, nil otherwise
+func (s *scheduler) popConfirm(now time.Time) *check {
+	if len(s.confirms) == 0 || s.confirms[0].due.After(now) {
+		return nil
+	}
+	ch := s.confirms[0]
+	s.confirms[0] = nil
+	s.confirms = s.confirms[1:]
+	return ch
+}
+
+// wait returns time left till the next job or repeated check is due
+func (s *scheduler) wait(now time.Time) (time.Duration, bool) {
+	var due time.Time
+	if len(s.jobs) > 0 {
+		due = s.jobs[0].due
+	}
+	if len(s.confirms) > 0 && (due.IsZero() || s.confirms[0].due.Before(due)) {
+		due = s.confirms[0].due
+	}
+	if due.IsZero() {
+		return 0, false
+	}
+	return due.Sub(now), true
+}
+
+// pop returns the next job if it's due, nil otherwise. The job is
+// rescheduled to its next round. If the previous check of the job is still in
+// progress or the job is late for more than its interval, round overrun is
+// logged and skip is set, missed rounds are not caught up. Round is skipped
+// without overrun if the previous check failure is being confirmed, e.g. with
+// confirmation delay longer than the interval.
+func (s *scheduler) pop(now time.Time) (j *job, skip bool) {
+	if len(s.jobs) == 0 || s.jobs[0].due.After(now) {
+		return nil, false
+	}
+
+	j = s.jobs[0]
+	if atomic.LoadInt32(j.running) > 0 {
+		log.Printf("Warning: %s check overruns its %s interval, skipping round\n", j.t.URL, j.interval)
+		atomic.AddUint64(&s.overruns, 1)
+		skip = true
+	} else if atomic.LoadInt32(j.confirming) > 0 {
+		skip = true
+	}
+
+	j.due = j.due.Add(j.interval)
+	if late := now.Sub(j.due); late >= 0 {
+		missed := late/j.interval + 1
+		log.Printf("Warning: %s check is %s late, skipping %d round(s)\n", j.t.URL, late+j.interval, missed)
+		atomic.AddUint64(&s.overruns, 1)
+		j.due = j.due.Add(missed * j.interval)
+	}
+	heap.Fix(&s.jobs, 0)
+
+	return j, skip
+}
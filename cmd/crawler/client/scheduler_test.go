@@ -0,0 +1,133 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+)
+
+func TestSchedulerSpread(t *testing.T) {
+	var (
+		s        = newScheduler()
+		now      = time.Now()
+		interval = time.Minute
+		targets  = make([]config.Target, 100)
+	)
+
+	for i := range targets {
+		s.add(newJob(&targets[i], interval, []*client{{}}), now)
+	}
+
+	var (
+		prev  time.Time
+		dues  = map[time.Time]bool{}
+		count int
+	)
+	for j, _ := s.pop(now.Add(interval)); j != nil; j, _ = s.pop(now.Add(interval)) {
+		due := j.due.Add(-interval)
+		if due.Before(now) || !due.Before(now.Add(interval)) {
+			t.Errorf("due time %s is out of [%s, %s)", due, now, now.Add(interval))
+		}
+		if due.Before(prev) {
+			t.Errorf("due time %s is before previous %s", due, prev)
+		}
+		prev = due
+		dues[due] = true
+		if count++; count == len(targets) {
+			break
+		}
+	}
+
+	if count != len(targets) {
+		t.Errorf("popped %d jobs, want %d", count, len(targets))
+	}
+	if len(dues) < len(targets)/2 {
+		t.Errorf("checks are not spread: %d distinct due times for %d targets", len(dues), len(targets))
+	}
+}
+
+func TestSchedulerIntervals(t *testing.T) {
+	var (
+		s    = newScheduler()
+		now  = time.Now()
+		fast = newJob(&config.Target{URL: "fast"}, 10*time.Second, nil)
+		slow = newJob(&config.Target{URL: "slow"}, time.Minute, nil)
+	)
+	s.add(fast, now)
+	s.add(slow, now)
+
+	counts := map[*job]int{}
+	popAll := func(cur time.Time) {
+		for j, skip := s.pop(cur); j != nil; j, skip = s.pop(cur) {
+			if skip {
+				t.Errorf("unexpected skip of %s", j.t.URL)
+			}
+			counts[j]++
+		}
+	}
+
+	// Every round due within [now, end) should be popped exactly once
+	end := now.Add(10 * time.Minute)
+	for cur := now; cur.Before(end); cur = cur.Add(time.Second) {
+		popAll(cur)
+	}
+	popAll(end.Add(-1))
+
+	if counts[fast] != 60 {
+		t.Errorf("fast job checked %d times, want 60", counts[fast])
+	}
+	if counts[slow] != 10 {
+		t.Errorf("slow job checked %d times, want 10", counts[slow])
+	}
+	if s.overruns != 0 {
+		t.Errorf("overruns = %d, want 0", s.overruns)
+	}
+}
+
+func TestSchedulerOverrun(t *testing.T) {
+	var (
+		s   = newScheduler()
+		now = time.Now()
+		j   = newJob(&config.Target{URL: "test"}, time.Minute, nil)
+	)
+	s.add(j, now)
+
+	cur := now.Add(time.Minute)
+	if got, skip := s.pop(cur); got != j || skip {
+		t.Fatalf("pop() = %v, %t, want job without skip", got, skip)
+	}
+
+	j.start()
+	cur = cur.Add(time.Minute)
+	if got, skip := s.pop(cur); got != j || !skip {
+		t.Fatalf("pop() = %v, %t, want job with skip as previous check is running", got, skip)
+	}
+	j.done()
+
+	cur = cur.Add(5 * time.Minute)
+	if got, skip := s.pop(cur); got != j || skip {
+		t.Fatalf("pop() = %v, %t, want late job without skip", got, skip)
+	}
+	if !j.due.After(cur) {
+		t.Errorf("missed rounds are not skipped: next due %s is not after %s", j.due, cur)
+	}
+	if s.overruns != 2 {
+		t.Errorf("overruns = %d, want 2", s.overruns)
+	}
+
+	// failure confirmation outlasting the interval isn't an overrun
+	j.start()
+	j.waitConfirmation()
+	cur = j.due
+	if got, skip := s.pop(cur); got != j || !skip {
+		t.Fatalf("pop() = %v, %t, want job with skip as previous check is being confirmed", got, skip)
+	}
+	j.confirmed()
+	if got, skip := s.pop(j.due); got != j || skip {
+		t.Fatalf("pop() = %v, %t, want job without skip", got, skip)
+	}
+	if s.overruns != 2 {
+		t.Errorf("overruns = %d, want 2", s.overruns)
+	}
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Normalized error classes stored in db.Record.Error
+const (
+	ErrDNS            = "dns"
+	ErrRefused        = "refused"
+	ErrConnect        = "connect"
+	ErrConnectTimeout = "connect_timeout"
+	ErrTLS            = "tls"
+	ErrTLSTimeout     = "tls_timeout"
+	ErrTimeout        = "timeout"
+	ErrStatus         = "status"
+	ErrOther          = "other"
+)
+
+type phase int
+
+const (
+	phaseStart phase = iota
+	phaseDNS
+	phaseConnect
+	phaseTLS
+	phaseWait
+	phaseBody
+)
+
+// timings collects request phase durations through httptrace. With redirects
+// followed, durations of all the hops are summed up.
+type timings struct {
+	mu    sync.Mutex
+	start time.Time
+	phase phase
+
+	dnsStart, connStart, tlsStart time.Time
+
+	dns, connect, tls, ttfb time.Duration
+
+	certs [][]*x509.Certificate // chains of successful TLS handshakes: proxy, target, redirects
+}
+
+func (t *timings) set(p phase) {
+	t.mu.Lock()
+	t.phase = p
+	t.mu.Unlock()
+}
+
+func (t *timings) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.phase = phaseDNS
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dns += time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			t.phase = phaseConnect
+			if t.connStart.IsZero() {
+				t.connStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			if !t.connStart.IsZero() {
+				t.connect += time.Since(t.connStart)
+				t.connStart = time.Time{}
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.phase = phaseTLS
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			t.mu.Lock()
+			t.tls += time.Since(t.tlsStart)
+			if err == nil && len(state.PeerCertificates) > 0 {
+				t.certs = append(t.certs, state.PeerCertificates)
+			}
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.set(phaseWait)
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.phase = phaseBody
+			if t.ttfb == 0 {
+				t.ttfb = time.Since(t.start)
+			}
+			t.mu.Unlock()
+		},
+	}
+}
+
+// cause unwraps url, net and syscall errors down to the root cause
+func cause(err error) error {
+	for {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			return err
+		}
+	}
+}
+
+// errorClass maps check error to one of the normalized error classes. Phase
+// the request was in when it failed is used to tell apart different kinds
+// of timeouts, timeout before DNS lookup or connect started, e.g. of IP
+// literal or proxied target, isn't a DNS one.
+func errorClass(err error, p phase) string {
+	if err == nil {
+		return ""
+	}
+
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		switch p {
+		case phaseDNS:
+			return ErrDNS
+		case phaseConnect:
+			return ErrConnectTimeout
+		case phaseTLS:
+			return ErrTLSTimeout
+		default:
+			return ErrTimeout
+		}
+	}
+
+	c := cause(err)
+	if _, ok := c.(*net.DNSError); ok {
+		return ErrDNS
+	}
+	if errno, ok := c.(syscall.Errno); ok {
+		if errno == syscall.ECONNREFUSED {
+			return ErrRefused
+		}
+		if p <= phaseConnect {
+			return ErrConnect
+		}
+	}
+	if _, ok := c.(tls.RecordHeaderError); ok {
+		return ErrTLS
+	}
+	if msg := c.Error(); strings.HasPrefix(msg, "x509:") || strings.HasPrefix(msg, "tls:") ||
+		strings.Contains(msg, "certificate") {
+		return ErrTLS
+	}
+
+	switch p {
+	case phaseDNS:
+		return ErrDNS
+	case phaseConnect:
+		return ErrConnect
+	case phaseTLS:
+		return ErrTLS
+	}
+	return ErrOther
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// transaction checks multi-step target: steps are made in order sharing a
+// cookie jar until the first failed one, values extracted from step responses
+// are substituted into the following steps. It returns a record per made step,
+// with the step name set, followed by the target record, that is up only if
+// every step succeeded.
+func (c *client) transaction(target *config.Target) []*db.Record {
+	var (
+		recs  []*db.Record
+		res   = &db.Record{URL: target.URL, LocalIP: c.a}
+		start = time.Now()
+	)
+
+	timeout := target.Timeout
+	if timeout == 0 {
+		timeout = c.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	p, ok := c.probes[target.Scheme()].(*httpProbe)
+	if !ok {
+		res.Error = ErrOther
+		log.Printf("No probe for %s\n", target.URL)
+	} else {
+		p = p.session()
+		vars := map[string]string{}
+		for i := range target.Steps {
+			s := &target.Steps[i]
+			r := c.step(ctx, p, target.URL, s, vars)
+			recs = append(recs, r)
+
+			res.StatusCode = r.StatusCode
+			res.Size += r.Size
+			if !r.Up {
+				res.Error, res.Assertion = r.Error, r.Assertion
+				if len(res.Assertion) == 0 {
+					res.Assertion = r.Error
+				}
+				res.Assertion = fmt.Sprintf("step %s: %s", s.Name, res.Assertion)
+				break
+			}
+		}
+	}
+
+	finish(res, start, target.Assert.MaxLatency)
+	return append(recs, res)
+}
+
+// step makes step request and adds values extracted from the response to
+// vars, step record URL is the target one
+func (c *client) step(ctx context.Context, p *httpProbe, base string, s *config.Step,
+	vars map[string]string) *db.Record {
+	var (
+		r     = &db.Record{URL: base, LocalIP: c.a, Step: s.Name}
+		start = time.Now()
+		resp  *http.Response
+		body  []byte
+	)
+
+	t, err := s.Request(base, vars)
+	if err != nil {
+		r.Error = ErrOther
+	} else {
+		resp, body, err = p.do(ctx, t, r, s.NeedBody())
+	}
+	if err != nil {
+		log.Println(err)
+	} else if len(r.Error) == 0 {
+		for i := range s.Extract {
+			e := &s.Extract[i]
+			v, err := extract(e, resp, body)
+			if err != nil {
+				r.Error, r.Assertion = ErrAssertion, fmt.Sprintf("extract %s: %s", e.Name, err)
+				break
+			}
+			vars[e.Name] = v
+		}
+	}
+
+	finish(r, start, s.Target.Assert.MaxLatency)
+	return r
+}
+
+// session returns a copy of the probe with its own cookie jar
+func (p *httpProbe) session() *httpProbe {
+	// cookiejar.New never fails without options
+	jar, _ := cookiejar.New(nil)
+	hc := *p.c
+	hc.Jar = jar
+	return &httpProbe{c: &hc, d: p.d, content: p.content}
+}
+
+// extract returns value e extracts from step response
+func extract(e *config.Extract, resp *http.Response, body []byte) (string, error) {
+	switch {
+	case len(e.Header) > 0:
+		v := resp.Header.Get(e.Header)
+		if len(v) == 0 {
+			return "", fmt.Errorf("header %s is missing", e.Header)
+		}
+		return v, nil
+	case e.Regexp != nil:
+		m := e.Regexp.FindSubmatch(body)
+		if m == nil {
+			return "", fmt.Errorf("body does not match %q", e.Regexp)
+		}
+		if len(m) > 1 {
+			return string(m[1]), nil
+		}
+		return string(m[0]), nil
+	}
+	return extractJSON(body, e.JSON)
+}
+
+// extractJSON returns JSON body field at dot separated path, numeric path
+// elements are array indexes. Objects and arrays are returned as JSON.
+func extractJSON(body []byte, path string) (string, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("invalid json body: %s", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if field, ok := node[key]; ok {
+				v = field
+				continue
+			}
+		case []interface{}:
+			if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(node) {
+				v = node[i]
+				continue
+			}
+		}
+		return "", fmt.Errorf("json field %s is missing", path)
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "", fmt.Errorf("json field %s is null", path)
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
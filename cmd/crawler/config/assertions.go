@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusRange is an inclusive range of accepted HTTP status codes
+type StatusRange struct {
+	From int
+	To   int
+}
+
+// Contains checks if status code is within the range
+func (r StatusRange) Contains(code int) bool {
+	return code >= r.From && code <= r.To
+}
+
+func (r StatusRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// DefaultStatus is used when target has no status assertion: anything but
+// server errors is considered up
+var DefaultStatus = []StatusRange{{100, 499}}
+
+// Header is a response header assertion. Empty Value only requires the header
+// to be present, otherwise header value should contain Value.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Assertions are the success criteria evaluated against the check response
+type Assertions struct {
+	Status       []StatusRange
+	BodyContains []string
+	BodyExcludes []string
+	BodyMatch    []*regexp.Regexp
+	BodyNotMatch []*regexp.Regexp
+	Headers      []Header
+	MaxLatency   time.Duration
+}
+
+// NeedBody reports if response body has to be read into memory to evaluate
+// assertions
+func (a *Assertions) NeedBody() bool {
+	return len(a.BodyContains) > 0 || len(a.BodyExcludes) > 0 ||
+		len(a.BodyMatch) > 0 || len(a.BodyNotMatch) > 0
+}
+
+// parseStatus parses comma separated list of status codes and ranges,
+// e.g. "200-299,301"
+func parseStatus(s string) ([]StatusRange, error) {
+	var res []StatusRange
+	for _, part := range strings.Split(s, ",") {
+		var (
+			r   StatusRange
+			err error
+		)
+
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		if r.From, err = strconv.Atoi(bounds[0]); err != nil {
+			return nil, fmt.Errorf("invalid status code %q", bounds[0])
+		}
+		r.To = r.From
+		if len(bounds) == 2 {
+			if r.To, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid status code %q", bounds[1])
+			}
+		}
+		if r.From < 100 || r.To > 599 || r.From > r.To {
+			return nil, fmt.Errorf("invalid status range %q", part)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func parseHeader(s string) (Header, error) {
+	kv := strings.SplitN(s, ":", 2)
+	h := Header{Name: strings.TrimSpace(kv[0])}
+	if len(h.Name) == 0 {
+		return h, fmt.Errorf("empty header name in %q", s)
+	}
+	if len(kv) == 2 {
+		h.Value = strings.TrimSpace(kv[1])
+	}
+	return h, nil
+}
+
+// set applies single key=value assertion option to a
+func (a *Assertions) set(key, value string) error {
+	var err error
+
+	switch key {
+	case "status":
+		var r []StatusRange
+		if r, err = parseStatus(value); err == nil {
+			a.Status = append(a.Status, r...)
+		}
+	case "body":
+		a.BodyContains = append(a.BodyContains, value)
+	case "body!":
+		a.BodyExcludes = append(a.BodyExcludes, value)
+	case "body~":
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(value); err == nil {
+			a.BodyMatch = append(a.BodyMatch, re)
+		}
+	case "body!~":
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(value); err == nil {
+			a.BodyNotMatch = append(a.BodyNotMatch, re)
+		}
+	case "header":
+		var h Header
+		if h, err = parseHeader(value); err == nil {
+			a.Headers = append(a.Headers, h)
+		}
+	case "latency":
+		a.MaxLatency, err = time.ParseDuration(value)
+		if err == nil && a.MaxLatency <= 0 {
+			err = fmt.Errorf("latency should be positive")
+		}
+	default:
+		err = fmt.Errorf("unknown option %q", key)
+	}
+
+	return err
+}
+
+// splitFields splits config line into whitespace separated fields, double
+// quoted values may contain spaces and Go escape sequences
+func splitFields(line string) ([]string, error) {
+	var (
+		res []string
+		cur []byte
+	)
+
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t':
+			if len(cur) > 0 {
+				res = append(res, string(cur))
+				cur = cur[:0]
+			}
+		case c == '"':
+			end := i + 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated quoted string")
+			}
+			s, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", line[i:end+1])
+			}
+			cur = append(cur, s...)
+			i = end
+		default:
+			cur = append(cur, c)
+		}
+	}
+	if len(cur) > 0 {
+		res = append(res, string(cur))
+	}
+
+	return res, nil
+}
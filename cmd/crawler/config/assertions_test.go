@@ -0,0 +1,88 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []StatusRange
+		wantErr bool
+	}{
+		{in: "200", want: []StatusRange{{200, 200}}},
+		{in: "200-299, 301", want: []StatusRange{{200, 299}, {301, 301}}},
+		{in: "abc", wantErr: true},
+		{in: "200-abc", wantErr: true},
+		{in: "299-200", wantErr: true},
+		{in: "99", wantErr: true},
+		{in: "200-600", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatus(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseStatus(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseStatus(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAssertionsSet(t *testing.T) {
+	tests := []struct {
+		key, value string
+		check      func(a *Assertions) bool
+		wantErr    bool
+	}{
+		{key: "body", value: "ok", check: func(a *Assertions) bool { return a.BodyContains[0] == "ok" }},
+		{key: "body!", value: "error", check: func(a *Assertions) bool { return a.BodyExcludes[0] == "error" }},
+		{key: "body~", value: `v\d+`, check: func(a *Assertions) bool { return a.BodyMatch[0].MatchString("v12") }},
+		{key: "body!~", value: "fail(ed)?", check: func(a *Assertions) bool { return a.BodyNotMatch[0].MatchString("failed") }},
+		{key: "header", value: "X-Id", check: func(a *Assertions) bool { return a.Headers[0] == Header{Name: "X-Id"} }},
+		{key: "header", value: " Server : nginx ", check: func(a *Assertions) bool {
+			return a.Headers[0] == Header{Name: "Server", Value: "nginx"}
+		}},
+		{key: "body~", value: "(", wantErr: true},
+		{key: "header", value: ":value", wantErr: true},
+		{key: "latency", value: "-1s", wantErr: true},
+		{key: "latency", value: "soon", wantErr: true},
+		{key: "size", value: "10", wantErr: true},
+	}
+	for _, tt := range tests {
+		a := &Assertions{}
+		err := a.set(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("set(%q, %q) error = %v, want error %t", tt.key, tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !tt.check(a) {
+			t.Errorf("set(%q, %q) = %+v", tt.key, tt.value, a)
+		}
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "example.com  status=200\tbody=ok", want: []string{"example.com", "status=200", "body=ok"}},
+		{line: `example.com "body=Welcome home" body="a \"b\""`, want: []string{"example.com", "body=Welcome home", `body=a "b"`}},
+		{line: `example.com "body=open`, wantErr: true},
+		{line: `example.com "body=\q"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := splitFields(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitFields(%q) error = %v, want error %t", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitFields(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
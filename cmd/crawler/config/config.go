@@ -5,35 +5,156 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
 )
 
-const limit = 2000
+const httpPrefix = "http://"
 
-var (
-	httpPrefix  = []byte("http://")
-	httpsPrefix = []byte("https://")
-)
+// Target is a single monitored URL with its request parameters and success
+// criteria
+type Target struct {
+	URL       string
+	Method    string
+	Headers   map[string]string
+	Body      string
+	Interval  time.Duration // check interval, zero means crawler period
+	Timeout   time.Duration // request timeout, zero means crawler default
+	Redirects *int          // max redirects to follow, nil means crawler default
+	Tags      []string
+	Sources   []string // IPs and proxies to check target from, empty means all
+	Assert    Assertions
+	Content   *Content // content change detection, nil if disabled
+	Steps     []Step   // requests of multi-step target, made instead of the target URL request
+}
+
+// Errors is a list of config errors, all of them are reported at once
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func lineError(line int, err error) error {
+	return fmt.Errorf("line %d: %s", line, err)
+}
 
-// Parse parses newline separated URL list
-func Parse(r io.Reader) ([]string, error) {
-	s := bufio.NewScanner(r)
+// parseLine parses plain config line in "url [option=value...]" format, see
+// Schemes for supported URLs. Options are assertions evaluated against the
+// response, non-HTTP targets support latency only:
+//
+//	status=200-299,301    accepted status codes and ranges
+//	body=text             body should contain text
+//	body!=text            body should not contain text
+//	body~=regexp          body should match regexp
+//	body!~=regexp         body should not match regexp
+//	header=Name:value     header should be present and contain value
+//	latency=2s            maximum response time
+//
+// Content change detection options:
+//
+//	content=hash          hash response body
+//	ignore=regexp         remove regexp matches before hashing
+func parseLine(line string) (*Target, error) {
+	fields, err := splitFields(line)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Target{URL: normalizeURL(fields[0]), Method: http.MethodGet}
 
-	res := make([]string, 0, limit)
-	for i := 0; s.Scan(); i++ {
-		if i == limit {
-			return nil, fmt.Errorf("Config exceeds %d lines size limit", limit)
+	for _, opt := range fields[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("option %q has no value", opt)
+		}
+		if isContentOption(kv[0]) {
+			err = t.setContent(kv[0], kv[1])
+		} else {
+			err = t.Assert.set(kv[0], kv[1])
+		}
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	if err = t.validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
 
-		url := bytes.TrimSpace(s.Bytes())
-		if !bytes.HasPrefix(url, httpPrefix) && !bytes.HasPrefix(url, httpsPrefix) {
-			url = append([]byte("http://"), url...)
+// parsePlain parses newline separated URL list, each URL may be followed by
+// assertion options
+func parsePlain(data []byte) ([]Target, error) {
+	var (
+		s    = bufio.NewScanner(bytes.NewReader(data))
+		res  []Target
+		errs Errors
+	)
+
+	for i := 1; s.Scan(); i++ {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		t, err := parseLine(line)
+		if err != nil {
+			errs = append(errs, lineError(i, err))
+			continue
 		}
-		res = append(res, string(url))
+		res = append(res, *t)
 	}
 
 	if s.Err() != nil {
 		return nil, s.Err()
 	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
 	return res, nil
 }
+
+// isStructured checks if config is in YAML or JSON format: it should start
+// with a JSON object or array, YAML list or one of the top level YAML keys
+func isStructured(data []byte) bool {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "---" || line[0] == '{' || line[0] == '[' || line[0] == '-' {
+			return true
+		}
+		for _, key := range topKeys {
+			if strings.HasPrefix(line, key+":") {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// Parse parses config in either structured YAML/JSON format or plain newline
+// separated URL list, format is detected automatically
+func Parse(r io.Reader) ([]Target, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if isStructured(data) {
+		return parseStructured(data)
+	}
+	return parsePlain(data)
+}
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePlain(t *testing.T) {
+	cfg := `google.com
+# comment
+
+https://example.com status=200-299,301 "body=Welcome home" header=Content-Type:text/html latency=2s
+`
+	targets, err := Parse(strings.NewReader(cfg))
+	if err != nil {
+		t.Fatalf("Parse() error = %s", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Parse() returned %d targets, want 2", len(targets))
+	}
+
+	if targets[0].URL != "http://google.com" || targets[0].Method != "GET" {
+		t.Errorf("unexpected first target %+v", targets[0])
+	}
+
+	a := targets[1].Assert
+	if targets[1].URL != "https://example.com" {
+		t.Errorf("URL = %s, want https://example.com", targets[1].URL)
+	}
+	if len(a.Status) != 2 || a.Status[0] != (StatusRange{200, 299}) || a.Status[1] != (StatusRange{301, 301}) {
+		t.Errorf("Status = %v", a.Status)
+	}
+	if len(a.BodyContains) != 1 || a.BodyContains[0] != "Welcome home" {
+		t.Errorf("BodyContains = %v", a.BodyContains)
+	}
+	if len(a.Headers) != 1 || a.Headers[0] != (Header{"Content-Type", "text/html"}) {
+		t.Errorf("Headers = %v", a.Headers)
+	}
+	if a.MaxLatency != 2*time.Second {
+		t.Errorf("MaxLatency = %s, want 2s", a.MaxLatency)
+	}
+}
+
+func TestParseProbes(t *testing.T) {
+	cfg := `targets:
+  - tcp://db.example.com:5432
+  - url: tls://example.com
+    timeout: 5s
+    assert: {latency: 1s}
+  - dns:///example.com
+  - dns://8.8.8.8:53/example.com?type=mx
+`
+	targets, err := Parse(strings.NewReader(cfg))
+	if err != nil {
+		t.Fatalf("Parse() error = %s", err)
+	}
+
+	want := []string{"tcp", "tls", "dns", "dns"}
+	if len(targets) != len(want) {
+		t.Fatalf("Parse() returned %d targets, want %d", len(targets), len(want))
+	}
+	for i, w := range want {
+		if s := targets[i].Scheme(); s != w || targets[i].IsHTTP() {
+			t.Errorf("target %s scheme = %s, want %s", targets[i].URL, s, w)
+		}
+	}
+	if targets[1].Assert.MaxLatency != time.Second {
+		t.Errorf("MaxLatency = %s, want 1s", targets[1].Assert.MaxLatency)
+	}
+}
+
+func TestParseContent(t *testing.T) {
+	for _, cfg := range []string{
+		"example.com content=hash\nexample.org ignore=<p>[0-9]+</p> ignore=csrf\n",
+		"- url: example.com\n  content: true\n- url: example.org\n  content: {ignore: ['<p>[0-9]+</p>', csrf]}\n",
+	} {
+		targets, err := Parse(strings.NewReader(cfg))
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %s", cfg, err)
+		}
+		if len(targets) != 2 || targets[0].Content == nil || len(targets[0].Content.Ignore) != 0 ||
+			targets[1].Content == nil || len(targets[1].Content.Ignore) != 2 {
+			t.Fatalf("Parse(%q) = %+v, want content watched", cfg, targets)
+		}
+		if got := string(targets[1].Content.Normalize([]byte("<p>12</p>hi csrf"))); got != "hi " {
+			t.Errorf("Normalize() = %q, want %q", got, "hi ")
+		}
+	}
+}
+
+func TestParseStructured(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+	}{
+		{
+			name: "yaml",
+			cfg: `defaults:
+  timeout: 5s
+  tags: [prod]
+targets:
+  - example.com
+  - url: https://example.com/login
+    method: post
+    headers: {Content-Type: application/json}
+    body: '{"user": "test"}'
+    interval: 30s
+    redirects: 3
+    sources: [10.0.0.1]
+    assert:
+      status: [200-299, 302]
+      body_regexp: ok
+      latency: 1s
+`,
+		},
+		{
+			name: "json",
+			cfg: `{
+	"defaults": {"timeout": "5s", "tags": ["prod"]},
+	"targets": [
+		"example.com",
+		{
+			"url": "https://example.com/login",
+			"method": "post",
+			"headers": {"Content-Type": "application/json"},
+			"body": "{\"user\": \"test\"}",
+			"interval": "30s",
+			"redirects": 3,
+			"sources": ["10.0.0.1"],
+			"assert": {"status": ["200-299", 302], "body_regexp": "ok", "latency": "1s"}
+		}
+	]
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := Parse(strings.NewReader(tt.cfg))
+			if err != nil {
+				t.Fatalf("Parse() error = %s", err)
+			}
+			if len(targets) != 2 {
+				t.Fatalf("Parse() returned %d targets, want 2", len(targets))
+			}
+
+			if targets[0].URL != "http://example.com" || targets[0].Timeout != 5*time.Second ||
+				len(targets[0].Tags) != 1 {
+				t.Errorf("defaults are not applied: %+v", targets[0])
+			}
+
+			l := targets[1]
+			if l.Method != "POST" || l.Body != `{"user": "test"}` || l.Interval != 30*time.Second ||
+				l.Timeout != 5*time.Second || l.Redirects == nil || *l.Redirects != 3 ||
+				l.Headers["Content-Type"] != "application/json" || len(l.Sources) != 1 {
+				t.Errorf("unexpected target %+v", l)
+			}
+			if len(l.Assert.Status) != 2 || len(l.Assert.BodyMatch) != 1 || l.Assert.MaxLatency != time.Second {
+				t.Errorf("unexpected assertions %+v", l.Assert)
+			}
+		})
+	}
+}
+
+func TestParseSteps(t *testing.T) {
+	targets, err := Parse(strings.NewReader(`
+- url: https://example.com
+  headers: {User-Agent: crawler}
+  redirects: 0
+  assert: {latency: 5s}
+  steps:
+    - name: login
+      url: /login
+      method: post
+      extract:
+        token: {json: .auth.token}
+        session: {header: X-Session}
+    - url: /cart?session=${session}
+      headers: {Authorization: 'Bearer ${token}'}
+      redirects: 2
+      assert: {body: Cart}
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %s", err)
+	}
+
+	steps := targets[0].Steps
+	if len(steps) != 2 || targets[0].Assert.MaxLatency != 5*time.Second {
+		t.Fatalf("unexpected target %+v", targets[0])
+	}
+	login, cart := &steps[0], &steps[1]
+	if login.Name != "login" || login.Target.Method != "POST" || *login.Target.Redirects != 0 ||
+		login.Target.Headers["User-Agent"] != "crawler" || len(login.Extract) != 2 ||
+		login.Extract[1].JSON != "auth.token" || login.Extract[0].Header != "X-Session" || !login.NeedBody() {
+		t.Errorf("unexpected login step %+v", login)
+	}
+	if cart.Name != "2" || *cart.Target.Redirects != 2 || cart.Target.Headers["User-Agent"] != "crawler" ||
+		len(cart.Target.Assert.BodyContains) != 1 {
+		t.Errorf("unexpected cart step %+v", cart)
+	}
+
+	req, err := cart.Request(targets[0].URL, map[string]string{"session": "s1", "token": "t1"})
+	if err != nil {
+		t.Fatalf("Request() error = %s", err)
+	}
+	if req.URL != "https://example.com/cart?session=s1" || req.Headers["Authorization"] != "Bearer t1" ||
+		cart.Target.Headers["Authorization"] != "Bearer ${token}" {
+		t.Errorf("unexpected request %+v", req)
+	}
+
+	// values are escaped in URL only
+	req, err = cart.Request(targets[0].URL, map[string]string{"session": "a&b=c d", "token": "t&1"})
+	if err != nil {
+		t.Fatalf("Request() error = %s", err)
+	}
+	if req.URL != "https://example.com/cart?session=a%26b%3Dc+d" || req.Headers["Authorization"] != "Bearer t&1" {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		want []string
+	}{
+		{
+			name: "plain",
+			cfg:  "example.com status=abc\ngoogle.com\nexample.org latency\n",
+			want: []string{"line 1:", "line 3:"},
+		},
+		{
+			name: "yaml",
+			cfg: `targets:
+  - url: example.com
+    interval: soon
+  - url: example.org
+    asert: {}
+  - method: GET
+  - url: example.net
+    redirects: many
+`,
+			want: []string{"line 2: invalid interval", "line 5: unknown key", "line 6: url is empty", "line 8:"},
+		}, {
+			name: "probes",
+			cfg: `tcp://db.example.com:5432
+tcp://db.example.com
+tls://example.com status=200
+dns://8.8.8.8/example.com?type=SRV
+ftp://example.com
+tls://example.com latency=1s
+`,
+			want: []string{"line 2: tcp target", "line 3: tls target", "line 4: unsupported dns record type",
+				"line 5: unsupported url scheme"},
+		}, {
+			name: "content",
+			cfg: `targets:
+  - url: example.com
+    content: {ignore: "("}
+  - url: example.org
+    content: {ignored: x}
+  - url: tcp://example.com:22
+    content: true
+`,
+			want: []string{"line 2: error parsing regexp", "line 5: unknown key", "line 6: tcp target"},
+		}, {
+			name: "steps",
+			cfg: `targets:
+  - url: tcp://example.com:22
+    steps: [{url: /}]
+  - url: example.com
+    body: x
+    steps: [{url: /}]
+  - url: example.com
+    steps:
+      - url: /cart?id=${id}
+        extract: {id: {json: id, header: X-Id}}
+  - url: example.com
+    steps:
+      - extract: {id: {regexp: (}}
+  - url: example.com
+    steps:
+      - url: ftp://example.com
+  - url: example.com
+    steps:
+      - extrct: {}
+  - url: example.com
+    steps:
+      - headers: {X-Token: '${token}'}
+`,
+			want: []string{"line 2: tcp target", "line 4: target http://example.com with steps",
+				"line 7: step 1: extract id should have one of", "line 11: step 1: extract id: error parsing regexp",
+				"line 14: step 1: step url should be", "line 19: unknown key",
+				"line 20: step 1: ${token} is not extracted by previous steps"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.cfg))
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Fatalf("Parse() error = %v, want Errors", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Parse() returned %d errors, want %d:\n%s", len(errs), len(tt.want), err)
+			}
+			for i, w := range tt.want {
+				if !strings.HasPrefix(errs[i].Error(), w) {
+					t.Errorf("error %d = %q, want prefix %q", i, errs[i], w)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"example.com", "http://example.com"},
+		{"example.com/r?u=http://x", "http://example.com/r?u=http://x"},
+		{"https://example.com", "https://example.com"},
+		{"HTTPS://example.com", "HTTPS://example.com"},
+		{"dns:///example.com", "dns:///example.com"},
+		{"git+ssh://example.com", "git+ssh://example.com"},
+	}
+	for _, tt := range tests {
+		if got := normalizeURL(tt.url); got != tt.want {
+			t.Errorf("normalizeURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+		tg := &Target{URL: normalizeURL(tt.url)}
+		if s := tg.Scheme(); len(s) == 0 || strings.Contains(s, "/") {
+			t.Errorf("Scheme() of %q = %q", tg.URL, s)
+		}
+	}
+}
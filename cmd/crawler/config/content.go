@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Content is a content change detection setting: response body of HTTP
+// target is hashed with Ignore regions removed, e.g. timestamps or CSRF
+// tokens, so the hash only changes when the rest of the content does
+type Content struct {
+	Ignore []*regexp.Regexp
+}
+
+// Normalize removes ignored regions from body
+func (c *Content) Normalize(body []byte) []byte {
+	for _, re := range c.Ignore {
+		body = re.ReplaceAll(body, nil)
+	}
+	return body
+}
+
+// setContent applies content option to t: content=hash enables content
+// change detection, ignore=regexp enables it as well and removes regexp
+// matches before hashing
+func (t *Target) setContent(key, value string) error {
+	switch key {
+	case "content":
+		if value != "hash" {
+			return fmt.Errorf("invalid content option %q, should be hash", value)
+		}
+	case "ignore":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return err
+		}
+		if t.Content == nil {
+			t.Content = &Content{}
+		}
+		t.Content.Ignore = append(t.Content.Ignore, re)
+		return nil
+	default:
+		return fmt.Errorf("unknown option %q", key)
+	}
+
+	if t.Content == nil {
+		t.Content = &Content{}
+	}
+	return nil
+}
+
+// isContentOption checks if option key is a content option rather than
+// assertion
+func isContentOption(key string) bool {
+	return key == "content" || key == "ignore"
+}
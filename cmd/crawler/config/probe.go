@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Schemes of supported target URLs, URL without scheme is a HTTP one:
+//
+//	http://host/path, https://host/path    HTTP request
+//	tcp://host:port                        TCP connect
+//	tls://host[:port]                      TLS handshake, port 443 by default
+//	dns://[resolver[:port]]/name[?type=A]  DNS resolution, system resolver by default
+var Schemes = []string{"http", "https", "tcp", "tls", "dns"}
+
+// DNSTypes are record types DNS targets may query, A and AAAA are both
+// queried if no type is set
+var DNSTypes = []string{"A", "AAAA", "CNAME", "MX", "NS", "TXT"}
+
+// schemeRe matches URL scheme prefix, "://" elsewhere, e.g. in query, isn't one
+var schemeRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+func normalizeURL(url string) string {
+	if !schemeRe.MatchString(url) {
+		return httpPrefix + url
+	}
+	return url
+}
+
+// Scheme returns target URL scheme
+func (t *Target) Scheme() string {
+	if m := schemeRe.FindString(t.URL); len(m) > 0 {
+		return strings.ToLower(strings.TrimSuffix(m, "://"))
+	}
+	return ""
+}
+
+// IsHTTP reports if target is checked with HTTP request
+func (t *Target) IsHTTP() bool {
+	s := t.Scheme()
+	return s == "http" || s == "https"
+}
+
+// validate checks target URL and that options are supported by its scheme:
+// only HTTP targets have request options, response assertions, content
+// change detection and steps
+func (t *Target) validate() error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	if len(t.Steps) > 0 {
+		return t.validateSteps()
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return nil
+	case "tcp":
+		if len(u.Hostname()) == 0 || len(u.Port()) == 0 {
+			return fmt.Errorf("tcp target %s should be tcp://host:port", t.URL)
+		}
+	case "tls":
+		if len(u.Hostname()) == 0 {
+			return fmt.Errorf("tls target %s should be tls://host[:port]", t.URL)
+		}
+	case "dns":
+		if len(strings.Trim(u.Path, "/")) == 0 {
+			return fmt.Errorf("dns target %s should be dns://[resolver]/name", t.URL)
+		}
+		if typ := u.Query().Get("type"); len(typ) > 0 && !contains(DNSTypes, strings.ToUpper(typ)) {
+			return fmt.Errorf("unsupported dns record type %q, should be one of %s", typ,
+				strings.Join(DNSTypes, ", "))
+		}
+	default:
+		return fmt.Errorf("unsupported url scheme %q, should be one of %s", u.Scheme,
+			strings.Join(Schemes, ", "))
+	}
+
+	a := &t.Assert
+	if t.Method != http.MethodGet || len(t.Headers) > 0 || len(t.Body) > 0 || t.Redirects != nil ||
+		len(a.Status) > 0 || len(a.Headers) > 0 || a.NeedBody() || t.Content != nil {
+		return fmt.Errorf("%s target %s supports latency assertion only", u.Scheme, t.URL)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
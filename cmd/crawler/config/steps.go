@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// placeholder is a reference to a value extracted by one of the previous steps
+	placeholder = regexp.MustCompile(`\$\{(\w+)\}`)
+	varName     = regexp.MustCompile(`^\w+$`)
+)
+
+// Step is a single request of a multi-step target. Steps are made in order
+// and share cookies, values extracted from step responses are substituted
+// into ${name} placeholders of the following steps URL, query escaped, and
+// headers and body as is.
+type Step struct {
+	Name    string
+	Target  Target // step request and assertions, URL may be relative to the target one
+	Extract []Extract
+}
+
+// Extract is a value extracted from step response, exactly one of the
+// sources is set
+type Extract struct {
+	Name   string
+	Header string         // response header name
+	JSON   string         // dot separated path of JSON body field, e.g. data.items.0.id
+	Regexp *regexp.Regexp // body regexp, the first capture group or the whole match is extracted
+}
+
+// NeedBody reports if response body has to be read into memory to evaluate
+// step assertions and extract values
+func (s *Step) NeedBody() bool {
+	if s.Target.Assert.NeedBody() {
+		return true
+	}
+	for _, e := range s.Extract {
+		if len(e.JSON) > 0 || e.Regexp != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Request returns step request with placeholders substituted by vars and URL
+// resolved against base target URL
+func (s *Step) Request(base string, vars map[string]string) (*Target, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	u, err := b.Parse(expand(s.Target.URL, vars, url.QueryEscape))
+	if err != nil {
+		return nil, err
+	}
+
+	t := s.Target
+	t.URL, t.Body = u.String(), expand(t.Body, vars, nil)
+	t.Headers = make(map[string]string, len(s.Target.Headers))
+	for k, v := range s.Target.Headers {
+		t.Headers[k] = expand(v, vars, nil)
+	}
+	return &t, nil
+}
+
+// expand substitutes placeholders in v by vars, escaped with escape unless
+// it's nil
+func expand(v string, vars map[string]string, escape func(string) string) string {
+	return placeholder.ReplaceAllStringFunc(v, func(m string) string {
+		val := vars[m[2:len(m)-1]]
+		if escape != nil {
+			val = escape(val)
+		}
+		return val
+	})
+}
+
+// validateSteps checks that target steps are HTTP requests using values
+// extracted by the previous steps only. Target request options other than
+// headers and redirects, inherited by steps, are not allowed.
+func (t *Target) validateSteps() error {
+	if !t.IsHTTP() {
+		return fmt.Errorf("%s target %s can't have steps", t.Scheme(), t.URL)
+	}
+	a := &t.Assert
+	if t.Method != http.MethodGet || len(t.Body) > 0 || len(a.Status) > 0 || len(a.Headers) > 0 ||
+		a.NeedBody() || t.Content != nil {
+		return fmt.Errorf("target %s with steps supports latency assertion only, other options should be set in steps", t.URL)
+	}
+
+	var (
+		names   = map[string]bool{}
+		defined = map[string]bool{}
+	)
+	for i := range t.Steps {
+		s := &t.Steps[i]
+		if names[s.Name] {
+			return fmt.Errorf("duplicate step name %q", s.Name)
+		}
+		names[s.Name] = true
+
+		u, err := url.Parse(s.Target.URL)
+		if err == nil && len(u.Scheme) > 0 && u.Scheme != "http" && u.Scheme != "https" {
+			err = fmt.Errorf("step url should be http(s) or relative")
+		}
+		if err != nil {
+			return fmt.Errorf("step %s: %s", s.Name, err)
+		}
+
+		fields := []string{s.Target.URL, s.Target.Body}
+		for _, v := range s.Target.Headers {
+			fields = append(fields, v)
+		}
+		for _, f := range fields {
+			for _, m := range placeholder.FindAllStringSubmatch(f, -1) {
+				if !defined[m[1]] {
+					return fmt.Errorf("step %s: %s is not extracted by previous steps", s.Name, m[0])
+				}
+			}
+		}
+
+		for _, e := range s.Extract {
+			defined[e.Name] = true
+		}
+	}
+	return nil
+}
+
+// newExtract creates extract of value name from one of header, JSON field
+// path or body regexp
+func newExtract(name, header, json, re string) (Extract, error) {
+	e := Extract{Name: name, Header: header, JSON: json}
+
+	n := 0
+	for _, v := range []string{header, json, re} {
+		if len(v) > 0 {
+			n++
+		}
+	}
+	if n != 1 {
+		return e, fmt.Errorf("extract %s should have one of header, json or regexp", name)
+	}
+	if !varName.MatchString(name) {
+		return e, fmt.Errorf("invalid extract name %q, should be alphanumeric", name)
+	}
+
+	if len(re) > 0 {
+		var err error
+		if e.Regexp, err = regexp.Compile(re); err != nil {
+			return e, fmt.Errorf("extract %s: %s", name, err)
+		}
+	}
+	e.JSON = strings.Trim(e.JSON, ".")
+	return e, nil
+}
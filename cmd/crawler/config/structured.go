@@ -0,0 +1,475 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+var topKeys = []string{"defaults", "targets"}
+
+var (
+	targetKeys = []string{"url", "method", "headers", "body", "interval", "timeout",
+		"redirects", "tags", "sources", "assert", "content", "steps"}
+	assertKeys = []string{"status", "body", "body_not", "body_regexp", "body_not_regexp",
+		"headers", "latency"}
+	contentKeys = []string{"ignore"}
+	stepKeys    = []string{"name", "url", "method", "headers", "body", "redirects", "assert", "extract"}
+	extractKeys = []string{"header", "json", "regexp"}
+)
+
+// stringList is a list of strings, that may be also written as a single scalar
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*l = stringList{n.Value}
+		return nil
+	}
+	var s []string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	*l = s
+	return nil
+}
+
+type assertSpec struct {
+	Status        stringList        `yaml:"status"`
+	Body          stringList        `yaml:"body"`
+	BodyNot       stringList        `yaml:"body_not"`
+	BodyRegexp    stringList        `yaml:"body_regexp"`
+	BodyNotRegexp stringList        `yaml:"body_not_regexp"`
+	Headers       map[string]string `yaml:"headers"`
+	Latency       string            `yaml:"latency"`
+}
+
+// contentSpec is either a mapping or a boolean enabling content change
+// detection with no ignored regions
+type contentSpec struct {
+	Enabled bool
+	Ignore  stringList `yaml:"ignore"`
+}
+
+func (c *contentSpec) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&c.Enabled)
+	}
+	var spec struct {
+		Ignore stringList `yaml:"ignore"`
+	}
+	if err := n.Decode(&spec); err != nil {
+		return err
+	}
+	c.Enabled, c.Ignore = true, spec.Ignore
+	return nil
+}
+
+type extractSpec struct {
+	Header string `yaml:"header"`
+	JSON   string `yaml:"json"`
+	Regexp string `yaml:"regexp"`
+}
+
+type stepSpec struct {
+	Name      string                 `yaml:"name"`
+	URL       string                 `yaml:"url"`
+	Method    string                 `yaml:"method"`
+	Headers   map[string]string      `yaml:"headers"`
+	Body      string                 `yaml:"body"`
+	Redirects *int                   `yaml:"redirects"`
+	Assert    assertSpec             `yaml:"assert"`
+	Extract   map[string]extractSpec `yaml:"extract"`
+}
+
+type targetSpec struct {
+	URL       string            `yaml:"url"`
+	Method    string            `yaml:"method"`
+	Headers   map[string]string `yaml:"headers"`
+	Body      string            `yaml:"body"`
+	Interval  string            `yaml:"interval"`
+	Timeout   string            `yaml:"timeout"`
+	Redirects *int              `yaml:"redirects"`
+	Tags      []string          `yaml:"tags"`
+	Sources   []string          `yaml:"sources"`
+	Assert    assertSpec        `yaml:"assert"`
+	Content   contentSpec       `yaml:"content"`
+	Steps     []stepSpec        `yaml:"steps"`
+}
+
+// yamlErrors converts YAML decoder errors, that already contain line numbers,
+// to Errors
+func yamlErrors(err error) Errors {
+	te, ok := err.(*yaml.TypeError)
+	if !ok {
+		return Errors{err}
+	}
+	res := make(Errors, len(te.Errors))
+	for i, e := range te.Errors {
+		res[i] = fmt.Errorf("%s", e)
+	}
+	return res
+}
+
+// checkTargetKeys reports unknown keys of target mapping and its nested
+// mappings
+func checkTargetKeys(n *yaml.Node) Errors {
+	errs := checkKeys(n, targetKeys)
+	if a := mapValue(n, "assert"); a != nil {
+		errs = append(errs, checkKeys(a, assertKeys)...)
+	}
+	if c := mapValue(n, "content"); c != nil {
+		errs = append(errs, checkKeys(c, contentKeys)...)
+	}
+	if steps := mapValue(n, "steps"); steps != nil && steps.Kind == yaml.SequenceNode {
+		for _, step := range steps.Content {
+			errs = append(errs, checkKeys(step, stepKeys)...)
+			if a := mapValue(step, "assert"); a != nil {
+				errs = append(errs, checkKeys(a, assertKeys)...)
+			}
+			if e := mapValue(step, "extract"); e != nil && e.Kind == yaml.MappingNode {
+				for i := 1; i < len(e.Content); i += 2 {
+					errs = append(errs, checkKeys(e.Content[i], extractKeys)...)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// checkKeys reports mapping keys that are not in known list
+func checkKeys(n *yaml.Node, known []string) Errors {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var errs Errors
+	for i := 0; i < len(n.Content); i += 2 {
+		key := n.Content[i]
+		found := false
+		for _, k := range known {
+			if key.Value == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, lineError(key.Line, fmt.Errorf("unknown key %q", key.Value)))
+		}
+	}
+	return errs
+}
+
+// mapValue returns value node of mapping key or nil if there's no such key
+func mapValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func parseDuration(s, name string) (time.Duration, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s should be positive", name)
+	}
+	return d, nil
+}
+
+// target converts decoded spec into Target, returning all the spec errors
+func (s *targetSpec) target() (*Target, Errors) {
+	var (
+		errs Errors
+		err  error
+		t    = &Target{
+			URL:       s.URL,
+			Method:    strings.ToUpper(s.Method),
+			Headers:   s.Headers,
+			Body:      s.Body,
+			Redirects: s.Redirects,
+			Tags:      s.Tags,
+			Sources:   s.Sources,
+		}
+	)
+
+	if len(t.URL) == 0 {
+		errs = append(errs, fmt.Errorf("url is empty"))
+	} else {
+		t.URL = normalizeURL(t.URL)
+	}
+	if len(t.Method) == 0 {
+		t.Method = http.MethodGet
+	}
+	if t.Interval, err = parseDuration(s.Interval, "interval"); err != nil {
+		errs = append(errs, err)
+	}
+	if t.Timeout, err = parseDuration(s.Timeout, "timeout"); err != nil {
+		errs = append(errs, err)
+	}
+	if t.Redirects != nil && *t.Redirects < 0 {
+		errs = append(errs, fmt.Errorf("redirects should not be negative"))
+	}
+
+	errs = append(errs, s.Assert.apply(&t.Assert)...)
+
+	if s.Content.Enabled {
+		t.Content = &Content{}
+	}
+	for _, v := range s.Content.Ignore {
+		if err = t.setContent("ignore", v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for i := range s.Steps {
+		step, stepErrs := s.Steps[i].step(i, t)
+		errs = append(errs, stepErrs...)
+		t.Steps = append(t.Steps, *step)
+	}
+
+	if len(t.URL) > 0 && len(errs) == 0 {
+		if err = t.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return t, errs
+}
+
+// step converts decoded step spec into Step, the step inherits target headers
+// and redirects limit. Steps are named by their number by default.
+func (s *stepSpec) step(i int, t *Target) (*Step, Errors) {
+	var (
+		errs Errors
+		step = &Step{
+			Name: s.Name,
+			Target: Target{
+				URL:       s.URL,
+				Method:    strings.ToUpper(s.Method),
+				Headers:   map[string]string{},
+				Body:      s.Body,
+				Redirects: s.Redirects,
+			},
+		}
+	)
+
+	if len(step.Name) == 0 {
+		step.Name = strconv.Itoa(i + 1)
+	}
+	if len(step.Target.Method) == 0 {
+		step.Target.Method = http.MethodGet
+	}
+	for k, v := range t.Headers {
+		step.Target.Headers[k] = v
+	}
+	for k, v := range s.Headers {
+		step.Target.Headers[k] = v
+	}
+	if step.Target.Redirects == nil {
+		step.Target.Redirects = t.Redirects
+	} else if *step.Target.Redirects < 0 {
+		errs = append(errs, fmt.Errorf("redirects should not be negative"))
+	}
+
+	errs = append(errs, s.Assert.apply(&step.Target.Assert)...)
+
+	names := make([]string, 0, len(s.Extract))
+	for name := range s.Extract {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e := s.Extract[name]
+		extract, err := newExtract(name, e.Header, e.JSON, e.Regexp)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		step.Extract = append(step.Extract, extract)
+	}
+
+	for i, err := range errs {
+		errs[i] = fmt.Errorf("step %s: %s", step.Name, err)
+	}
+	return step, errs
+}
+
+// apply sets assertions of the spec to a
+func (s *assertSpec) apply(a *Assertions) Errors {
+	type option struct {
+		key    string
+		values []string
+	}
+	opts := []option{
+		{"status", s.Status},
+		{"body", s.Body},
+		{"body!", s.BodyNot},
+		{"body~", s.BodyRegexp},
+		{"body!~", s.BodyNotRegexp},
+	}
+	for name, value := range s.Headers {
+		opts = append(opts, option{"header", []string{name + ":" + value}})
+	}
+	if len(s.Latency) > 0 {
+		opts = append(opts, option{"latency", []string{s.Latency}})
+	}
+
+	var errs Errors
+	for _, o := range opts {
+		for _, v := range o.values {
+			if err := a.set(o.key, v); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// checkDefaults validates defaults section once, so its errors are not
+// repeated for every target
+func checkDefaults(n *yaml.Node) Errors {
+	errs := checkTargetKeys(n)
+
+	var spec targetSpec
+	if err := n.Decode(&spec); err != nil {
+		return append(errs, yamlErrors(err)...)
+	}
+	if len(spec.URL) > 0 {
+		errs = append(errs, lineError(n.Line, fmt.Errorf("url can't be set in defaults")))
+	}
+	if len(spec.Steps) > 0 {
+		errs = append(errs, lineError(n.Line, fmt.Errorf("steps can't be set in defaults")))
+	}
+
+	spec.URL = "defaults"
+	_, tErrs := spec.target()
+	for _, err := range tErrs {
+		errs = append(errs, lineError(n.Line, err))
+	}
+	return errs
+}
+
+// parseStructured parses YAML or JSON config. Config is either a list of
+// targets or a mapping with "targets" list and optional "defaults" applied to
+// every target:
+//
+//	defaults:
+//	  timeout: 10s
+//	targets:
+//	  - tcp://db.example.com:5432
+//	  - url: https://example.com/login
+//	    method: POST
+//	    headers: {Content-Type: application/json}
+//	    body: '{"user": "test"}'
+//	    interval: 30s
+//	    redirects: 3
+//	    tags: [prod]
+//	    sources: [10.0.0.1, http://proxy:8080]
+//	    assert:
+//	      status: 200-299
+//	      body: Welcome
+//	      latency: 2s
+//	    content:
+//	      ignore: ['<input name="csrf" value="\w+">']
+//	  - url: https://shop.example.com
+//	    steps:
+//	      - name: login
+//	        url: /api/login
+//	        method: POST
+//	        body: '{"user": "test", "password": "secret"}'
+//	        assert: {status: 200}
+//	        extract:
+//	          token: {json: auth.token}
+//	          session: {header: X-Session}
+//	      - url: /cart
+//	        headers: {Authorization: 'Bearer ${token}'}
+//	        extract:
+//	          item: {regexp: 'data-item="(\d+)"'}
+//	      - url: /api/checkout?item=${item}&session=${session}
+//	        method: POST
+//	        assert: {body: confirmed}
+//
+// Steps are made in order sharing cookies, see Step.
+func parseStructured(data []byte) ([]Target, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, yamlErrors(err)
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	var (
+		doc      = root.Content[0]
+		targets  = doc
+		defaults *yaml.Node
+		errs     Errors
+	)
+
+	if doc.Kind == yaml.MappingNode {
+		errs = append(errs, checkKeys(doc, topKeys)...)
+		defaults = mapValue(doc, "defaults")
+		if defaults != nil {
+			errs = append(errs, checkDefaults(defaults)...)
+			if len(errs) > 0 {
+				return nil, errs
+			}
+		}
+		if targets = mapValue(doc, "targets"); targets == nil {
+			return nil, append(errs, fmt.Errorf("no targets defined"))
+		}
+	}
+	if targets.Kind != yaml.SequenceNode {
+		return nil, append(errs, lineError(targets.Line, fmt.Errorf("targets should be a list")))
+	}
+
+	res := make([]Target, 0, len(targets.Content))
+	for _, item := range targets.Content {
+		var spec targetSpec
+		if defaults != nil {
+			defaults.Decode(&spec)
+		}
+
+		if item.Kind == yaml.ScalarNode {
+			spec.URL = item.Value
+		} else {
+			itemErrs := checkTargetKeys(item)
+			if err := item.Decode(&spec); err != nil {
+				itemErrs = append(itemErrs, yamlErrors(err)...)
+			}
+			if len(itemErrs) > 0 {
+				errs = append(errs, itemErrs...)
+				continue
+			}
+		}
+
+		t, tErrs := spec.target()
+		for _, err := range tErrs {
+			errs = append(errs, lineError(item.Line, err))
+		}
+		if len(tErrs) == 0 {
+			res = append(res, *t)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return res, nil
+}
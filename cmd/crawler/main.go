@@ -12,9 +12,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/bpiddubnyi/crawler/cmd/crawler/alert"
 	"github.com/bpiddubnyi/crawler/cmd/crawler/client"
 	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
-	"github.com/bpiddubnyi/crawler/db/pq"
+	"github.com/bpiddubnyi/crawler/cmd/crawler/metrics"
+	"github.com/bpiddubnyi/crawler/db"
+	"github.com/bpiddubnyi/crawler/db/backend"
+	"github.com/bpiddubnyi/crawler/db/file"
+	"github.com/bpiddubnyi/crawler/db/spool"
 )
 
 var (
@@ -29,11 +34,24 @@ var (
 	followRedirects  = false
 	dbFlushPeriod    = 5
 	nWorkers         = 40
+	watchPeriod      = 0
+	dryRun           = false
+	spoolPath        string
+	alertsFileName   string
+	metricsAddr      string
+	retentionDays    = 0
+	rollupDays       = 0
+	confirmMode      string
+	confirmDelay     = 0
+	migrateDB        = false
 )
 
+// maintenancePeriod is a period db partitions and retention are maintained with
+const maintenancePeriod = time.Hour
+
 func init() {
-	flag.StringVar(&dbURI, "db", dbURI, "postgres connection string")
-	flag.StringVar(&cfgFileName, "config", cfgFileName, "config file with urls to be monitored")
+	flag.StringVar(&dbURI, "db", dbURI, "db URI: "+backend.Schemes)
+	flag.StringVar(&cfgFileName, "config", cfgFileName, "config file with targets to be monitored: YAML, JSON or newline separated URL list")
 	flag.IntVar(&period, "period", period, "monitoring period in seconds")
 	flag.BoolVar(&showHelp, "help", showHelp, "show this help message and exit")
 	flag.StringVar(&ipsRaw, "ips", ipsRaw, "comma separated list of ip addresses")
@@ -43,9 +61,24 @@ func init() {
 	flag.IntVar(&dbFlushPeriod, "flush", dbFlushPeriod, "database flush period in seconds")
 	flag.StringVar(&proxiesRaw, "proxies", proxiesRaw, "comma separated proxy url list")
 	flag.IntVar(&nWorkers, "workers", nWorkers, "number of workers per IP/proxy")
+	flag.BoolVar(&dryRun, "dry-run", dryRun, "print records to stdout as JSON lines instead of saving them to db")
+	flag.StringVar(&spoolPath, "spool", spoolPath, "spool file records are kept in while db is unavailable (empty - disabled, crawler stops on db failure)")
+	flag.StringVar(&alertsFileName, "alerts", alertsFileName, "alerting config file (empty - alerting disabled)")
+	flag.StringVar(&metricsAddr, "metrics", metricsAddr, "Prometheus metrics web server listen address, metrics are served at /metrics (empty - disabled)")
+	flag.IntVar(&retentionDays, "retention", retentionDays, "number of days raw checks are kept, older ones are rolled up into hourly summaries (0 - kept forever)")
+	flag.IntVar(&rollupDays, "rollup-retention", rollupDays, "number of days hourly summaries are kept (0 - kept forever)")
+	flag.IntVar(&watchPeriod, "watch", watchPeriod, "config file change check period in seconds (0 - disabled, SIGHUP reloads config anyway)")
+	flag.StringVar(&confirmMode, "confirm", confirmMode, "confirm failed checks by repeating them: retry - from the same IP/proxy, source - from another IP/proxy of the target (empty - disabled)")
+	flag.BoolVar(&migrateDB, "migrate", migrateDB, "migrate db schema to the latest version at start, otherwise crawler refuses to run on outdated schema")
+	flag.IntVar(&confirmDelay, "confirm-delay", confirmDelay, "delay in seconds before failed check is repeated to confirm the failure")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
 	if showHelp {
@@ -79,25 +112,88 @@ func main() {
 		os.Exit(1)
 	}
 
-	cfgFile, err := os.Open(cfgFileName)
-	if err != nil {
-		fmt.Printf("Error: failed to open config: %s\n", err)
+	if watchPeriod < 0 {
+		fmt.Printf("Error: watch period should not be negative\n")
 		os.Exit(1)
 	}
 
-	urls, err := config.Parse(cfgFile)
-	if err != nil {
-		fmt.Printf("Error: failed to parse config: %s\n", err)
+	if retentionDays < 0 || rollupDays < 0 {
+		fmt.Printf("Error: retention should not be negative\n")
 		os.Exit(1)
 	}
-	cfgFile.Close()
 
-	db, err := pq.New(dbURI, reconnectRetries)
+	targets, err := loadConfig(cfgFileName)
 	if err != nil {
-		fmt.Printf("Error: failed to create db connection: %s\n", err)
+		fmt.Printf("Error: failed to load config: %s\n", err)
 		os.Exit(1)
 	}
 
+	var (
+		w   db.Writer
+		m   db.Maintainer
+		cli *client.Client
+		mon *metrics.Metrics
+		ret = db.Retention{
+			Raw:    time.Duration(retentionDays) * 24 * time.Hour,
+			Rollup: time.Duration(rollupDays) * 24 * time.Hour,
+		}
+	)
+	if len(metricsAddr) > 0 {
+		mon = metrics.New(func() client.Stats { return cli.Stats() })
+	}
+
+	if dryRun {
+		w = file.NewStream(os.Stdout, file.JSON)
+	} else {
+		b, err := backend.Open(dbURI, reconnectRetries)
+		if err != nil {
+			fmt.Printf("Error: failed to create db connection: %s\n", err)
+			os.Exit(1)
+		}
+		w = b
+
+		if mg, ok := b.(db.Migrator); ok && migrateDB {
+			from, to, err := mg.Migrate()
+			if err != nil {
+				fmt.Printf("Error: failed to migrate db: %s\n", err)
+				os.Exit(1)
+			}
+			if from != to {
+				log.Printf("Info: DB schema migrated from version %d to %d\n", from, to)
+			}
+		}
+		if err = db.CheckVersion(b); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		var ok bool
+		m, ok = b.(db.Maintainer)
+		if (ret.Raw > 0 || ret.Rollup > 0) && !ok {
+			fmt.Printf("Error: %s db doesn't support retention\n", dbURI)
+			os.Exit(1)
+		}
+		// partitions for records being written are created before crawling starts
+		if ok {
+			if err = m.Maintain(time.Now(), ret); err != nil {
+				log.Printf("Error: DB maintenance failed: %s\n", err)
+			}
+		}
+
+		bw, ok := b.(db.BatchWriter)
+		if len(spoolPath) > 0 && !ok {
+			fmt.Printf("Error: %s db doesn't support spooling\n", dbURI)
+			os.Exit(1)
+		}
+		if ok && mon != nil {
+			bw = mon.Instrument(bw)
+			w = db.Batched{BatchWriter: bw}
+		}
+		if len(spoolPath) > 0 {
+			w = spool.New(bw, spoolPath)
+		}
+	}
+
 	var ips []string
 	if len(ipsRaw) > 0 {
 		ips = strings.Split(ipsRaw, ",")
@@ -117,25 +213,71 @@ func main() {
 		}()
 	}
 
-	client, err := client.New(ips, proxies, time.Duration(period)*time.Second, followRedirects, db)
+	cli, err = client.New(ips, proxies, time.Duration(period)*time.Second, followRedirects, w)
 	if err != nil {
 		fmt.Printf("Error: failed to create crawler: %s\n", err)
 		os.Exit(1)
 	}
 
-	sigC := make(chan os.Signal, 2)
-	signal.Notify(sigC, syscall.SIGTERM, syscall.SIGINT)
+	if len(confirmMode) > 0 {
+		if err = cli.SetConfirmation(confirmMode, time.Duration(confirmDelay)*time.Second); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if mon != nil {
+		cli.AddObserver(mon)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", mon)
+		go func() {
+			err := http.ListenAndServe(metricsAddr, mux)
+			if err != nil {
+				fmt.Printf("Error: Failed to start metrics web server: %s\n", err)
+			}
+		}()
+	}
 
 	shutdownC := make(chan struct{})
 
+	if len(alertsFileName) > 0 {
+		f, err := os.Open(alertsFileName)
+		if err != nil {
+			fmt.Printf("Error: failed to open alerting config: %s\n", err)
+			os.Exit(1)
+		}
+		cfg, err := alert.ParseConfig(f)
+		f.Close()
+		if err != nil {
+			fmt.Printf("Error: failed to parse alerting config: %s\n", err)
+			os.Exit(1)
+		}
+
+		alerter := alert.New(cfg)
+		cli.AddObserver(alerter)
+		go alerter.Run(shutdownC)
+	}
+
+	if m != nil {
+		go db.RunMaintenance(m, ret, maintenancePeriod, shutdownC)
+	}
+
+	sigC := make(chan os.Signal, 2)
+	signal.Notify(sigC, syscall.SIGTERM, syscall.SIGINT)
+
 	go func() {
 		sig := <-sigC
 		fmt.Printf("Info: Received %s signal. Shutting down gracefully\n", sig)
 		close(shutdownC)
 	}()
 
+	hupC := make(chan os.Signal, 1)
+	signal.Notify(hupC, syscall.SIGHUP)
+	updateC := make(chan []config.Target)
+	go watchConfig(cfgFileName, time.Duration(watchPeriod)*time.Second, hupC, updateC, shutdownC)
+
 	log.Printf("Starting crawler [∫]\n")
-	if err = client.Crawl(urls, time.Duration(dbFlushPeriod)*time.Second, nWorkers, shutdownC); err != nil {
+	if err = cli.Crawl(targets, time.Duration(dbFlushPeriod)*time.Second, nWorkers, updateC, shutdownC); err != nil {
 		fmt.Printf("Error: Crawler failed: %s\n", err)
 	}
 }
@@ -0,0 +1,246 @@
+// Package metrics exports crawler metrics in Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/client"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+var (
+	latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	flushBuckets   = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 5, 10}
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if len(labels) > 0 {
+		sep = ","
+	}
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(b), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func braces(labels string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats label pairs: name1, value1, name2, value2...
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeValues writes metric samples ordered by labels
+func writeValues(w io.Writer, name string, values map[string]float64) {
+	labels := make([]string, 0, len(values))
+	for l := range values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s%s %s\n", name, braces(l), formatFloat(values[l]))
+	}
+}
+
+// Metrics collects crawler metrics, it implements client.ReloadObserver and
+// http.Handler serving metrics in Prometheus text exposition format
+type Metrics struct {
+	mu            sync.Mutex
+	up            map[string]float64    // by url and source labels
+	checks        map[string]float64    // by outcome and source labels
+	latency       map[string]*histogram // by url label
+	urls          map[string]string     // target url of up and latency series by their labels
+	targets       map[string]bool       // configured target urls, nil until the first Reload
+	flush         *histogram
+	flushFailures uint64
+	stats         func() client.Stats
+}
+
+// New creates metrics collector, stats is called on every scrape to get
+// crawler queues depth and overruns, it may be nil
+func New(stats func() client.Stats) *Metrics {
+	return &Metrics{
+		up:      map[string]float64{},
+		checks:  map[string]float64{},
+		latency: map[string]*histogram{},
+		urls:    map[string]string{},
+		flush:   newHistogram(flushBuckets),
+		stats:   stats,
+	}
+}
+
+// Observe records check result
+func (m *Metrics) Observe(r *db.Record) {
+	outcome := "up"
+	if !r.Up {
+		outcome = r.Error
+	}
+
+	up := 0.0
+	if r.Up {
+		up = 1
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checks[labels("outcome", outcome, "source", r.LocalIP)]++
+	// check of removed target may finish after reload
+	if m.targets != nil && !m.targets[r.URL] {
+		return
+	}
+	l := labels("url", r.URL, "source", r.LocalIP)
+	m.up[l] = up
+	m.urls[l] = r.URL
+	l = labels("url", r.URL)
+	h, ok := m.latency[l]
+	if !ok {
+		h = newHistogram(latencyBuckets)
+		m.latency[l] = h
+		m.urls[l] = r.URL
+	}
+	h.observe(r.Duration.Seconds())
+}
+
+// Reload drops up and latency series of targets that are no longer checked
+func (m *Metrics) Reload(urls []string) {
+	targets := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		targets[u] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.targets = targets
+	for l, u := range m.urls {
+		if !targets[u] {
+			delete(m.up, l)
+			delete(m.latency, l)
+			delete(m.urls, l)
+		}
+	}
+}
+
+type instrumented struct {
+	db.BatchWriter
+	m *Metrics
+}
+
+func (i *instrumented) WriteBatch(recs []*db.Record) error {
+	start := time.Now()
+	err := i.BatchWriter.WriteBatch(recs)
+	d := time.Since(start)
+
+	i.m.mu.Lock()
+	i.m.flush.observe(d.Seconds())
+	if err != nil {
+		i.m.flushFailures++
+	}
+	i.m.mu.Unlock()
+
+	return err
+}
+
+// Permanent classifies write errors with the wrapped writer, if it can
+func (i *instrumented) Permanent(err error) bool {
+	c, ok := i.BatchWriter.(db.ErrorClassifier)
+	return ok && c.Permanent(err)
+}
+
+// Instrument wraps batch writer to collect db flush durations and failures
+func (m *Metrics) Instrument(w db.BatchWriter) db.BatchWriter {
+	return &instrumented{BatchWriter: w, m: m}
+}
+
+// Write writes metrics in Prometheus text exposition format
+func (m *Metrics) Write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(w, "crawler_target_up", "gauge", "Whether the last target check from the source succeeded.")
+	writeValues(w, "crawler_target_up", m.up)
+	header(w, "crawler_checks_total", "counter", "Number of checks by outcome (up or error class) and source.")
+	writeValues(w, "crawler_checks_total", m.checks)
+
+	header(w, "crawler_check_duration_seconds", "histogram", "Target check duration.")
+	urls := make([]string, 0, len(m.latency))
+	for l := range m.latency {
+		urls = append(urls, l)
+	}
+	sort.Strings(urls)
+	for _, l := range urls {
+		m.latency[l].write(w, "crawler_check_duration_seconds", l)
+	}
+
+	header(w, "crawler_db_flush_duration_seconds", "histogram", "Duration of db batch writes.")
+	m.flush.write(w, "crawler_db_flush_duration_seconds", "")
+	header(w, "crawler_db_flush_failures_total", "counter", "Number of failed db batch writes.")
+	fmt.Fprintf(w, "crawler_db_flush_failures_total %d\n", m.flushFailures)
+
+	if m.stats == nil {
+		return
+	}
+	s := m.stats()
+	header(w, "crawler_queue_depth", "gauge", "Number of items waiting in crawler queues.")
+	fmt.Fprintf(w, "crawler_queue_depth{queue=\"checks\"} %d\n", s.Queued)
+	fmt.Fprintf(w, "crawler_queue_depth{queue=\"records\"} %d\n", s.Pending)
+	header(w, "crawler_round_overruns_total", "counter", "Number of check rounds that overran their interval.")
+	fmt.Fprintf(w, "crawler_round_overruns_total %d\n", s.Overruns)
+}
+
+// ServeHTTP serves metrics
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Write(w)
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/client"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+type failingWriter struct{ fail bool }
+
+func (w *failingWriter) WriteBatch(recs []*db.Record) error {
+	if w.fail {
+		return errors.New("db is down")
+	}
+	return nil
+}
+
+func TestMetrics(t *testing.T) {
+	m := New(func() client.Stats { return client.Stats{Queued: 3, Pending: 7, Overruns: 2} })
+
+	m.Observe(&db.Record{URL: "http://a", LocalIP: "10.0.0.1", Up: true, Duration: 200 * time.Millisecond})
+	m.Observe(&db.Record{URL: "http://a", LocalIP: "10.0.0.1", Error: client.ErrTimeout, Duration: 20 * time.Second})
+	m.Observe(&db.Record{URL: `http://b/"q"`, LocalIP: "10.0.0.2", Up: true, Duration: 40 * time.Millisecond})
+
+	fw := &failingWriter{}
+	bw := m.Instrument(fw)
+	bw.WriteBatch(nil)
+	fw.fail = true
+	if err := bw.WriteBatch(nil); err == nil {
+		t.Errorf("WriteBatch() error is lost")
+	}
+
+	buf := &bytes.Buffer{}
+	m.Write(buf)
+	out := buf.String()
+
+	want := []string{
+		`crawler_target_up{url="http://a",source="10.0.0.1"} 0`,
+		`crawler_target_up{url="http://b/\"q\"",source="10.0.0.2"} 1`,
+		`crawler_checks_total{outcome="timeout",source="10.0.0.1"} 1`,
+		`crawler_checks_total{outcome="up",source="10.0.0.1"} 1`,
+		`crawler_check_duration_seconds_bucket{url="http://a",le="0.25"} 1`,
+		`crawler_check_duration_seconds_bucket{url="http://a",le="10"} 1`,
+		`crawler_check_duration_seconds_bucket{url="http://a",le="30"} 2`,
+		`crawler_check_duration_seconds_bucket{url="http://a",le="+Inf"} 2`,
+		`crawler_check_duration_seconds_sum{url="http://a"} 20.2`,
+		`crawler_check_duration_seconds_count{url="http://b/\"q\""} 1`,
+		`crawler_db_flush_duration_seconds_count 2`,
+		`crawler_db_flush_failures_total 1`,
+		`crawler_queue_depth{queue="checks"} 3`,
+		`crawler_queue_depth{queue="records"} 7`,
+		`crawler_round_overruns_total 2`,
+		`# TYPE crawler_check_duration_seconds histogram`,
+	}
+	for _, w := range want {
+		if !strings.Contains(out, w+"\n") {
+			t.Errorf("metrics don't contain %q:\n%s", w, out)
+		}
+	}
+}
+
+// TestReload checks that series of removed targets are dropped and not
+// recreated by checks that finish after reload
+func TestReload(t *testing.T) {
+	m := New(nil)
+	m.Observe(&db.Record{URL: "http://a", LocalIP: "10.0.0.1", Up: true})
+	m.Observe(&db.Record{URL: "http://b", LocalIP: "10.0.0.1", Up: true})
+
+	m.Reload([]string{"http://b", "http://c"})
+	m.Observe(&db.Record{URL: "http://a", LocalIP: "10.0.0.1", Up: true})
+	m.Observe(&db.Record{URL: "http://c", LocalIP: "10.0.0.1", Up: true})
+
+	buf := &bytes.Buffer{}
+	m.Write(buf)
+	out := buf.String()
+
+	if strings.Contains(out, `url="http://a"`) {
+		t.Errorf("metrics contain removed target:\n%s", out)
+	}
+	for _, w := range []string{
+		`crawler_target_up{url="http://b",source="10.0.0.1"} 1`,
+		`crawler_target_up{url="http://c",source="10.0.0.1"} 1`,
+		`crawler_check_duration_seconds_count{url="http://b"} 1`,
+		`crawler_checks_total{outcome="up",source="10.0.0.1"} 4`,
+	} {
+		if !strings.Contains(out, w+"\n") {
+			t.Errorf("metrics don't contain %q:\n%s", w, out)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/bpiddubnyi/crawler/db/pq"
+)
+
+// migrate runs "crawler migrate" subcommand: migrates PostgreSQL db schema to
+// the latest version and exits
+func migrate(args []string) {
+	var (
+		fs      = flag.NewFlagSet("migrate", flag.ExitOnError)
+		uri     = fs.String("db", dbURI, "PostgreSQL db URI")
+		retries = fs.Int("retry", reconnectRetries, "number of db connection attempts")
+	)
+	fs.Parse(args)
+
+	u, err := url.Parse(*uri)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		fmt.Printf("Error: migrations are only supported by postgres:// db\n")
+		os.Exit(1)
+	}
+
+	d, err := pq.New(*uri, *retries)
+	if err != nil {
+		fmt.Printf("Error: failed to create db connection: %s\n", err)
+		os.Exit(1)
+	}
+
+	from, to, err := d.Migrate()
+	if err != nil {
+		fmt.Printf("Error: failed to migrate db: %s\n", err)
+		os.Exit(1)
+	}
+	if from != to {
+		fmt.Printf("Info: DB schema migrated from version %d to %d\n", from, to)
+	}
+	fmt.Printf("Info: DB schema is at version %d\n", to)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler/config"
+)
+
+func loadConfig(name string) ([]config.Target, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return config.Parse(f)
+}
+
+// watchConfig reloads config on SIGHUP and, if period is positive, when config
+// file modification time or size changes. Successfully parsed target lists are
+// sent to updateC, invalid configs are logged and ignored.
+func watchConfig(name string, period time.Duration, hupC <-chan os.Signal, updateC chan<- []config.Target,
+	shutdownC <-chan struct{}) {
+	var (
+		checkC <-chan time.Time
+		last   os.FileInfo
+	)
+	if period > 0 {
+		t := time.NewTicker(period)
+		defer t.Stop()
+		checkC = t.C
+		last, _ = os.Stat(name)
+	}
+
+	for {
+		select {
+		case <-shutdownC:
+			return
+		case <-hupC:
+			log.Printf("Info: Received SIGHUP, reloading config %s\n", name)
+		case <-checkC:
+			fi, err := os.Stat(name)
+			if err != nil {
+				log.Printf("Error: Failed to stat config: %s\n", err)
+				continue
+			}
+			if last != nil && fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size() {
+				continue
+			}
+			last = fi
+			log.Printf("Info: Config %s changed, reloading\n", name)
+		}
+
+		targets, err := loadConfig(name)
+		if err != nil {
+			log.Printf("Error: Failed to reload config, keeping the old one: %s\n", err)
+			continue
+		}
+
+		select {
+		case updateC <- targets:
+		case <-shutdownC:
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"time"
+)
+
+// BatchWriter is a storage that writes batch of records at once, either all
+// of them or none
+type BatchWriter interface {
+	WriteBatch(recs []*Record) error
+}
+
+// ErrorClassifier is a BatchWriter that tells write errors caused by the
+// records, e.g. invalid values, from storage outages
+type ErrorClassifier interface {
+	// Permanent reports if err is caused by the records, so writing them
+	// again would fail as well
+	Permanent(err error) bool
+}
+
+// WriteBatches collects records from rC and writes them with w every
+// flushPeriod and once rC is closed. It stops on the first write error.
+func WriteBatches(w BatchWriter, flushPeriod time.Duration, rC <-chan *Record, errC chan<- error) {
+	var (
+		batch []*Record
+		err   error
+		t     = time.NewTicker(flushPeriod)
+	)
+	defer t.Stop()
+
+theLoop:
+	for {
+		select {
+		case r, ok := <-rC:
+			if !ok {
+				break theLoop
+			}
+			batch = append(batch, r)
+		case <-t.C:
+			if len(batch) == 0 {
+				continue
+			}
+			if err = w.WriteBatch(batch); err != nil {
+				errC <- err
+				return
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		err = w.WriteBatch(batch)
+	}
+	errC <- err
+}
+
+// Batched is a Writer that writes records with wrapped BatchWriter
+type Batched struct {
+	BatchWriter
+}
+
+// Write collects records from rC and writes them in batches, see WriteBatches
+func (b Batched) Write(flushPeriod time.Duration, rC <-chan *Record, errC chan<- error) {
+	WriteBatches(b.BatchWriter, flushPeriod, rC, errC)
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// Columns are record columns of SQL backends uptime_records view, in the
+// order ScanRecord scans them
+var Columns = []string{"url", "time", "local_ip", "up", "status_code", "response_time",
+	"dns_time", "connect_time", "tls_time", "ttfb", "size", "error", "assertion",
+	"cert_expiry", "cert_issuer", "cert_sans", "cert_chain_valid", "cert_host_valid",
+	"content_hash", "content_change", "content_previous", "content_before", "content_after",
+	"confirmed"}
+
+// WriteColumns are uptime_log columns records are written to, in the order of
+// WriteValues. Step records are not read back, so uptime_records view has no
+// step column.
+var WriteColumns = append(Columns[:len(Columns):len(Columns)], "step")
+
+// Microseconds converts duration to microseconds, the unit timings are stored in
+func Microseconds(d time.Duration) int64 {
+	return int64(d / time.Microsecond)
+}
+
+// FromMicroseconds converts stored timing back to duration
+func FromMicroseconds(v int64) time.Duration {
+	return time.Duration(v) * time.Microsecond
+}
+
+// WriteValues returns r values of WriteColumns, t and expiry are record time
+// and certificate expiry in the backend representation, expiry is nil if r
+// has no certificate
+func WriteValues(r *Record, t, expiry interface{}) []interface{} {
+	c, cc := r.Cert, r.Content
+	if c == nil {
+		c = &Certificate{}
+	}
+	if cc == nil {
+		cc = &ContentChange{}
+	}
+	return []interface{}{r.URL, t, r.LocalIP, r.Up, r.StatusCode, Microseconds(r.Duration),
+		Microseconds(r.DNS), Microseconds(r.Connect), Microseconds(r.TLS), Microseconds(r.TTFB),
+		r.Size, r.Error, r.Assertion,
+		expiry, c.Issuer, strings.Join(c.SANs, ","), c.ChainValid, c.HostValid,
+		r.ContentHash, cc.Kind, cc.Previous, cc.Before, cc.After, r.Confirmed, r.Step}
+}
+
+// ScanRecord scans row of Columns into r, t and expiry receive record time
+// and certificate expiry in the backend representation. Certificate is
+// returned without expiry, it's up to the caller to set it and attach the
+// certificate to r if expiry isn't NULL.
+func ScanRecord(scan func(dest ...interface{}) error, r *Record, t, expiry interface{}) (*Certificate, error) {
+	var (
+		duration, dns, conn, tls, ttfb int64
+		c                              Certificate
+		cc                             ContentChange
+		sans                           string
+	)
+	err := scan(&r.URL, t, &r.LocalIP, &r.Up, &r.StatusCode, &duration,
+		&dns, &conn, &tls, &ttfb, &r.Size, &r.Error, &r.Assertion,
+		expiry, &c.Issuer, &sans, &c.ChainValid, &c.HostValid,
+		&r.ContentHash, &cc.Kind, &cc.Previous, &cc.Before, &cc.After, &r.Confirmed)
+	if err != nil {
+		return nil, err
+	}
+	if len(cc.Kind) > 0 {
+		r.Content = &cc
+	}
+	if len(sans) > 0 {
+		c.SANs = strings.Split(sans, ",")
+	}
+	r.Duration = FromMicroseconds(duration)
+	r.DNS = FromMicroseconds(dns)
+	r.Connect = FromMicroseconds(conn)
+	r.TLS = FromMicroseconds(tls)
+	r.TTFB = FromMicroseconds(ttfb)
+	return &c, nil
+}
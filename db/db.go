@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"time"
 )
 
@@ -9,6 +10,63 @@ type Record struct {
 	Time    time.Time
 	LocalIP string
 	Up      bool
+
+	StatusCode int           // HTTP status code, 0 if no response was received
+	Duration   time.Duration // total check time including body download
+	DNS        time.Duration // DNS lookup time
+	Connect    time.Duration // TCP connect time
+	TLS        time.Duration // TLS handshake time
+	TTFB       time.Duration // time from request start to the first response byte
+	Size       int64         // response body size in bytes
+	Error      string        // normalized error class, empty if check succeeded
+	Assertion  string        // description of the failed assertion, if any
+	Cert       *Certificate  // server certificate of HTTPS and TLS checks, nil if none was received
+
+	ContentHash string         // hex SHA-256 of normalized response body, empty if content isn't watched
+	Content     *ContentChange // content difference from the previous check or other sources, if any
+
+	// Confirmed is set if failure was confirmed by repeated check. Failure
+	// that repeated check didn't confirm is recorded as up, keeping its error.
+	Confirmed bool
+
+	// Step is a step name of multi-step target record, empty for target
+	// records. Step records are saved, but they're not read back as target
+	// checks: GetRecords, StreamRecords and aggregation skip them.
+	Step string
+}
+
+// Content change kinds
+const (
+	ContentChanged = "changed" // content differs from the previous check from the same source
+	ContentDiffers = "differs" // content differs from the one other source received
+)
+
+// ContentChange is a difference of checked content from the content it's
+// compared to, snippets are taken around the first difference
+type ContentChange struct {
+	Kind     string // ContentChanged or ContentDiffers
+	Previous string // hash of the content compared to
+	Before   string // snippet of the content compared to
+	After    string // snippet of the checked content
+}
+
+// Certificate is a summary of the leaf certificate server presented
+type Certificate struct {
+	Expiry     time.Time // NotAfter of the certificate
+	Issuer     string    // issuer common name
+	SANs       []string  // DNS names and IP addresses certificate is issued for
+	ChainValid bool      // certificate chain is trusted by system roots and not expired
+	HostValid  bool      // certificate is valid for the checked host
+}
+
+// DaysLeft returns number of whole days until certificate expiry, negative if
+// it has already expired
+func (c *Certificate) DaysLeft(now time.Time) int {
+	d := c.Expiry.Sub(now)
+	if d < 0 {
+		return -int(-d/(24*time.Hour)) - 1
+	}
+	return int(d / (24 * time.Hour))
 }
 
 type Writer interface {
@@ -18,3 +76,92 @@ type Writer interface {
 type RecordGetter interface {
 	GetRecords(from, to time.Time, url ...string) ([]Record, error)
 }
+
+// RecordStreamer is a storage that streams records instead of loading them
+// into memory all at once
+type RecordStreamer interface {
+	// StreamRecords calls fn for every record in [from, to] time range
+	// ordered by url, local_ip and time. Streaming stops on the first fn error
+	// or ctx cancellation, the error is returned.
+	StreamRecords(ctx context.Context, from, to time.Time, url []string, fn func(r *Record) error) error
+}
+
+// StreamRecords streams records from g if it's a RecordStreamer, otherwise
+// loads them with GetRecords first
+func StreamRecords(ctx context.Context, g RecordGetter, from, to time.Time, url []string,
+	fn func(r *Record) error) error {
+	if s, ok := g.(RecordStreamer); ok {
+		return s.StreamRecords(ctx, from, to, url, fn)
+	}
+
+	recs, err := g.GetRecords(from, to, url...)
+	if err != nil {
+		return err
+	}
+	for i := range recs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = fn(&recs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backend is a storage records can be both written to and read from
+type Backend interface {
+	Writer
+	RecordGetter
+}
+
+// Migrator is a storage with versioned schema. Schema is only migrated by
+// "crawler migrate" or "crawler -migrate", other tools refuse to run on
+// outdated schema.
+type Migrator interface {
+	// Migrate applies pending migrations and returns schema versions before
+	// and after migration
+	Migrate() (int, int, error)
+	// CheckVersion fails if schema isn't migrated to the latest version
+	CheckVersion() error
+}
+
+// CheckVersion fails if d is a Migrator with outdated schema
+func CheckVersion(d interface{}) error {
+	if m, ok := d.(Migrator); ok {
+		return m.CheckVersion()
+	}
+	return nil
+}
+
+// Interval is a period server was continuously up or down according to its
+// checks
+type Interval struct {
+	URL     string
+	LocalIP string
+	Up      bool
+	From    time.Time
+	To      time.Time
+}
+
+// Summary is a server checks summary
+type Summary struct {
+	URL      string
+	LocalIP  string
+	Failures map[string]int // number of failed checks by assertion description or error class
+	P50      time.Duration  // response time percentiles of successful checks
+	P90      time.Duration
+	P99      time.Duration
+}
+
+// Aggregator is a storage that aggregates records itself, so long time ranges
+// don't have to be loaded into memory
+type Aggregator interface {
+	// GetIntervals returns uptime/downtime intervals in [from, to] time range,
+	// ordered by url, local_ip and time. Interval ends with the first check of
+	// the next one, the last interval ends with its last check and is omitted
+	// if it has a single check.
+	GetIntervals(from, to time.Time, url ...string) ([]Interval, error)
+	// GetSummaries returns summaries of checks in [from, to] time range
+	GetSummaries(from, to time.Time, url ...string) ([]Summary, error)
+}
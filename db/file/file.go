@@ -0,0 +1,209 @@
+package file
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// DB is an append-only file storage backend
+type DB struct {
+	path   string
+	format Format
+}
+
+// New creates file backend storing records at path in the given format
+func New(path string, format Format) *DB {
+	return &DB{path: path, format: format}
+}
+
+// Write appends records from rC to the file, flushing them to disk every
+// flushPeriod
+func (d *DB) Write(flushPeriod time.Duration, rC <-chan *db.Record, errC chan<- error) {
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		errC <- err
+		return
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		errC <- err
+		return
+	}
+
+	err = write(f, d.format, fi.Size() == 0, flushPeriod, rC, f.Sync)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	errC <- err
+}
+
+// write encodes records from rC to w until rC is closed, flushing buffered
+// data every flushPeriod followed by sync call, if it's not nil
+func write(w io.Writer, format Format, header bool, flushPeriod time.Duration, rC <-chan *db.Record,
+	sync func() error) error {
+	var (
+		bw  = bufio.NewWriter(w)
+		enc = newEncoder(bw, format, header)
+		t   = time.NewTicker(flushPeriod)
+		err error
+	)
+	defer t.Stop()
+
+	flush := func() error {
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if sync != nil {
+			return sync()
+		}
+		return nil
+	}
+
+theLoop:
+	for {
+		select {
+		case r, ok := <-rC:
+			if !ok {
+				break theLoop
+			}
+			if err = enc.Encode(r); err != nil {
+				break theLoop
+			}
+		case <-t.C:
+			if err = flush(); err != nil {
+				break theLoop
+			}
+		}
+	}
+
+	if ferr := flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Stream is a write-only backend encoding records to arbitrary writer, e.g.
+// standard output
+type Stream struct {
+	w      io.Writer
+	format Format
+}
+
+// NewStream creates stream backend writing records to w in the given format
+func NewStream(w io.Writer, format Format) *Stream {
+	return &Stream{w: w, format: format}
+}
+
+// Write encodes records from rC to the stream writer, flushing them every
+// flushPeriod
+func (s *Stream) Write(flushPeriod time.Duration, rC <-chan *db.Record, errC chan<- error) {
+	errC <- write(s.w, s.format, true, flushPeriod, rC, nil)
+}
+
+// Append appends records to the file and syncs it to disk
+func (d *DB) Append(recs []*db.Record) error {
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	enc := newEncoder(bw, d.format, fi.Size() == 0)
+	for _, r := range recs {
+		if err = enc.Encode(r); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = enc.Flush()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Each calls fn for every record in the file in the order they were written,
+// it stops on the first fn error. Missing file is treated as empty.
+func (d *DB) Each(fn func(*db.Record) error) error {
+	f, err := os.Open(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var fnErr error
+	err = decode(bufio.NewReader(f), d.format, func(r *record) bool {
+		rec := r.dbRecord()
+		fnErr = fn(&rec)
+		return fnErr == nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// GetRecords reads the whole file and returns records in [from, to] time
+// range, ordered by url, local_ip and time. Step records are skipped.
+func (d *DB) GetRecords(from, to time.Time, url ...string) ([]db.Record, error) {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	urls := make(map[string]bool, len(url))
+	for _, u := range url {
+		urls[u] = true
+	}
+
+	res := []db.Record{}
+	err = decode(bufio.NewReader(f), d.format, func(r *record) bool {
+		if len(r.Step) == 0 && !r.Time.Before(from) && !r.Time.After(to) && (len(urls) == 0 || urls[r.URL]) {
+			res = append(res, r.dbRecord())
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].URL != res[j].URL {
+			return res[i].URL < res[j].URL
+		}
+		if res[i].LocalIP != res[j].LocalIP {
+			return res[i].LocalIP < res[j].LocalIP
+		}
+		return res[i].Time.Before(res[j].Time)
+	})
+
+	return res, nil
+}
@@ -0,0 +1,84 @@
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+func TestWriteGetRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := time.Date(1972, 1, 1, 0, 0, 0, 0, time.UTC)
+	recs := []*db.Record{
+		{URL: "http://b.com", Time: base, LocalIP: "127.0.0.1", Up: true, StatusCode: 200,
+			Duration: 120 * time.Millisecond, TTFB: 100 * time.Millisecond, Size: 1024},
+		{URL: "http://a.com", Time: base.Add(time.Minute), LocalIP: "127.0.0.1", Error: "status",
+			StatusCode: 503, Assertion: "status 503 not in 100-499", Cert: &db.Certificate{
+				Expiry: base.AddDate(0, 3, 0), Issuer: "Test CA", SANs: []string{"a.com", "127.0.0.1"}, ChainValid: true}},
+		{URL: "http://a.com", Time: base, LocalIP: "127.0.0.1", Error: "dns", Confirmed: true},
+		{URL: "http://a.com", Time: base.Add(time.Hour), LocalIP: "127.0.0.1", Up: true, ContentHash: "ab12",
+			Content: &db.ContentChange{Kind: db.ContentChanged, Previous: "cd34", Before: "old,\n\"text\"", After: "new"}},
+		{URL: "http://a.com", Time: base, LocalIP: "127.0.0.1", Up: true, Step: "login"},
+	}
+
+	for _, f := range []Format{JSON, CSV} {
+		d := New(filepath.Join(dir, fmt.Sprintf("log%d", f)), f)
+
+		// Write twice to check appending to an existing file
+		for _, part := range [][]*db.Record{recs[:2], recs[2:]} {
+			rC := make(chan *db.Record, len(part))
+			errC := make(chan error, 1)
+			for _, r := range part {
+				rC <- r
+			}
+			close(rC)
+			d.Write(time.Second, rC, errC)
+			if err = <-errC; err != nil {
+				t.Fatalf("format %d: Write() error = %s", f, err)
+			}
+		}
+
+		got, err := d.GetRecords(base, base.Add(time.Minute), "http://a.com")
+		if err != nil {
+			t.Fatalf("format %d: GetRecords() error = %s", f, err)
+		}
+		want := []db.Record{*recs[2], *recs[1]}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("format %d: GetRecords() = %v, want %v", f, got, want)
+		}
+
+		got, err = d.GetRecords(base, base.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("format %d: GetRecords() error = %s", f, err)
+		}
+		if len(got) != len(recs)-1 || got[len(got)-1].URL != "http://b.com" {
+			t.Errorf("format %d: GetRecords() = %v, want all but step records ordered by url", f, got)
+		}
+		if !reflect.DeepEqual(got[2], *recs[3]) {
+			t.Errorf("format %d: GetRecords() = %+v, want %+v", f, got[2], *recs[3])
+		}
+
+		// step records are kept in the file
+		var steps []db.Record
+		err = d.Each(func(r *db.Record) error {
+			if len(r.Step) > 0 {
+				steps = append(steps, *r)
+			}
+			return nil
+		})
+		if err != nil || len(steps) != 1 || !reflect.DeepEqual(steps[0], *recs[4]) {
+			t.Errorf("format %d: Each() step records %+v, %v, want %+v", f, steps, err, *recs[4])
+		}
+	}
+}
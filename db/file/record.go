@@ -0,0 +1,337 @@
+package file
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// Format is a file record format
+type Format int
+
+const (
+	// JSON is a newline separated list of JSON objects
+	JSON Format = iota
+	// CSV is a comma separated values file with header
+	CSV
+)
+
+// ParseFormat returns format by its name: "json", "jsonl" or "csv"
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "json", "jsonl":
+		return JSON, nil
+	case "csv":
+		return CSV, nil
+	}
+	return JSON, fmt.Errorf("Unknown file format %s", name)
+}
+
+// columns are CSV header, the same as uptime_log columns of SQL backends
+var columns = db.WriteColumns
+
+// Indexes of the first certificate, content, confirmation and step columns,
+// files written before they were recorded have no such columns
+const (
+	certColumn      = 13
+	contentColumn   = 18
+	confirmedColumn = 23
+	stepColumn      = 24
+)
+
+// record is db.Record file representation, timings are stored in microseconds
+type record struct {
+	URL        string    `json:"url"`
+	Time       time.Time `json:"time"`
+	LocalIP    string    `json:"local_ip"`
+	Up         bool      `json:"up"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Duration   int64     `json:"response_time,omitempty"`
+	DNS        int64     `json:"dns_time,omitempty"`
+	Connect    int64     `json:"connect_time,omitempty"`
+	TLS        int64     `json:"tls_time,omitempty"`
+	TTFB       int64     `json:"ttfb,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Assertion  string    `json:"assertion,omitempty"`
+	Cert       *cert     `json:"cert,omitempty"`
+
+	ContentHash string         `json:"content_hash,omitempty"`
+	Content     *contentChange `json:"content,omitempty"`
+	Confirmed   bool           `json:"confirmed,omitempty"`
+	Step        string         `json:"step,omitempty"`
+}
+
+// contentChange is db.ContentChange file representation
+type contentChange struct {
+	Kind     string `json:"kind"`
+	Previous string `json:"previous"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+}
+
+// cert is db.Certificate file representation
+type cert struct {
+	Expiry     time.Time `json:"expiry"`
+	Issuer     string    `json:"issuer,omitempty"`
+	SANs       []string  `json:"sans,omitempty"`
+	ChainValid bool      `json:"chain_valid"`
+	HostValid  bool      `json:"host_valid"`
+}
+
+func newRecord(r *db.Record) *record {
+	res := &record{
+		URL:        r.URL,
+		Time:       r.Time.UTC(),
+		LocalIP:    r.LocalIP,
+		Up:         r.Up,
+		StatusCode: r.StatusCode,
+		Duration:   db.Microseconds(r.Duration),
+		DNS:        db.Microseconds(r.DNS),
+		Connect:    db.Microseconds(r.Connect),
+		TLS:        db.Microseconds(r.TLS),
+		TTFB:       db.Microseconds(r.TTFB),
+		Size:       r.Size,
+		Error:      r.Error,
+		Assertion:  r.Assertion,
+
+		ContentHash: r.ContentHash,
+		Confirmed:   r.Confirmed,
+		Step:        r.Step,
+	}
+	if c := r.Content; c != nil {
+		res.Content = &contentChange{Kind: c.Kind, Previous: c.Previous, Before: c.Before, After: c.After}
+	}
+	if c := r.Cert; c != nil {
+		res.Cert = &cert{Expiry: c.Expiry.UTC(), Issuer: c.Issuer, SANs: c.SANs,
+			ChainValid: c.ChainValid, HostValid: c.HostValid}
+	}
+	return res
+}
+
+func (r *record) dbRecord() db.Record {
+	res := db.Record{
+		URL:        r.URL,
+		Time:       r.Time,
+		LocalIP:    r.LocalIP,
+		Up:         r.Up,
+		StatusCode: r.StatusCode,
+		Duration:   db.FromMicroseconds(r.Duration),
+		DNS:        db.FromMicroseconds(r.DNS),
+		Connect:    db.FromMicroseconds(r.Connect),
+		TLS:        db.FromMicroseconds(r.TLS),
+		TTFB:       db.FromMicroseconds(r.TTFB),
+		Size:       r.Size,
+		Error:      r.Error,
+		Assertion:  r.Assertion,
+
+		ContentHash: r.ContentHash,
+		Confirmed:   r.Confirmed,
+		Step:        r.Step,
+	}
+	if c := r.Content; c != nil {
+		res.Content = &db.ContentChange{Kind: c.Kind, Previous: c.Previous, Before: c.Before, After: c.After}
+	}
+	if c := r.Cert; c != nil {
+		res.Cert = &db.Certificate{Expiry: c.Expiry, Issuer: c.Issuer, SANs: c.SANs,
+			ChainValid: c.ChainValid, HostValid: c.HostValid}
+	}
+	return res
+}
+
+func (r *record) csv() []string {
+	c := &cert{}
+	expiry := ""
+	if r.Cert != nil {
+		c = r.Cert
+		expiry = c.Expiry.Format(time.RFC3339)
+	}
+	cc := r.Content
+	if cc == nil {
+		cc = &contentChange{}
+	}
+	return []string{
+		r.URL,
+		r.Time.Format(time.RFC3339Nano),
+		r.LocalIP,
+		strconv.FormatBool(r.Up),
+		strconv.Itoa(r.StatusCode),
+		strconv.FormatInt(r.Duration, 10),
+		strconv.FormatInt(r.DNS, 10),
+		strconv.FormatInt(r.Connect, 10),
+		strconv.FormatInt(r.TLS, 10),
+		strconv.FormatInt(r.TTFB, 10),
+		strconv.FormatInt(r.Size, 10),
+		r.Error,
+		r.Assertion,
+		expiry,
+		c.Issuer,
+		strings.Join(c.SANs, ","),
+		strconv.FormatBool(c.ChainValid),
+		strconv.FormatBool(c.HostValid),
+		r.ContentHash,
+		cc.Kind,
+		cc.Previous,
+		cc.Before,
+		cc.After,
+		strconv.FormatBool(r.Confirmed),
+		r.Step,
+	}
+}
+
+func parseCSV(fields []string) (*record, error) {
+	if len(fields) != len(columns) && len(fields) != certColumn && len(fields) != contentColumn &&
+		len(fields) != confirmedColumn && len(fields) != stepColumn {
+		return nil, fmt.Errorf("Invalid number of fields %d, expected %d", len(fields), len(columns))
+	}
+
+	var (
+		r   = &record{URL: fields[0], LocalIP: fields[2], Error: fields[11], Assertion: fields[12]}
+		err error
+	)
+	if r.Time, err = time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+		return nil, err
+	}
+	if r.Up, err = strconv.ParseBool(fields[3]); err != nil {
+		return nil, err
+	}
+	if r.StatusCode, err = strconv.Atoi(fields[4]); err != nil {
+		return nil, err
+	}
+	for i, v := range []*int64{&r.Duration, &r.DNS, &r.Connect, &r.TLS, &r.TTFB, &r.Size} {
+		if *v, err = strconv.ParseInt(fields[5+i], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(fields) > stepColumn {
+		r.Step = fields[stepColumn]
+	}
+	if len(fields) > confirmedColumn {
+		if r.Confirmed, err = strconv.ParseBool(fields[confirmedColumn]); err != nil {
+			return nil, err
+		}
+	}
+	if len(fields) > contentColumn {
+		r.ContentHash = fields[contentColumn]
+		if len(fields[contentColumn+1]) > 0 {
+			r.Content = &contentChange{Kind: fields[contentColumn+1], Previous: fields[contentColumn+2],
+				Before: fields[contentColumn+3], After: fields[contentColumn+4]}
+		}
+	}
+
+	if len(fields) == certColumn || len(fields[certColumn]) == 0 {
+		return r, nil
+	}
+	c := &cert{Issuer: fields[certColumn+1]}
+	if c.Expiry, err = time.Parse(time.RFC3339, fields[certColumn]); err != nil {
+		return nil, err
+	}
+	if len(fields[certColumn+2]) > 0 {
+		c.SANs = strings.Split(fields[certColumn+2], ",")
+	}
+	if c.ChainValid, err = strconv.ParseBool(fields[certColumn+3]); err != nil {
+		return nil, err
+	}
+	if c.HostValid, err = strconv.ParseBool(fields[certColumn+4]); err != nil {
+		return nil, err
+	}
+	r.Cert = c
+	return r, nil
+}
+
+// encoder writes records in one of the file formats
+type encoder interface {
+	Encode(r *db.Record) error
+	Flush() error
+}
+
+type jsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *jsonEncoder) Encode(r *db.Record) error {
+	return e.enc.Encode(newRecord(r))
+}
+
+func (e *jsonEncoder) Flush() error {
+	return nil
+}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) Encode(r *db.Record) error {
+	if e.header {
+		if err := e.w.Write(columns); err != nil {
+			return err
+		}
+		e.header = false
+	}
+	return e.w.Write(newRecord(r).csv())
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// newEncoder creates record encoder, header is only written to CSV files if
+// header is true
+func newEncoder(w io.Writer, f Format, header bool) encoder {
+	if f == CSV {
+		return &csvEncoder{w: csv.NewWriter(w), header: header}
+	}
+	return &jsonEncoder{enc: json.NewEncoder(w)}
+}
+
+// decode reads records from r calling fn for each of them until fn returns
+// false
+func decode(r io.Reader, f Format, fn func(*record) bool) error {
+	if f == CSV {
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		for line := 1; ; line++ {
+			fields, err := cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if line == 1 && len(fields) > 0 && fields[0] == columns[0] {
+				continue
+			}
+			rec, err := parseCSV(fields)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", line, err)
+			}
+			if !fn(rec) {
+				return nil
+			}
+		}
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		rec := &record{}
+		err := dec.Decode(rec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(rec) {
+			return nil
+		}
+	}
+}
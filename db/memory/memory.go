@@ -0,0 +1,68 @@
+// Package memory implements in-memory storage backend for tests and dry runs
+package memory
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// DB keeps all the records in memory, it's safe for concurrent use
+type DB struct {
+	mu   sync.RWMutex
+	recs []db.Record
+}
+
+// New creates empty in-memory storage
+func New() *DB {
+	return &DB{}
+}
+
+// Add stores records right away, bypassing Write
+func (d *DB) Add(recs ...db.Record) {
+	d.mu.Lock()
+	d.recs = append(d.recs, recs...)
+	d.mu.Unlock()
+}
+
+// Write stores records from rC as soon as they're received, flushPeriod is
+// ignored
+func (d *DB) Write(flushPeriod time.Duration, rC <-chan *db.Record, errC chan<- error) {
+	for r := range rC {
+		d.Add(*r)
+	}
+	errC <- nil
+}
+
+// GetRecords returns records in [from, to] time range, ordered by url,
+// local_ip and time. Step records are skipped.
+func (d *DB) GetRecords(from, to time.Time, url ...string) ([]db.Record, error) {
+	urls := make(map[string]bool, len(url))
+	for _, u := range url {
+		urls[u] = true
+	}
+
+	res := []db.Record{}
+	d.mu.RLock()
+	for _, r := range d.recs {
+		if len(r.Step) > 0 || r.Time.Before(from) || r.Time.After(to) || (len(urls) > 0 && !urls[r.URL]) {
+			continue
+		}
+		res = append(res, r)
+	}
+	d.mu.RUnlock()
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].URL != res[j].URL {
+			return res[i].URL < res[j].URL
+		}
+		if res[i].LocalIP != res[j].LocalIP {
+			return res[i].LocalIP < res[j].LocalIP
+		}
+		return res[i].Time.Before(res[j].Time)
+	})
+
+	return res, nil
+}
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+func TestGetRecords(t *testing.T) {
+	base := time.Date(1972, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := New()
+
+	rC := make(chan *db.Record, 5)
+	errC := make(chan error, 1)
+	for _, r := range []db.Record{
+		{URL: "http://b.com", Time: base, LocalIP: "127.0.0.2", Up: true},
+		{URL: "http://a.com", Time: base.Add(time.Minute), LocalIP: "127.0.0.2"},
+		{URL: "http://a.com", Time: base.Add(time.Minute), LocalIP: "127.0.0.1"},
+		{URL: "http://a.com", Time: base, LocalIP: "127.0.0.1", Up: true},
+		{URL: "http://a.com", Time: base.Add(time.Hour), LocalIP: "127.0.0.1"},
+	} {
+		r := r
+		rC <- &r
+	}
+	close(rC)
+	d.Write(time.Second, rC, errC)
+	if err := <-errC; err != nil {
+		t.Fatalf("Write() error = %s", err)
+	}
+
+	got, err := d.GetRecords(base, base.Add(time.Minute), "http://a.com")
+	if err != nil {
+		t.Fatalf("GetRecords() error = %s", err)
+	}
+	want := []db.Record{
+		{URL: "http://a.com", Time: base, LocalIP: "127.0.0.1", Up: true},
+		{URL: "http://a.com", Time: base.Add(time.Minute), LocalIP: "127.0.0.1"},
+		{URL: "http://a.com", Time: base.Add(time.Minute), LocalIP: "127.0.0.2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRecords() = %v, want %v", got, want)
+	}
+}
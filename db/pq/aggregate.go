@@ -0,0 +1,160 @@
+package pq
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+	"github.com/lib/pq"
+)
+
+// filter returns records filter condition and its arguments
+func filter(from, to time.Time, url []string) (string, []interface{}) {
+	if len(url) > 0 {
+		return `time >= $1 AND time <= $2 AND url = ANY($3)`, []interface{}{from.UTC(), to.UTC(), pq.Array(url)}
+	}
+	return `time >= $1 AND time <= $2`, []interface{}{from.UTC(), to.UTC()}
+}
+
+// intervalsQuery splits every server checks into runs of the same state and
+// returns a row per run with the first check time of the next run
+const intervalsQuery = `WITH changes AS (
+	SELECT url, local_ip, time, up,
+		CASE WHEN lag(up) OVER (PARTITION BY url, local_ip ORDER BY time) IS DISTINCT FROM up
+			THEN 1 ELSE 0 END AS change
+	FROM uptime_records WHERE %s
+), runs AS (
+	SELECT url, local_ip, time, up,
+		sum(change) OVER (PARTITION BY url, local_ip ORDER BY time ROWS UNBOUNDED PRECEDING) AS run
+	FROM changes
+), grouped AS (
+	SELECT url, local_ip, run, bool_and(up) AS up, min(time) AS first, max(time) AS last, count(*) AS n
+	FROM runs GROUP BY url, local_ip, run
+)
+SELECT url, local_ip, up, first, last, n,
+	lead(first) OVER (PARTITION BY url, local_ip ORDER BY run) AS next
+FROM grouped ORDER BY url, local_ip, run`
+
+// GetIntervals computes uptime/downtime intervals with window functions, so
+// only a row per interval is transferred
+func (d *DB) GetIntervals(from, to time.Time, url ...string) ([]db.Interval, error) {
+	cond, args := filter(from, to, url)
+	rows, err := d.conn.Query(fmt.Sprintf(intervalsQuery, cond), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []db.Interval{}
+	for rows.Next() {
+		var (
+			iv   db.Interval
+			last time.Time
+			n    int
+			next pq.NullTime
+		)
+		if err = rows.Scan(&iv.URL, &iv.LocalIP, &iv.Up, &iv.From, &last, &n, &next); err != nil {
+			return nil, err
+		}
+		switch {
+		case next.Valid:
+			iv.To = next.Time
+		case n > 1:
+			iv.To = last
+		default:
+			continue
+		}
+		res = append(res, iv)
+	}
+
+	return res, rows.Err()
+}
+
+const failuresQuery = `SELECT url, local_ip, CASE WHEN assertion <> '' THEN assertion ELSE error END, count(*)
+FROM uptime_records WHERE NOT up AND (assertion <> '' OR error <> '') AND %s
+GROUP BY 1, 2, 3`
+
+const latencyQuery = `SELECT url, local_ip,
+	percentile_disc(ARRAY[0.5, 0.9, 0.99]) WITHIN GROUP (ORDER BY response_time)
+FROM uptime_records WHERE up AND error = '' AND %s
+GROUP BY url, local_ip`
+
+// GetSummaries computes failure counts and latency percentiles with SQL
+// aggregates
+func (d *DB) GetSummaries(from, to time.Time, url ...string) ([]db.Summary, error) {
+	var (
+		cond, args = filter(from, to, url)
+		sums       = map[[2]string]*db.Summary{}
+		res        []db.Summary
+	)
+	summary := func(url, localIP string) *db.Summary {
+		key := [2]string{url, localIP}
+		s, ok := sums[key]
+		if !ok {
+			s = &db.Summary{URL: url, LocalIP: localIP}
+			sums[key] = s
+		}
+		return s
+	}
+
+	err := query(d.conn, fmt.Sprintf(failuresQuery, cond), args, func(rows *sql.Rows) error {
+		var (
+			url, localIP, reason string
+			n                    int
+		)
+		if err := rows.Scan(&url, &localIP, &reason, &n); err != nil {
+			return err
+		}
+		s := summary(url, localIP)
+		if s.Failures == nil {
+			s.Failures = map[string]int{}
+		}
+		s.Failures[reason] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = query(d.conn, fmt.Sprintf(latencyQuery, cond), args, func(rows *sql.Rows) error {
+		var (
+			url, localIP string
+			p            []int64
+		)
+		if err := rows.Scan(&url, &localIP, pq.Array(&p)); err != nil {
+			return err
+		}
+		if len(p) == 3 {
+			s := summary(url, localIP)
+			s.P50 = time.Duration(p[0]) * time.Microsecond
+			s.P90 = time.Duration(p[1]) * time.Microsecond
+			s.P99 = time.Duration(p[2]) * time.Microsecond
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range sums {
+		res = append(res, *s)
+	}
+	return res, nil
+}
+
+// query runs q and calls fn for every row
+func query(conn *sql.DB, q string, args []interface{}, fn func(rows *sql.Rows) error) error {
+	rows, err := conn.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
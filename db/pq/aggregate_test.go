@@ -0,0 +1,91 @@
+package pq
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// testDB connects to PG_URI db and migrates it, the test is skipped if
+// PG_URI is not set
+func testDB(t *testing.T) *DB {
+	uri := os.Getenv("PG_URI")
+	if len(uri) == 0 {
+		t.Skip("PG_URI is not set")
+	}
+	d, err := New(uri, 1)
+	if err != nil {
+		t.Fatalf("New() error = %s", err)
+	}
+	if _, _, err = d.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %s", err)
+	}
+	return d
+}
+
+func TestAggregateSQL(t *testing.T) {
+	d := testDB(t)
+	defer d.conn.Close()
+
+	var (
+		rnd    = rand.New(rand.NewSource(1))
+		prefix = fmt.Sprintf("http://aggregate-%d.test/", time.Now().UnixNano())
+		start  = time.Now().UTC().Truncate(time.Hour).Add(-6 * time.Hour)
+		urls   = []string{prefix + "a", prefix + "b"}
+		recs   []*db.Record
+	)
+	defer d.conn.Exec(`DELETE FROM uptime_log WHERE url LIKE $1`, prefix+"%")
+
+	for _, url := range urls {
+		for _, src := range []string{"10.0.0.1", "10.0.0.2"} {
+			for i := 0; i < 300; i++ {
+				r := &db.Record{
+					URL:      url,
+					LocalIP:  src,
+					Time:     start.Add(time.Duration(i) * time.Minute),
+					Up:       rnd.Intn(5) > 0,
+					Duration: time.Duration(1+rnd.Intn(1000)) * time.Millisecond,
+				}
+				if !r.Up {
+					r.Error = []string{"timeout", "dns", "status"}[rnd.Intn(3)]
+				}
+				recs = append(recs, r)
+			}
+		}
+	}
+	if err := d.WriteBatch(recs); err != nil {
+		t.Fatalf("WriteBatch() error = %s", err)
+	}
+
+	raw := make([]db.Record, len(recs))
+	for i := range recs {
+		raw[i] = *recs[i]
+	}
+	want := stat.Aggregate(raw)
+
+	from, to := start, start.Add(300*time.Minute)
+	tls, err := stat.FromAggregator(d, from, to, urls...)
+	if err != nil {
+		t.Fatalf("FromAggregator() error = %s", err)
+	}
+	if len(tls) != len(want) {
+		t.Fatalf("got %d timelines, want %d", len(tls), len(want))
+	}
+
+	for i := range tls {
+		got, w := tls[i].Stat(), want[i]
+		if got.URL != w.URL || got.LocalIP != w.LocalIP || got.WholeTime != w.WholeTime ||
+			got.UpTime != w.UpTime || got.Incidents != w.Incidents || got.Latency != w.Latency ||
+			fmt.Sprint(got.Failures) != fmt.Sprint(w.Failures) {
+			t.Errorf("stat %d = %+v, want %+v", i, got, w)
+		}
+		if !got.LongestDown.From.Equal(w.LongestDown.From) || !got.LongestDown.To.Equal(w.LongestDown.To) {
+			t.Errorf("stat %d longest down = %+v, want %+v", i, got.LongestDown, w.LongestDown)
+		}
+	}
+}
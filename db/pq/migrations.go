@@ -0,0 +1,344 @@
+package pq
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migration is a schema change, its version is its index in migrations plus
+// one. Released migrations are never changed, new ones are appended.
+type migration struct {
+	name  string
+	stmts []string // executed one by one, each may hold several statements unless noTx is set
+	noTx  bool     // migration can't run in a transaction, e.g. it builds indexes concurrently
+}
+
+var migrations = []migration{
+	{name: "initial schema", stmts: []string{`CREATE TABLE IF NOT EXISTS uptime_log (
+    time     TIMESTAMP NOT NULL,
+    url      TEXT NOT NULL,
+    local_ip TEXT NOT NULL,
+    up       BOOLEAN DEFAULT false NOT NULL,
+    UNIQUE(time, url)
+)`}},
+
+	// HTTP status, timings, response size and error class
+	{name: "check details", stmts: []string{`ALTER TABLE uptime_log
+    ADD COLUMN IF NOT EXISTS status_code   INTEGER DEFAULT 0 NOT NULL,
+    ADD COLUMN IF NOT EXISTS response_time BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    ADD COLUMN IF NOT EXISTS dns_time      BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    ADD COLUMN IF NOT EXISTS connect_time  BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    ADD COLUMN IF NOT EXISTS tls_time      BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    ADD COLUMN IF NOT EXISTS ttfb          BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    ADD COLUMN IF NOT EXISTS size          BIGINT DEFAULT 0 NOT NULL,
+    ADD COLUMN IF NOT EXISTS error         TEXT DEFAULT '' NOT NULL`}},
+
+	// failed assertion description
+	{name: "assertion", stmts: []string{`ALTER TABLE uptime_log
+    ADD COLUMN IF NOT EXISTS assertion TEXT DEFAULT '' NOT NULL`}},
+
+	// per server ordered reads and window function aggregation. The index is
+	// built without blocking writes, invalid index left by failed build is
+	// dropped first.
+	{name: "url index", stmts: []string{`DO $$ BEGIN
+    IF EXISTS (SELECT 1 FROM pg_index
+        WHERE indexrelid = to_regclass('uptime_log_url_local_ip_time') AND NOT indisvalid)
+    THEN DROP INDEX uptime_log_url_local_ip_time; END IF; END $$`,
+		`CREATE INDEX CONCURRENTLY IF NOT EXISTS uptime_log_url_local_ip_time ON uptime_log (url, local_ip, time)`},
+		noTx: true},
+
+	// Converts uptime_log into a table partitioned by day and adds hourly
+	// rollups, requires PostgreSQL 11+. Existing records are kept in
+	// uptime_log_legacy partition, crawler creates daily partitions for the
+	// following days, rows that don't fit any of them go to the default one.
+	{name: "retention", stmts: []string{`ALTER TABLE uptime_log RENAME TO uptime_log_legacy;
+ALTER TABLE uptime_log_legacy RENAME CONSTRAINT uptime_log_time_url_key TO uptime_log_legacy_time_url_key;
+ALTER INDEX uptime_log_url_local_ip_time RENAME TO uptime_log_legacy_url_local_ip_time;
+
+CREATE TABLE uptime_log (
+    time          TIMESTAMP NOT NULL,
+    url           TEXT NOT NULL,
+    local_ip      TEXT NOT NULL,
+    up            BOOLEAN DEFAULT false NOT NULL,
+    status_code   INTEGER DEFAULT 0 NOT NULL,
+    response_time BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    dns_time      BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    connect_time  BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    tls_time      BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    ttfb          BIGINT DEFAULT 0 NOT NULL, -- microseconds
+    size          BIGINT DEFAULT 0 NOT NULL,
+    error         TEXT DEFAULT '' NOT NULL,
+    assertion     TEXT DEFAULT '' NOT NULL,
+    UNIQUE(time, url)
+) PARTITION BY RANGE (time);
+CREATE INDEX uptime_log_url_local_ip_time ON uptime_log (url, local_ip, time);
+
+DO $$
+DECLARE
+    bound TIMESTAMP;
+BEGIN
+    SELECT date_trunc('day', max(time)) + interval '1 day' INTO bound FROM uptime_log_legacy;
+    IF bound IS NULL THEN
+        DROP TABLE uptime_log_legacy;
+    ELSE
+        EXECUTE format('ALTER TABLE uptime_log ATTACH PARTITION uptime_log_legacy FOR VALUES FROM (MINVALUE) TO (%L)', bound);
+    END IF;
+END $$;
+CREATE TABLE uptime_log_default PARTITION OF uptime_log DEFAULT;
+
+-- Raw checks older than retention period are rolled up into hourly rows, a
+-- row per run of checks with the same state within an hour.
+CREATE TABLE uptime_hourly (
+    hour          TIMESTAMP NOT NULL,
+    url           TEXT NOT NULL,
+    local_ip      TEXT NOT NULL,
+    up            BOOLEAN NOT NULL,
+    first_time    TIMESTAMP NOT NULL, -- first check of the run
+    last_time     TIMESTAMP NOT NULL, -- last check of the run
+    checks        INTEGER NOT NULL,
+    status_code   INTEGER DEFAULT 0 NOT NULL, -- the highest one
+    response_time BIGINT DEFAULT 0 NOT NULL, -- average, microseconds
+    error         TEXT DEFAULT '' NOT NULL, -- one of the run errors
+    assertion     TEXT DEFAULT '' NOT NULL -- one of the run failed assertions
+);
+CREATE INDEX uptime_hourly_url_local_ip_time ON uptime_hourly (url, local_ip, first_time);
+
+-- Raw checks along with the first and the last check of every rolled up run,
+-- uptime intervals computed from it are the same as from raw checks.
+CREATE VIEW uptime_records AS
+    SELECT url, time, local_ip, up, status_code, response_time, dns_time, connect_time,
+        tls_time, ttfb, size, error, assertion
+    FROM uptime_log
+    UNION ALL
+    SELECT url, first_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion
+    FROM uptime_hourly
+    UNION ALL
+    SELECT url, last_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion
+    FROM uptime_hourly WHERE checks > 1`}},
+
+	// the same URL is checked at once from several sources
+	{name: "source uniqueness", stmts: []string{`ALTER TABLE uptime_log DROP CONSTRAINT uptime_log_time_url_key;
+ALTER TABLE uptime_log ADD CONSTRAINT uptime_log_time_url_local_ip_key UNIQUE (time, url, local_ip)`}},
+
+	// leaf certificate of HTTPS and TLS checks, cert_expiry is NULL if there
+	// was no certificate, rolled up checks don't keep it
+	{name: "certificates", stmts: []string{`ALTER TABLE uptime_log
+    ADD COLUMN cert_expiry      TIMESTAMP,
+    ADD COLUMN cert_issuer      TEXT DEFAULT '' NOT NULL,
+    ADD COLUMN cert_sans        TEXT DEFAULT '' NOT NULL, -- comma separated
+    ADD COLUMN cert_chain_valid BOOLEAN DEFAULT false NOT NULL,
+    ADD COLUMN cert_host_valid  BOOLEAN DEFAULT false NOT NULL;
+
+CREATE OR REPLACE VIEW uptime_records AS
+    SELECT url, time, local_ip, up, status_code, response_time, dns_time, connect_time,
+        tls_time, ttfb, size, error, assertion,
+        cert_expiry, cert_issuer, cert_sans, cert_chain_valid, cert_host_valid
+    FROM uptime_log
+    UNION ALL
+    SELECT url, first_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', false, false
+    FROM uptime_hourly
+    UNION ALL
+    SELECT url, last_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', false, false
+    FROM uptime_hourly WHERE checks > 1`}},
+
+	// response content hash and content change flag with snippets around the
+	// first difference, content_change is empty if content didn't change
+	{name: "content", stmts: []string{`ALTER TABLE uptime_log
+    ADD COLUMN content_hash     TEXT DEFAULT '' NOT NULL,
+    ADD COLUMN content_change   TEXT DEFAULT '' NOT NULL,
+    ADD COLUMN content_previous TEXT DEFAULT '' NOT NULL,
+    ADD COLUMN content_before   TEXT DEFAULT '' NOT NULL,
+    ADD COLUMN content_after    TEXT DEFAULT '' NOT NULL;
+
+CREATE OR REPLACE VIEW uptime_records AS
+    SELECT url, time, local_ip, up, status_code, response_time, dns_time, connect_time,
+        tls_time, ttfb, size, error, assertion,
+        cert_expiry, cert_issuer, cert_sans, cert_chain_valid, cert_host_valid,
+        content_hash, content_change, content_previous, content_before, content_after
+    FROM uptime_log
+    UNION ALL
+    SELECT url, first_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', false, false, '', '', '', '', ''
+    FROM uptime_hourly
+    UNION ALL
+    SELECT url, last_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', false, false, '', '', '', '', ''
+    FROM uptime_hourly WHERE checks > 1`}},
+	{name: "confirmed", stmts: []string{`ALTER TABLE uptime_log ADD COLUMN confirmed BOOLEAN DEFAULT false NOT NULL;
+
+CREATE OR REPLACE VIEW uptime_records AS
+    SELECT url, time, local_ip, up, status_code, response_time, dns_time, connect_time,
+        tls_time, ttfb, size, error, assertion,
+        cert_expiry, cert_issuer, cert_sans, cert_chain_valid, cert_host_valid,
+        content_hash, content_change, content_previous, content_before, content_after,
+        confirmed
+    FROM uptime_log
+    UNION ALL
+    SELECT url, first_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', false, false, '', '', '', '', '', false
+    FROM uptime_hourly
+    UNION ALL
+    SELECT url, last_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', false, false, '', '', '', '', '', false
+    FROM uptime_hourly WHERE checks > 1`}},
+
+	// step records of multi-step targets have the target URL and step name,
+	// they're neither rolled up nor included in uptime_records
+	{name: "steps", stmts: []string{`ALTER TABLE uptime_log ADD COLUMN step TEXT DEFAULT '' NOT NULL;
+ALTER TABLE uptime_log DROP CONSTRAINT uptime_log_time_url_local_ip_key;
+ALTER TABLE uptime_log ADD CONSTRAINT uptime_log_time_url_local_ip_step_key UNIQUE (time, url, local_ip, step);
+
+CREATE OR REPLACE VIEW uptime_records AS
+    SELECT url, time, local_ip, up, status_code, response_time, dns_time, connect_time,
+        tls_time, ttfb, size, error, assertion,
+        cert_expiry, cert_issuer, cert_sans, cert_chain_valid, cert_host_valid,
+        content_hash, content_change, content_previous, content_before, content_after,
+        confirmed
+    FROM uptime_log WHERE step = ''
+    UNION ALL
+    SELECT url, first_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', false, false, '', '', '', '', '', false
+    FROM uptime_hourly
+    UNION ALL
+    SELECT url, last_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', false, false, '', '', '', '', '', false
+    FROM uptime_hourly WHERE checks > 1`}},
+}
+
+// migrationLock is an advisory lock key migrations are serialized with, so
+// components started at once don't migrate the same db concurrently
+const migrationLock = 0x637261776c6572 // "crawler"
+
+// queryer is either *sql.DB, *sql.Conn or *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// legacyVersion returns schema version of db created before schema_version
+// table was introduced, when crawler created its schema on start. Schema
+// with uptime_hourly table was created with retention support and matches
+// migrations up to "retention" one. Schema with only uptime_log table may be
+// anything from the initial one to the one with url index, migrations prior
+// to "retention" are idempotent, so they're reapplied.
+func legacyVersion(logged, rolled bool) int {
+	switch {
+	case rolled:
+		return 5
+	case logged:
+		return 1
+	}
+	return 0
+}
+
+// version returns schema version, 0 for the empty db, see legacyVersion for
+// databases without schema_version table
+func version(ctx context.Context, q queryer) (int, error) {
+	var versioned, logged, rolled bool
+	err := q.QueryRowContext(ctx, `SELECT to_regclass('schema_version') IS NOT NULL,
+		to_regclass('uptime_log') IS NOT NULL, to_regclass('uptime_hourly') IS NOT NULL`).
+		Scan(&versioned, &logged, &rolled)
+	if err != nil {
+		return 0, err
+	}
+
+	if !versioned {
+		return legacyVersion(logged, rolled), nil
+	}
+	var v int
+	err = q.QueryRowContext(ctx, `SELECT coalesce(max(version), 0) FROM schema_version`).Scan(&v)
+	return v, err
+}
+
+// Version returns db schema version
+func (d *DB) Version() (int, error) {
+	return version(context.Background(), d.conn)
+}
+
+// CheckVersion fails if db schema isn't migrated to the latest version
+func (d *DB) CheckVersion() error {
+	v, err := d.Version()
+	if err != nil {
+		return fmt.Errorf("Failed to get db schema version: %s", err)
+	}
+	if v < len(migrations) {
+		return fmt.Errorf("DB schema version %d is out of date, %d is required, run crawler migrate", v, len(migrations))
+	}
+	return nil
+}
+
+// Migrate applies pending migrations and returns schema versions before and
+// after migration. Every migration is applied in its own transaction, unless
+// it can't run in one, while the session advisory lock is held.
+func (d *DB) Migrate() (int, int, error) {
+	ctx := context.Background()
+	cur, err := version(ctx, d.conn)
+	if err != nil || cur >= len(migrations) {
+		return cur, cur, err
+	}
+
+	conn, err := d.conn.Conn(ctx)
+	if err != nil {
+		return cur, cur, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		return cur, cur, err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLock)
+
+	// db might have been migrated while the lock was awaited
+	if cur, err = version(ctx, conn); err != nil {
+		return cur, cur, err
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name    TEXT NOT NULL,
+		applied TIMESTAMP DEFAULT now() NOT NULL
+	)`)
+	if err != nil {
+		return cur, cur, err
+	}
+
+	for i := cur; i < len(migrations); i++ {
+		if err = apply(ctx, conn, i+1, &migrations[i]); err != nil {
+			return cur, i, fmt.Errorf("Failed to apply migration %d (%s): %s", i+1, migrations[i].name, err)
+		}
+	}
+	return cur, len(migrations), nil
+}
+
+// apply applies migration m and records schema version v
+func apply(ctx context.Context, conn *sql.Conn, v int, m *migration) error {
+	const record = `INSERT INTO schema_version (version, name) VALUES ($1, $2)`
+
+	if m.noTx {
+		for _, stmt := range m.stmts {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		_, err := conn.ExecContext(ctx, record, v, m.name)
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.stmts {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.ExecContext(ctx, record, v, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,7 +1,11 @@
 package pq
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/bpiddubnyi/crawler/db"
@@ -12,6 +16,7 @@ type DB struct {
 	conn *sql.DB
 }
 
+// New connects to db at uri, its schema isn't migrated, see Migrate
 func New(uri string, retries int) (*DB, error) {
 	var (
 		res = &DB{}
@@ -38,95 +43,157 @@ func New(uri string, retries int) (*DB, error) {
 	return res, nil
 }
 
-func (d *DB) Write(flushPeriod time.Duration, rC <-chan *db.Record, errC chan<- error) {
+// WriteBatch copies records to a staging table and moves them to uptime_log
+// in a single transaction. Records that are already saved, e.g. replayed
+// from spool, or duplicated within the batch are skipped.
+func (d *DB) WriteBatch(recs []*db.Record) error {
 	tx, err := d.conn.Begin()
 	if err != nil {
-		errC <- err
-		return
+		return err
 	}
+	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(pq.CopyIn("uptime_log", "url", "time", "local_ip", "up"))
+	_, err = tx.Exec(`CREATE TEMP TABLE uptime_log_staging (LIKE uptime_log INCLUDING DEFAULTS) ON COMMIT DROP`)
 	if err != nil {
-		errC <- err
-		return
+		return err
 	}
 
-	t := time.NewTicker(flushPeriod)
-	defer t.Stop()
+	stmt, err := tx.Prepare(pq.CopyIn("uptime_log_staging", db.WriteColumns...))
+	if err != nil {
+		return err
+	}
 
-theLoop:
-	for {
-		select {
-		case r, ok := <-rC:
-			if !ok {
-				break theLoop
-			}
-			_, err = stmt.Exec(r.URL, r.Time.UTC(), r.LocalIP, r.Up)
-			if err != nil {
-				stmt = nil
-				break theLoop
-			}
-		case <-t.C:
-			_, err = stmt.Exec()
-			if err != nil {
-				stmt.Close()
-				tx.Rollback()
-				break
-			}
-			err = tx.Commit()
-			if err != nil {
-				break theLoop
-			}
-
-			tx, err = d.conn.Begin()
-			if err != nil {
-				break theLoop
-			}
-
-			stmt, err = tx.Prepare(pq.CopyIn("uptime_log", "url", "time", "local_ip", "up"))
-			if err != nil {
-				break theLoop
-			}
+	for _, r := range recs {
+		var expiry interface{}
+		if r.Cert != nil {
+			expiry = r.Cert.Expiry.UTC()
+		}
+		_, err = stmt.Exec(db.WriteValues(r, r.Time.UTC(), expiry)...)
+		if err != nil {
+			stmt.Close()
+			return err
 		}
 	}
 
-	if stmt != nil {
-		stmt.Exec()
-		tx.Commit()
+	if _, err = stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err = stmt.Close(); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`INSERT INTO uptime_log (` + strings.Join(db.WriteColumns, ", ") + `)
+		SELECT ` + strings.Join(db.WriteColumns, ", ") + ` FROM uptime_log_staging ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n < int64(len(recs)) {
+		log.Printf("Warning: %d duplicate records skipped\n", int64(len(recs))-n)
 	}
+	return nil
+}
 
-	errC <- err
+// Permanent reports if err is a data exception or integrity constraint
+// violation, connection and server state errors are not permanent
+func (d *DB) Permanent(err error) bool {
+	e, ok := err.(*pq.Error)
+	if !ok {
+		return false
+	}
+	switch e.Code.Class() {
+	case "22", "23":
+		return true
+	}
+	return false
 }
 
-func (d *DB) GetRecords(from, to time.Time, url ...string) ([]db.Record, error) {
-	var (
-		rows *sql.Rows
-		err  error
-	)
+func (d *DB) Write(flushPeriod time.Duration, rC <-chan *db.Record, errC chan<- error) {
+	db.WriteBatches(d, flushPeriod, rC, errC)
+}
+
+// fetchSize is the number of rows fetched from cursor at once
+const fetchSize = 1000
+
+// scanRecord scans uptime_records row selected with db.Columns
+func scanRecord(rows *sql.Rows, r *db.Record) error {
+	var expiry pq.NullTime
+	c, err := db.ScanRecord(rows.Scan, r, &r.Time, &expiry)
+	if err != nil {
+		return err
+	}
+	if expiry.Valid {
+		c.Expiry = expiry.Time
+		r.Cert = c
+	}
+	return nil
+}
 
-	if len(url) > 0 {
-		rows, err = d.conn.Query(`SELECT url, time, local_ip, up FROM uptime_log
-			WHERE time >= $1 AND time <= $2 AND url=ANY($3)
-			ORDER BY url, local_ip, time`, from.UTC(), to.UTC(), pq.Array(url))
-	} else {
-		rows, err = d.conn.Query(`SELECT url, time, local_ip, up FROM uptime_log
-			WHERE time >= $1 AND time <= $2
-			ORDER BY url, local_ip, time`, from.UTC(), to.UTC())
+// StreamRecords reads raw and rolled up records through server-side cursor,
+// fetchSize rows at a time, so memory use doesn't depend on time range
+func (d *DB) StreamRecords(ctx context.Context, from, to time.Time, url []string, fn func(r *db.Record) error) error {
+	tx, err := d.conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
 	}
+	// nothing to commit, rollback closes the cursor as well
+	defer tx.Rollback()
 
+	cond, args := filter(from, to, url)
+	_, err = tx.ExecContext(ctx, `DECLARE uptime_log_cursor NO SCROLL CURSOR FOR
+		SELECT `+strings.Join(db.Columns, ", ")+` FROM uptime_records WHERE `+cond+`
+		ORDER BY url, local_ip, time`, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	res := []db.Record{}
-	for rows.Next() {
-		r := db.Record{}
-		err = rows.Scan(&r.URL, &r.Time, &r.LocalIP, &r.Up)
+	for {
+		n, err := fetch(ctx, tx, fn)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if n < fetchSize {
+			return nil
+		}
+	}
+}
+
+// fetch fetches next rows from the cursor and calls fn for each of them
+func fetch(ctx context.Context, tx *sql.Tx, fn func(r *db.Record) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`FETCH %d FROM uptime_log_cursor`, fetchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var r db.Record
+		if err = scanRecord(rows, &r); err != nil {
+			return n, err
+		}
+		if err = fn(&r); err != nil {
+			return n, err
 		}
-		res = append(res, r)
+		n++
 	}
+	return n, rows.Err()
+}
 
+// GetRecords returns records in [from, to] time range, ordered by url,
+// local_ip and time
+func (d *DB) GetRecords(from, to time.Time, url ...string) ([]db.Record, error) {
+	res := []db.Record{}
+	err := d.StreamRecords(context.Background(), from, to, url, func(r *db.Record) error {
+		res = append(res, *r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return res, nil
 }
@@ -0,0 +1,124 @@
+package pq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "22021"}, true},  // invalid byte sequence
+		{&pq.Error{Code: "23505"}, true},  // unique violation
+		{&pq.Error{Code: "08006"}, false}, // connection failure
+		{&pq.Error{Code: "57P01"}, false}, // admin shutdown
+		{fmt.Errorf("dial tcp: connection refused"), false},
+	}
+	d := &DB{}
+	for _, tt := range tests {
+		if got := d.Permanent(tt.err); got != tt.want {
+			t.Errorf("Permanent(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNoTxMigrations(t *testing.T) {
+	for i := range migrations {
+		m := &migrations[i]
+		if m.noTx != (m.name == "url index") {
+			t.Errorf("migration %s noTx = %t", m.name, m.noTx)
+		}
+		for _, stmt := range m.stmts {
+			if strings.Contains(stmt, "CONCURRENTLY") && (!m.noTx || strings.Contains(stmt, ";")) {
+				t.Errorf("migration %s builds index concurrently in a transaction or along with other statements", m.name)
+			}
+		}
+	}
+}
+
+func TestLegacyVersion(t *testing.T) {
+	tests := []struct {
+		logged, rolled bool
+		want           int
+	}{
+		{false, false, 0},
+		{true, false, 1},
+		{true, true, 5},
+	}
+	for _, tt := range tests {
+		if got := legacyVersion(tt.logged, tt.rolled); got != tt.want {
+			t.Errorf("legacyVersion(%t, %t) = %d, want %d", tt.logged, tt.rolled, got, tt.want)
+		}
+	}
+	// schema with uptime_hourly is the one "retention" migration creates
+	if migrations[4].name != "retention" {
+		t.Errorf("migration 5 is %s, want retention", migrations[4].name)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	d := testDB(t)
+	defer d.conn.Close()
+
+	if err := d.CheckVersion(); err != nil {
+		t.Fatalf("CheckVersion() of migrated db error = %s", err)
+	}
+
+	// pretend the last migration isn't applied
+	last := &migrations[len(migrations)-1]
+	if _, err := d.conn.Exec(`DELETE FROM schema_version WHERE version = $1`, len(migrations)); err != nil {
+		t.Fatalf("Failed to delete schema version: %s", err)
+	}
+	defer d.conn.Exec(`INSERT INTO schema_version (version, name) VALUES ($1, $2)`, len(migrations), last.name)
+
+	if err := d.CheckVersion(); err == nil {
+		t.Errorf("CheckVersion() of outdated db error = nil")
+	}
+}
+
+// TestVersionLegacy checks that databases created before schema_version
+// table was introduced are versioned by their tables
+func TestVersionLegacy(t *testing.T) {
+	d := testDB(t)
+	defer d.conn.Close()
+
+	ctx := context.Background()
+	conn, err := d.conn.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get connection: %s", err)
+	}
+	defer conn.Close()
+
+	// tables are created in a schema of their own, it's the only one on the
+	// connection search path
+	_, err = conn.ExecContext(ctx, `CREATE SCHEMA crawler_legacy; SET search_path TO crawler_legacy`)
+	if err != nil {
+		t.Fatalf("Failed to create schema: %s", err)
+	}
+	defer conn.ExecContext(ctx, `RESET search_path; DROP SCHEMA crawler_legacy CASCADE`)
+
+	tests := []struct {
+		create string
+		want   int
+	}{
+		{"", 0},
+		{`CREATE TABLE uptime_log (time TIMESTAMP NOT NULL, url TEXT NOT NULL)`, 1},
+		{`CREATE TABLE uptime_hourly (hour TIMESTAMP NOT NULL)`, 5},
+	}
+	for _, tt := range tests {
+		if len(tt.create) > 0 {
+			if _, err = conn.ExecContext(ctx, tt.create); err != nil {
+				t.Fatalf("Failed to create table: %s", err)
+			}
+		}
+		if v, err := version(ctx, conn); err != nil || v != tt.want {
+			t.Errorf("version() after %q = %d, %v, want %d", tt.create, v, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package pq
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+	"github.com/lib/pq"
+)
+
+const (
+	// partitionPrefix is a daily uptime_log partition name prefix, it's
+	// followed by the partition day in partitionDay format
+	partitionPrefix = "uptime_log_"
+	partitionDay    = "20060102"
+	// partitionsAhead is a number of days partitions are created in advance
+	partitionsAhead = 2
+	day             = 24 * time.Hour
+	// defaultPartition keeps rows no daily partition was created for
+	defaultPartition = "uptime_log_default"
+)
+
+const (
+	// errOverlap is an error code of partition overlapping the existing one
+	errOverlap = "42P17"
+	// errCheck is an error code of partition created for rows that are
+	// already in the default partition
+	errCheck = "23514"
+)
+
+// rollupQuery rolls raw checks up into a row per run of checks with the same
+// state within an hour, see intervalsQuery
+const rollupQuery = `WITH changes AS (
+	SELECT date_trunc('hour', time) AS hour, url, local_ip, time, up, status_code,
+		response_time, error, assertion,
+		CASE WHEN lag(up) OVER (PARTITION BY url, local_ip ORDER BY time) IS DISTINCT FROM up
+			THEN 1 ELSE 0 END AS change
+	FROM uptime_log WHERE time < $1 AND step = ''
+), runs AS (
+	SELECT *, sum(change) OVER (PARTITION BY url, local_ip ORDER BY time ROWS UNBOUNDED PRECEDING) AS run
+	FROM changes
+)
+INSERT INTO uptime_hourly (hour, url, local_ip, up, first_time, last_time, checks, status_code,
+	response_time, error, assertion)
+SELECT hour, url, local_ip, bool_and(up), min(time), max(time), count(*), max(status_code),
+	avg(response_time)::BIGINT, max(CASE WHEN up THEN '' ELSE error END),
+	max(CASE WHEN up THEN '' ELSE assertion END)
+FROM runs GROUP BY url, local_ip, run, hour`
+
+// Maintain creates daily uptime_log partitions in advance, if the table is
+// partitioned, rolls raw checks older than r.Raw up into uptime_hourly and
+// deletes rollups older than r.Rollup
+func (d *DB) Maintain(now time.Time, r db.Retention) error {
+	partitioned, err := d.partitioned()
+	if err != nil {
+		return fmt.Errorf("Failed to check uptime_log partitioning: %s", err)
+	}
+
+	if partitioned {
+		if err = d.createPartitions(now); err != nil {
+			return fmt.Errorf("Failed to create partitions: %s", err)
+		}
+	}
+
+	if r.Raw > 0 {
+		if err = d.rollup(now.Add(-r.Raw).Truncate(time.Hour), partitioned); err != nil {
+			return fmt.Errorf("Failed to roll up records: %s", err)
+		}
+	}
+
+	if r.Rollup > 0 {
+		_, err = d.conn.Exec(`DELETE FROM uptime_hourly WHERE hour < $1`, now.Add(-r.Rollup).UTC())
+		if err != nil {
+			return fmt.Errorf("Failed to delete expired rollups: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// partitioned checks if uptime_log is a partitioned table, it's not if the
+// db was created before partitioning was introduced and wasn't migrated
+func (d *DB) partitioned() (bool, error) {
+	var res bool
+	err := d.conn.QueryRow(`SELECT relkind = 'p' FROM pg_class WHERE oid = 'uptime_log'::regclass`).Scan(&res)
+	return res, err
+}
+
+// createPartitions creates partitions for the day of now and partitionsAhead
+// days after it. Days covered by the legacy partition are skipped.
+func (d *DB) createPartitions(now time.Time) error {
+	first := now.UTC().Truncate(day)
+	for i := 0; i <= partitionsAhead; i++ {
+		from := first.Add(time.Duration(i) * day)
+		_, err := d.conn.Exec(partitionQuery(from))
+		if e, ok := err.(*pq.Error); ok {
+			switch e.Code {
+			case errOverlap:
+				continue
+			case errCheck:
+				log.Printf("Warning: Records of %s are in the default partition, moving them to the new one\n",
+					from.Format("2006-01-02"))
+				err = d.movePartition(from)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partitionQuery returns query creating partition for the day starting at from
+func partitionQuery(from time.Time) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s%s PARTITION OF uptime_log
+			FOR VALUES FROM ('%s') TO ('%s')`, partitionPrefix, from.Format(partitionDay),
+		from.Format("2006-01-02"), from.Add(day).Format("2006-01-02"))
+}
+
+// movePartition creates partition for the day starting at from, rows of the
+// day written to the default partition before, e.g. while crawler wasn't
+// running, are moved to the new partition
+func (d *DB) movePartition(from time.Time) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`CREATE TEMP TABLE uptime_log_moved (LIKE uptime_log) ON COMMIT DROP`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`WITH moved AS (DELETE FROM `+defaultPartition+` WHERE time >= $1 AND time < $2 RETURNING *)
+		INSERT INTO uptime_log_moved SELECT * FROM moved`, from, from.Add(day))
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(partitionQuery(from)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`INSERT INTO uptime_log SELECT * FROM uptime_log_moved`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rollup rolls checks before cutoff up and deletes them, daily partitions
+// that are expired as a whole are dropped
+func (d *DB) rollup(cutoff time.Time, partitioned bool) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(rollupQuery, cutoff.UTC()); err != nil {
+		return err
+	}
+
+	if partitioned {
+		if err = dropPartitions(tx, cutoff); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec(`DELETE FROM uptime_log WHERE time < $1`, cutoff.UTC()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// dropPartitions drops daily partitions that end before cutoff
+func dropPartitions(tx *sql.Tx, cutoff time.Time) error {
+	rows, err := tx.Query(`SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'uptime_log'::regclass`)
+	if err != nil {
+		return err
+	}
+
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		// legacy and default partitions don't have a day in their names
+		from, perr := time.Parse(partitionDay, strings.TrimPrefix(name, partitionPrefix))
+		if perr == nil && !from.Add(day).After(cutoff) {
+			expired = append(expired, name)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range expired {
+		if _, err = tx.Exec(`DROP TABLE ` + pq.QuoteIdentifier(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
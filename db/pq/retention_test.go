@@ -0,0 +1,55 @@
+package pq
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+func TestCreatePartitionsDefaultRows(t *testing.T) {
+	d := testDB(t)
+	defer d.conn.Close()
+
+	if ok, err := d.partitioned(); err != nil || !ok {
+		t.Skipf("uptime_log is not partitioned: %v", err)
+	}
+
+	// records written while there was no partition for their day go to
+	// the default one
+	start := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= partitionsAhead; i++ {
+		defer d.conn.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s%s`, partitionPrefix,
+			start.Add(time.Duration(i)*day).Format(partitionDay)))
+	}
+	recs := []*db.Record{
+		{URL: "http://partition.test/", LocalIP: "10.0.0.1", Time: start.Add(time.Hour), Up: true},
+		{URL: "http://partition.test/", LocalIP: "10.0.0.1", Time: start.Add(2 * time.Hour), Up: true},
+	}
+	if err := d.WriteBatch(recs); err != nil {
+		t.Fatalf("WriteBatch() error = %s", err)
+	}
+
+	if err := d.createPartitions(start); err != nil {
+		t.Fatalf("createPartitions() error = %s", err)
+	}
+
+	var n int
+	err := d.conn.QueryRow(fmt.Sprintf(`SELECT count(*) FROM %s%s`, partitionPrefix,
+		start.Format(partitionDay))).Scan(&n)
+	if err != nil {
+		t.Fatalf("Failed to count partition rows: %s", err)
+	}
+	if n != len(recs) {
+		t.Errorf("partition has %d rows, want %d", n, len(recs))
+	}
+	err = d.conn.QueryRow(`SELECT count(*) FROM `+defaultPartition+` WHERE time >= $1 AND time < $2`,
+		start, start.Add(day)).Scan(&n)
+	if err != nil {
+		t.Fatalf("Failed to count default partition rows: %s", err)
+	}
+	if n != 0 {
+		t.Errorf("default partition has %d rows of the day, want 0", n)
+	}
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"log"
+	"time"
+)
+
+// Retention is a records retention policy
+type Retention struct {
+	Raw    time.Duration // age raw records are rolled up into hourly summaries at, 0 - never
+	Rollup time.Duration // age hourly summaries are deleted at, 0 - never
+}
+
+// Maintainer is a storage that needs periodic maintenance, e.g. creation of
+// time partitions or expiration of old records
+type Maintainer interface {
+	// Maintain prepares storage for records written around now and applies
+	// retention policy r. Raw records are rolled up by whole hours, only
+	// uptime intervals are kept exact, see db/pq/migrations.go.
+	Maintain(now time.Time, r Retention) error
+}
+
+// RunMaintenance maintains m every period until shutdownC is closed, errors
+// are logged
+func RunMaintenance(m Maintainer, r Retention, period time.Duration, shutdownC <-chan struct{}) {
+	t := time.NewTicker(period)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			if err := m.Maintain(now, r); err != nil {
+				log.Printf("Error: DB maintenance failed: %s\n", err)
+			}
+		case <-shutdownC:
+			return
+		}
+	}
+}
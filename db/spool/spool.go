@@ -0,0 +1,254 @@
+// Package spool implements durable local spool for records that can't be
+// written to the database
+package spool
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+	"github.com/bpiddubnyi/crawler/db/file"
+)
+
+const (
+	defaultMinBackoff  = time.Second
+	defaultMaxBackoff  = time.Minute
+	defaultReplayBatch = 1000
+)
+
+// Spool is a db.Writer that writes records to the underlying BatchWriter
+// every flush period. While the batch writer fails, records are appended to
+// the spool file instead, and writes are retried with exponential backoff.
+// Once the writer recovers, spooled records are replayed in the order they
+// were received and the spool file is removed. Records left in the spool on
+// exit are replayed on the next start.
+//
+// Records the batch writer permanently fails to write, as reported by
+// Permanent, are moved to the dead-letter file next to the spool instead.
+// Failing batches are split to find such records, so they don't block the
+// rest.
+type Spool struct {
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	ReplayBatch int              // number of spooled records written at once
+	Permanent   func(error) bool // reports if write error is caused by records, nil if every error is an outage
+
+	w        db.BatchWriter
+	path     string
+	f        *file.DB
+	deadPath string
+	dead     *file.DB
+	spooled  bool
+	backoff  time.Duration
+	retryAt  time.Time
+}
+
+// New creates spool for w, storing records at path while w is unavailable
+// and records w can't write at path.dead. If w is an ErrorClassifier, it's
+// used as Permanent.
+func New(w db.BatchWriter, path string) *Spool {
+	s := &Spool{
+		MinBackoff:  defaultMinBackoff,
+		MaxBackoff:  defaultMaxBackoff,
+		ReplayBatch: defaultReplayBatch,
+		w:           w,
+		path:        path,
+		f:           file.New(path, file.JSON),
+		deadPath:    path + ".dead",
+	}
+	s.dead = file.New(s.deadPath, file.JSON)
+	if c, ok := w.(db.ErrorClassifier); ok {
+		s.Permanent = c.Permanent
+	}
+	return s
+}
+
+// Write collects records from rC and flushes them every flushPeriod. It only
+// fails if records can't be written to the spool file.
+func (s *Spool) Write(flushPeriod time.Duration, rC <-chan *db.Record, errC chan<- error) {
+	if fi, err := os.Stat(s.path); err == nil && fi.Size() > 0 {
+		s.spooled = true
+		log.Printf("Info: Found %s spool, it will be replayed\n", s.path)
+	}
+
+	var (
+		batch []*db.Record
+		err   error
+		t     = time.NewTicker(flushPeriod)
+	)
+	defer t.Stop()
+
+theLoop:
+	for {
+		select {
+		case r, ok := <-rC:
+			if !ok {
+				break theLoop
+			}
+			batch = append(batch, r)
+		case <-t.C:
+			if err = s.flush(batch, false); err != nil {
+				errC <- err
+				return
+			}
+			batch = batch[:0]
+		}
+	}
+
+	err = s.flush(batch, true)
+	if err == nil && s.spooled {
+		log.Printf("Warning: Records are kept in %s spool until the next start\n", s.path)
+	}
+	errC <- err
+}
+
+// flush writes spooled records and batch to the batch writer, unless it's
+// backing off after failure. Batch is spooled if it can't be written. Final
+// flush doesn't respect backoff.
+func (s *Spool) flush(batch []*db.Record, final bool) error {
+	if !final && s.backoff > 0 && time.Now().Before(s.retryAt) {
+		return s.spool(batch)
+	}
+
+	if s.spooled {
+		if err := s.replay(); err != nil {
+			s.fail(err)
+			return s.spool(batch)
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := s.write(batch); err != nil {
+			s.fail(err)
+			return s.spool(batch)
+		}
+	}
+
+	if s.backoff > 0 {
+		log.Printf("Info: Database is available again\n")
+		s.backoff = 0
+	}
+	return nil
+}
+
+// write writes batch with the batch writer. If it fails permanently, the
+// batch is split in halves written separately, single failing records are
+// moved to the dead-letter file. Other errors are returned.
+func (s *Spool) write(batch []*db.Record) error {
+	err := s.w.WriteBatch(batch)
+	if err == nil || s.Permanent == nil || !s.Permanent(err) {
+		return err
+	}
+
+	if len(batch) > 1 {
+		half := len(batch) / 2
+		if err = s.write(batch[:half]); err != nil {
+			return err
+		}
+		return s.write(batch[half:])
+	}
+
+	r := batch[0]
+	log.Printf("Error: Failed to write %s record at %s, moving it to %s: %s\n", r.URL, r.Time, s.deadPath, err)
+	return s.dead.Append(batch)
+}
+
+// fail schedules next write attempt with exponential backoff
+func (s *Spool) fail(err error) {
+	if s.backoff == 0 {
+		log.Printf("Error: Failed to write records, spooling them to %s: %s\n", s.path, err)
+		s.backoff = s.MinBackoff
+	} else {
+		log.Printf("Error: Database is still unavailable: %s\n", err)
+		if s.backoff *= 2; s.backoff > s.MaxBackoff {
+			s.backoff = s.MaxBackoff
+		}
+	}
+	s.retryAt = time.Now().Add(s.backoff)
+}
+
+func (s *Spool) spool(batch []*db.Record) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := s.f.Append(batch); err != nil {
+		return err
+	}
+	s.spooled = true
+	return nil
+}
+
+// replay writes spooled records in chunks of ReplayBatch. If it fails
+// halfway, already written records are removed from the spool.
+func (s *Spool) replay() error {
+	var (
+		chunk   = make([]*db.Record, 0, s.ReplayBatch)
+		written int
+	)
+
+	err := s.f.Each(func(r *db.Record) error {
+		if chunk = append(chunk, r); len(chunk) < s.ReplayBatch {
+			return nil
+		}
+		if err := s.write(chunk); err != nil {
+			return err
+		}
+		written += len(chunk)
+		chunk = chunk[:0]
+		return nil
+	})
+	if err == nil && len(chunk) > 0 {
+		if err = s.write(chunk); err == nil {
+			written += len(chunk)
+		}
+	}
+
+	if err != nil {
+		if written > 0 {
+			if cerr := s.compact(written); cerr != nil {
+				log.Printf("Error: Failed to compact %s spool: %s\n", s.path, cerr)
+			}
+		}
+		return err
+	}
+
+	log.Printf("Info: Replayed %d spooled records\n", written)
+	s.spooled = false
+	return os.Remove(s.path)
+}
+
+// compact removes first n records from the spool
+func (s *Spool) compact(n int) error {
+	tmpPath := s.path + ".tmp"
+	os.Remove(tmpPath)
+
+	var (
+		tmp   = file.New(tmpPath, file.JSON)
+		chunk = make([]*db.Record, 0, s.ReplayBatch)
+		i     int
+	)
+	err := s.f.Each(func(r *db.Record) error {
+		if i++; i <= n {
+			return nil
+		}
+		if chunk = append(chunk, r); len(chunk) < s.ReplayBatch {
+			return nil
+		}
+		err := tmp.Append(chunk)
+		chunk = chunk[:0]
+		return err
+	})
+	if err == nil && len(chunk) > 0 {
+		err = tmp.Append(chunk)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if _, err = os.Stat(tmpPath); os.IsNotExist(err) {
+		return os.Remove(s.path)
+	}
+	return os.Rename(tmpPath, s.path)
+}
@@ -0,0 +1,173 @@
+package spool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+var errInvalid = fmt.Errorf("invalid record")
+
+// flakyWriter fails while down is set, and every failAfter-th batch.
+// Batches with invalid records always fail with errInvalid.
+type flakyWriter struct {
+	mu        sync.Mutex
+	down      bool
+	failAfter int
+	invalid   map[int64]bool // record times
+	batches   int
+	recs      []db.Record
+}
+
+func (w *flakyWriter) WriteBatch(recs []*db.Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.batches++
+	if w.down || (w.failAfter > 0 && w.batches%w.failAfter == 0) {
+		return fmt.Errorf("db is down")
+	}
+	for _, r := range recs {
+		if w.invalid[r.Time.Unix()] {
+			return errInvalid
+		}
+	}
+	for _, r := range recs {
+		w.recs = append(w.recs, *r)
+	}
+	return nil
+}
+
+func (w *flakyWriter) setDown(down bool) {
+	w.mu.Lock()
+	w.down = down
+	w.mu.Unlock()
+}
+
+func records(from, to int) []*db.Record {
+	var res []*db.Record
+	for i := from; i < to; i++ {
+		res = append(res, &db.Record{URL: fmt.Sprintf("http://%d.com", i), Time: time.Unix(int64(i), 0).UTC()})
+	}
+	return res
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		path = filepath.Join(dir, "spool.jsonl")
+		w    = &flakyWriter{down: true}
+		s    = New(w, path)
+	)
+	s.MinBackoff = time.Millisecond
+	s.MaxBackoff = 5 * time.Millisecond
+	s.ReplayBatch = 3
+
+	// db is down: everything goes to the spool
+	if err = s.flush(records(0, 5), false); err != nil {
+		t.Fatalf("flush() error = %s", err)
+	}
+	if err = s.flush(records(5, 10), false); err != nil {
+		t.Fatalf("flush() error = %s", err)
+	}
+	if len(w.recs) != 0 || !s.spooled {
+		t.Fatalf("records are written while db is down")
+	}
+
+	// db is back, but replay fails halfway
+	w.setDown(false)
+	w.failAfter = w.batches + 2
+	time.Sleep(s.MaxBackoff)
+	if err = s.flush(records(10, 12), false); err != nil {
+		t.Fatalf("flush() error = %s", err)
+	}
+	if len(w.recs) != 3 || !s.spooled {
+		t.Fatalf("got %d records written, want 3 with the rest spooled", len(w.recs))
+	}
+
+	// db is fully back: the rest of spool is replayed in order
+	w.failAfter = 0
+	rC := make(chan *db.Record, 3)
+	errC := make(chan error, 1)
+	for _, r := range records(12, 15) {
+		rC <- r
+	}
+	close(rC)
+	s.Write(time.Hour, rC, errC)
+	if err = <-errC; err != nil {
+		t.Fatalf("Write() error = %s", err)
+	}
+
+	if len(w.recs) != 15 {
+		t.Fatalf("got %d records written, want 15", len(w.recs))
+	}
+	for i, r := range w.recs {
+		if r.Time.Unix() != int64(i) {
+			t.Errorf("record %d is out of order: %v", i, r)
+		}
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("spool file is not removed after replay: %v", err)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		path = filepath.Join(dir, "spool.jsonl")
+		w    = &flakyWriter{down: true, invalid: map[int64]bool{2: true, 7: true}}
+		s    = New(w, path)
+	)
+	s.MinBackoff = time.Millisecond
+	s.ReplayBatch = 4
+	s.Permanent = func(err error) bool { return err == errInvalid }
+
+	// invalid records are spooled during outage and moved out on replay
+	if err = s.flush(records(0, 5), false); err != nil {
+		t.Fatalf("flush() error = %s", err)
+	}
+	w.setDown(false)
+	time.Sleep(s.MinBackoff)
+	if err = s.flush(records(5, 10), false); err != nil {
+		t.Fatalf("flush() error = %s", err)
+	}
+	if s.spooled || s.backoff > 0 {
+		t.Errorf("invalid records are considered outage")
+	}
+
+	var got []int64
+	for _, r := range w.recs {
+		got = append(got, r.Time.Unix())
+	}
+	if want := []int64{0, 1, 3, 4, 5, 6, 8, 9}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("written records %v, want %v", got, want)
+	}
+
+	var dead []int64
+	err = s.dead.Each(func(r *db.Record) error {
+		dead = append(dead, r.Time.Unix())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Each() error = %s", err)
+	}
+	if fmt.Sprint(dead) != "[2 7]" {
+		t.Errorf("dead-letter records %v, want [2 7]", dead)
+	}
+}
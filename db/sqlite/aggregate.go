@@ -0,0 +1,173 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// filter returns records filter condition and its arguments
+func filter(from, to time.Time, url []string) (string, []interface{}) {
+	cond := `time >= ? AND time <= ?`
+	args := []interface{}{from.UnixNano(), to.UnixNano()}
+	if len(url) > 0 {
+		cond += ` AND url IN (?` + strings.Repeat(", ?", len(url)-1) + `)`
+		for _, u := range url {
+			args = append(args, u)
+		}
+	}
+	return cond, args
+}
+
+// intervalsQuery splits every server checks into runs of the same state and
+// returns a row per run with the first check time of the next run
+const intervalsQuery = `WITH changes AS (
+	SELECT url, local_ip, time, up,
+		CASE WHEN lag(up) OVER (PARTITION BY url, local_ip ORDER BY time) IS NOT up
+			THEN 1 ELSE 0 END AS change
+	FROM uptime_records WHERE %s
+), runs AS (
+	SELECT url, local_ip, time, up,
+		sum(change) OVER (PARTITION BY url, local_ip ORDER BY time ROWS UNBOUNDED PRECEDING) AS run
+	FROM changes
+), grouped AS (
+	SELECT url, local_ip, run, min(up) AS up, min(time) AS first, max(time) AS last, count(*) AS n
+	FROM runs GROUP BY url, local_ip, run
+)
+SELECT url, local_ip, up, first, last, n,
+	lead(first) OVER (PARTITION BY url, local_ip ORDER BY run) AS next
+FROM grouped ORDER BY url, local_ip, run`
+
+// GetIntervals computes uptime/downtime intervals with window functions
+func (d *DB) GetIntervals(from, to time.Time, url ...string) ([]db.Interval, error) {
+	cond, args := filter(from, to, url)
+	rows, err := d.conn.Query(fmt.Sprintf(intervalsQuery, cond), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []db.Interval{}
+	for rows.Next() {
+		var (
+			iv          db.Interval
+			first, last int64
+			n           int
+			next        sql.NullInt64
+		)
+		if err = rows.Scan(&iv.URL, &iv.LocalIP, &iv.Up, &first, &last, &n, &next); err != nil {
+			return nil, err
+		}
+		iv.From = time.Unix(0, first).UTC()
+		switch {
+		case next.Valid:
+			iv.To = time.Unix(0, next.Int64).UTC()
+		case n > 1:
+			iv.To = time.Unix(0, last).UTC()
+		default:
+			continue
+		}
+		res = append(res, iv)
+	}
+
+	return res, rows.Err()
+}
+
+const failuresQuery = `SELECT url, local_ip, CASE WHEN assertion <> '' THEN assertion ELSE error END, count(*)
+FROM uptime_records WHERE NOT up AND (assertion <> '' OR error <> '') AND %s
+GROUP BY 1, 2, 3`
+
+// latencyQuery returns nearest-rank 50th, 90th and 99th response time
+// percentiles, there's no percentile aggregate in SQLite
+const latencyQuery = `SELECT url, local_ip, rank, n, response_time FROM (
+	SELECT url, local_ip, response_time,
+		row_number() OVER (PARTITION BY url, local_ip ORDER BY response_time) AS rank,
+		count(*) OVER (PARTITION BY url, local_ip) AS n
+	FROM uptime_records WHERE up AND error = '' AND %s
+) WHERE rank IN ((50 * n + 99) / 100, (90 * n + 99) / 100, (99 * n + 99) / 100)`
+
+// GetSummaries computes failure counts and latency percentiles
+func (d *DB) GetSummaries(from, to time.Time, url ...string) ([]db.Summary, error) {
+	var (
+		cond, args = filter(from, to, url)
+		sums       = map[[2]string]*db.Summary{}
+		res        []db.Summary
+	)
+	summary := func(url, localIP string) *db.Summary {
+		key := [2]string{url, localIP}
+		s, ok := sums[key]
+		if !ok {
+			s = &db.Summary{URL: url, LocalIP: localIP}
+			sums[key] = s
+		}
+		return s
+	}
+
+	err := query(d.conn, fmt.Sprintf(failuresQuery, cond), args, func(rows *sql.Rows) error {
+		var (
+			url, localIP, reason string
+			n                    int
+		)
+		if err := rows.Scan(&url, &localIP, &reason, &n); err != nil {
+			return err
+		}
+		s := summary(url, localIP)
+		if s.Failures == nil {
+			s.Failures = map[string]int{}
+		}
+		s.Failures[reason] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = query(d.conn, fmt.Sprintf(latencyQuery, cond), args, func(rows *sql.Rows) error {
+		var (
+			url, localIP string
+			rank, n, us  int64
+		)
+		if err := rows.Scan(&url, &localIP, &rank, &n, &us); err != nil {
+			return err
+		}
+		// the same row may be several percentiles at once if there are few checks
+		s, d := summary(url, localIP), time.Duration(us)*time.Microsecond
+		if rank == (50*n+99)/100 {
+			s.P50 = d
+		}
+		if rank == (90*n+99)/100 {
+			s.P90 = d
+		}
+		if rank == (99*n+99)/100 {
+			s.P99 = d
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range sums {
+		res = append(res, *s)
+	}
+	return res, nil
+}
+
+// query runs q and calls fn for every row
+func query(conn *sql.DB, q string, args []interface{}, fn func(rows *sql.Rows) error) error {
+	rows, err := conn.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,64 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+// rollupQuery rolls raw checks up into a row per run of checks with the same
+// state within an hour, see intervalsQuery
+const rollupQuery = `WITH changes AS (
+	SELECT time - time % 3600000000000 AS hour, url, local_ip, time, up, status_code,
+		response_time, error, assertion,
+		CASE WHEN lag(up) OVER (PARTITION BY url, local_ip ORDER BY time) IS NOT up
+			THEN 1 ELSE 0 END AS change
+	FROM uptime_log WHERE time < ? AND step = ''
+), runs AS (
+	SELECT *, sum(change) OVER (PARTITION BY url, local_ip ORDER BY time ROWS UNBOUNDED PRECEDING) AS run
+	FROM changes
+)
+INSERT INTO uptime_hourly (hour, url, local_ip, up, first_time, last_time, checks, status_code,
+	response_time, error, assertion)
+SELECT hour, url, local_ip, min(up), min(time), max(time), count(*), max(status_code),
+	CAST(avg(response_time) AS INTEGER), max(CASE WHEN up THEN '' ELSE error END),
+	max(CASE WHEN up THEN '' ELSE assertion END)
+FROM runs GROUP BY url, local_ip, run, hour`
+
+// Maintain rolls raw checks older than r.Raw up into uptime_hourly and
+// deletes rollups older than r.Rollup
+func (d *DB) Maintain(now time.Time, r db.Retention) error {
+	if r.Raw > 0 {
+		if err := d.rollup(now.Add(-r.Raw).Truncate(time.Hour)); err != nil {
+			return fmt.Errorf("Failed to roll up records: %s", err)
+		}
+	}
+
+	if r.Rollup > 0 {
+		_, err := d.conn.Exec(`DELETE FROM uptime_hourly WHERE hour < ?`, now.Add(-r.Rollup).UnixNano())
+		if err != nil {
+			return fmt.Errorf("Failed to delete expired rollups: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// rollup rolls checks before cutoff up and deletes them
+func (d *DB) rollup(cutoff time.Time) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(rollupQuery, cutoff.UnixNano()); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM uptime_log WHERE time < ?`, cutoff.UnixNano()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,297 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/db"
+	// sqlite3 database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS uptime_log (
+    time          INTEGER NOT NULL, -- unix nanoseconds
+    url           TEXT NOT NULL,
+    local_ip      TEXT NOT NULL,
+    up            BOOLEAN DEFAULT 0 NOT NULL,
+    status_code   INTEGER DEFAULT 0 NOT NULL,
+    response_time INTEGER DEFAULT 0 NOT NULL, -- microseconds
+    dns_time      INTEGER DEFAULT 0 NOT NULL, -- microseconds
+    connect_time  INTEGER DEFAULT 0 NOT NULL, -- microseconds
+    tls_time      INTEGER DEFAULT 0 NOT NULL, -- microseconds
+    ttfb          INTEGER DEFAULT 0 NOT NULL, -- microseconds
+    size          INTEGER DEFAULT 0 NOT NULL,
+    error         TEXT DEFAULT '' NOT NULL,
+    assertion     TEXT DEFAULT '' NOT NULL,
+    cert_expiry      INTEGER, -- unix nanoseconds, NULL if there was no certificate
+    cert_issuer      TEXT DEFAULT '' NOT NULL,
+    cert_sans        TEXT DEFAULT '' NOT NULL, -- comma separated
+    cert_chain_valid BOOLEAN DEFAULT 0 NOT NULL,
+    cert_host_valid  BOOLEAN DEFAULT 0 NOT NULL,
+    content_hash     TEXT DEFAULT '' NOT NULL,
+    content_change   TEXT DEFAULT '' NOT NULL, -- empty if content didn't change
+    content_previous TEXT DEFAULT '' NOT NULL,
+    content_before   TEXT DEFAULT '' NOT NULL,
+    content_after    TEXT DEFAULT '' NOT NULL,
+    confirmed        BOOLEAN DEFAULT 0 NOT NULL,
+    step             TEXT DEFAULT '' NOT NULL -- step name of multi-step target record
+);
+CREATE INDEX IF NOT EXISTS uptime_log_url_time ON uptime_log (url, local_ip, time);
+CREATE TABLE IF NOT EXISTS uptime_hourly (
+    hour          INTEGER NOT NULL, -- unix nanoseconds
+    url           TEXT NOT NULL,
+    local_ip      TEXT NOT NULL,
+    up            BOOLEAN NOT NULL,
+    first_time    INTEGER NOT NULL, -- unix nanoseconds
+    last_time     INTEGER NOT NULL, -- unix nanoseconds
+    checks        INTEGER NOT NULL,
+    status_code   INTEGER DEFAULT 0 NOT NULL,
+    response_time INTEGER DEFAULT 0 NOT NULL, -- average, microseconds
+    error         TEXT DEFAULT '' NOT NULL,
+    assertion     TEXT DEFAULT '' NOT NULL
+);
+CREATE INDEX IF NOT EXISTS uptime_hourly_url_time ON uptime_hourly (url, local_ip, first_time);`
+
+// uniqueIndex makes records unique by time, url, local_ip and step, replacing
+// the index that had no step. Databases created before it was introduced may
+// have duplicates, only the first saved one is kept.
+const uniqueIndex = `DROP INDEX IF EXISTS uptime_log_time_url_local_ip;
+DELETE FROM uptime_log WHERE rowid NOT IN (
+    SELECT min(rowid) FROM uptime_log GROUP BY time, url, local_ip, step);
+CREATE UNIQUE INDEX uptime_log_time_url_local_ip_step ON uptime_log (time, url, local_ip, step);`
+
+// view reads raw and rolled up records, it's recreated on start if stored
+// definition differs, so it matches uptime_log columns
+const view = `CREATE VIEW uptime_records AS
+    SELECT url, time, local_ip, up, status_code, response_time, dns_time, connect_time,
+        tls_time, ttfb, size, error, assertion,
+        cert_expiry, cert_issuer, cert_sans, cert_chain_valid, cert_host_valid,
+        content_hash, content_change, content_previous, content_before, content_after,
+        confirmed
+    FROM uptime_log WHERE step = ''
+    UNION ALL
+    SELECT url, first_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', 0, 0, '', '', '', '', '', 0
+    FROM uptime_hourly
+    UNION ALL
+    SELECT url, last_time, local_ip, up, status_code, response_time, 0, 0, 0, 0, 0, error, assertion,
+        NULL, '', '', 0, 0, '', '', '', '', '', 0
+    FROM uptime_hourly WHERE checks > 1`
+
+// addedColumns are uptime_log columns added after the table was introduced,
+// they're added to existing databases on start
+var addedColumns = [][2]string{
+	{"cert_expiry", "INTEGER"},
+	{"cert_issuer", "TEXT DEFAULT '' NOT NULL"},
+	{"cert_sans", "TEXT DEFAULT '' NOT NULL"},
+	{"cert_chain_valid", "BOOLEAN DEFAULT 0 NOT NULL"},
+	{"cert_host_valid", "BOOLEAN DEFAULT 0 NOT NULL"},
+	{"content_hash", "TEXT DEFAULT '' NOT NULL"},
+	{"content_change", "TEXT DEFAULT '' NOT NULL"},
+	{"content_previous", "TEXT DEFAULT '' NOT NULL"},
+	{"content_before", "TEXT DEFAULT '' NOT NULL"},
+	{"content_after", "TEXT DEFAULT '' NOT NULL"},
+	{"confirmed", "BOOLEAN DEFAULT 0 NOT NULL"},
+	{"step", "TEXT DEFAULT '' NOT NULL"},
+}
+
+// DB is an embedded SQLite storage backend
+type DB struct {
+	conn *sql.DB
+}
+
+// New opens SQLite database file at path, creating it and uptime_log table if
+// they don't exist
+func New(path string) (*DB, error) {
+	conn, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = conn.Exec(schema); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = addColumns(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to add uptime_log columns: %s", err)
+	}
+	if err = addUniqueIndex(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to create uptime_log unique index: %s", err)
+	}
+	if err = addView(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to create uptime_records view: %s", err)
+	}
+
+	return &DB{conn: conn}, nil
+}
+
+// addView creates uptime_records view, replacing the existing one unless it
+// has the same definition, so opening up-to-date database doesn't write to it
+func addView(conn *sql.DB) error {
+	var def string
+	err := conn.QueryRow(`SELECT sql FROM sqlite_master
+		WHERE type = 'view' AND name = 'uptime_records'`).Scan(&def)
+	if err == nil && def == view {
+		return nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DROP VIEW IF EXISTS uptime_records`); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(view); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addUniqueIndex removes duplicate records and creates unique index, unless
+// it already exists, see uniqueIndex
+func addUniqueIndex(conn *sql.DB) error {
+	var n int
+	err := conn.QueryRow(`SELECT count(*) FROM sqlite_master
+		WHERE type = 'index' AND name = 'uptime_log_time_url_local_ip_step'`).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(uniqueIndex); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addColumns adds addedColumns missing in uptime_log
+func addColumns(conn *sql.DB) error {
+	rows, err := conn.Query(`SELECT name FROM pragma_table_info('uptime_log')`)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range addedColumns {
+		if existing[c[0]] {
+			continue
+		}
+		if _, err = conn.Exec(`ALTER TABLE uptime_log ADD COLUMN ` + c[0] + ` ` + c[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBatch inserts records in a single transaction, records that are
+// already saved are skipped
+func (d *DB) WriteBatch(recs []*db.Record) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO uptime_log (` + strings.Join(db.WriteColumns, ", ") +
+		`) VALUES (?` + strings.Repeat(", ?", len(db.WriteColumns)-1) + `)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range recs {
+		var expiry interface{}
+		if r.Cert != nil {
+			expiry = r.Cert.Expiry.UnixNano()
+		}
+		_, err = stmt.Exec(db.WriteValues(r, r.Time.UnixNano(), expiry)...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Write inserts records from rC, committing them every flushPeriod
+func (d *DB) Write(flushPeriod time.Duration, rC <-chan *db.Record, errC chan<- error) {
+	db.WriteBatches(d, flushPeriod, rC, errC)
+}
+
+// StreamRecords calls fn for every raw or rolled up record in [from, to] time
+// range, ordered by url, local_ip and time, rows are read one by one
+func (d *DB) StreamRecords(ctx context.Context, from, to time.Time, url []string, fn func(r *db.Record) error) error {
+	cond, args := filter(from, to, url)
+	rows, err := d.conn.QueryContext(ctx, `SELECT `+strings.Join(db.Columns, ", ")+` FROM uptime_records WHERE `+cond+
+		` ORDER BY url, local_ip, time`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			r      = db.Record{}
+			ts     int64
+			expiry sql.NullInt64
+		)
+		c, err := db.ScanRecord(rows.Scan, &r, &ts, &expiry)
+		if err != nil {
+			return err
+		}
+		if expiry.Valid {
+			c.Expiry = time.Unix(0, expiry.Int64).UTC()
+			r.Cert = c
+		}
+		r.Time = time.Unix(0, ts).UTC()
+		if err = fn(&r); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetRecords returns records in [from, to] time range, ordered by url,
+// local_ip and time
+func (d *DB) GetRecords(from, to time.Time, url ...string) ([]db.Record, error) {
+	res := []db.Record{}
+	err := d.StreamRecords(context.Background(), from, to, url, func(r *db.Record) error {
+		res = append(res, *r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
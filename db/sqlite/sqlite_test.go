@@ -0,0 +1,293 @@
+package sqlite
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bpiddubnyi/crawler/cmd/crawler-stat/stat"
+	"github.com/bpiddubnyi/crawler/db"
+)
+
+func testDB(t *testing.T) (*DB, func()) {
+	dir, err := ioutil.TempDir("", "crawler-sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+	d, err := New(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("New() error = %s", err)
+	}
+	return d, func() {
+		d.conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestAggregator checks that intervals and summaries computed with SQL match
+// the ones aggregated in memory from raw records
+func TestAggregator(t *testing.T) {
+	d, cleanup := testDB(t)
+	defer cleanup()
+
+	var (
+		rnd   = rand.New(rand.NewSource(1))
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		recs  []*db.Record
+	)
+	for _, url := range []string{"http://a", "http://b", "http://c"} {
+		for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+			n, offset := 200, 0
+			if url == "http://c" {
+				n, offset = 1, 20 // single check, no intervals
+			}
+			for i := 0; i < n; i++ {
+				r := &db.Record{URL: url, LocalIP: ip, Time: start.Add(time.Duration(i+offset) * time.Minute),
+					Up: rnd.Intn(4) > 0, Duration: time.Duration(rnd.Intn(1000)) * time.Millisecond}
+				if !r.Up {
+					r.Error = []string{"dns", "timeout", "status"}[rnd.Intn(3)]
+					if r.Error == "status" {
+						r.Assertion = "status 503 not in 200-299"
+					}
+				}
+				recs = append(recs, r)
+			}
+		}
+	}
+	if err := d.WriteBatch(recs); err != nil {
+		t.Fatalf("WriteBatch() error = %s", err)
+	}
+
+	from, to := start.Add(10*time.Minute), start.Add(150*time.Minute)
+	for _, urls := range [][]string{nil, {"http://b", "http://c"}} {
+		raw, err := d.GetRecords(from, to, urls...)
+		if err != nil {
+			t.Fatalf("GetRecords() error = %s", err)
+		}
+		want := stat.Aggregate(raw)
+
+		tls, err := stat.FromAggregator(d, from, to, urls...)
+		if err != nil {
+			t.Fatalf("FromAggregator() error = %s", err)
+		}
+		var got []stat.Stat
+		for i := range tls {
+			got = append(got, tls[i].Stat())
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("urls %v: aggregated stats\n%+v\nwant\n%+v", urls, got, want)
+		}
+	}
+}
+
+// TestMaintain checks that rolling raw records up keeps uptime intervals
+func TestMaintain(t *testing.T) {
+	d, cleanup := testDB(t)
+	defer cleanup()
+
+	var (
+		rnd   = rand.New(rand.NewSource(1))
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		now   = start.Add(6 * time.Hour)
+		recs  []*db.Record
+	)
+	for _, url := range []string{"http://a", "http://b"} {
+		for i := 0; i < 360; i++ {
+			// long runs of the same state, crossing hour boundaries
+			up := (i/45)%2 == 0 || rnd.Intn(10) > 0
+			recs = append(recs, &db.Record{URL: url, LocalIP: "10.0.0.1", Up: up,
+				Time: start.Add(time.Duration(i)*time.Minute + time.Duration(rnd.Intn(30))*time.Second)})
+		}
+	}
+	if err := d.WriteBatch(recs); err != nil {
+		t.Fatalf("WriteBatch() error = %s", err)
+	}
+
+	intervals := func() []db.Interval {
+		ivs, err := d.GetIntervals(start, now)
+		if err != nil {
+			t.Fatalf("GetIntervals() error = %s", err)
+		}
+		return ivs
+	}
+	uptime := func() []stat.Stat {
+		raw, err := d.GetRecords(start, now)
+		if err != nil {
+			t.Fatalf("GetRecords() error = %s", err)
+		}
+		var res []stat.Stat
+		for _, s := range stat.Aggregate(raw) {
+			res = append(res, stat.Stat{URL: s.URL, LocalIP: s.LocalIP, WholeTime: s.WholeTime,
+				UpTime: s.UpTime, LongestDown: s.LongestDown, Incidents: s.Incidents})
+		}
+		return res
+	}
+	count := func(table string) int {
+		var n int
+		if err := d.conn.QueryRow(`SELECT count(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatalf("Failed to count %s rows: %s", table, err)
+		}
+		return n
+	}
+
+	wantIntervals, wantUptime := intervals(), uptime()
+
+	// 3.5 hours retention rolls up the first 2 whole hours
+	if err := d.Maintain(now, db.Retention{Raw: 210 * time.Minute}); err != nil {
+		t.Fatalf("Maintain() error = %s", err)
+	}
+	if n := count("uptime_log"); n != 2*240 {
+		t.Errorf("raw records left %d, want %d", n, 2*240)
+	}
+	if n := count("uptime_hourly"); n == 0 || n >= 2*120 {
+		t.Errorf("rollup rows %d, want (0, %d)", n, 2*120)
+	}
+	if got := intervals(); !reflect.DeepEqual(got, wantIntervals) {
+		t.Errorf("intervals after rollup\n%+v\nwant\n%+v", got, wantIntervals)
+	}
+	if got := uptime(); !reflect.DeepEqual(got, wantUptime) {
+		t.Errorf("uptime after rollup\n%+v\nwant\n%+v", got, wantUptime)
+	}
+
+	// rollups of the first hour are expired
+	if err := d.Maintain(now, db.Retention{Raw: 210 * time.Minute, Rollup: 5 * time.Hour}); err != nil {
+		t.Fatalf("Maintain() error = %s", err)
+	}
+	var first int64
+	if err := d.conn.QueryRow(`SELECT min(hour) FROM uptime_hourly`).Scan(&first); err != nil {
+		t.Fatalf("Failed to get the first rollup hour: %s", err)
+	}
+	if want := start.Add(time.Hour); !time.Unix(0, first).Equal(want) {
+		t.Errorf("first rollup hour %s, want %s", time.Unix(0, first).UTC(), want)
+	}
+}
+
+// TestWriteBatchDuplicates checks that the same URL checked at once from
+// several sources or by step is saved and duplicates don't fail the batch
+func TestWriteBatchDuplicates(t *testing.T) {
+	d, cleanup := testDB(t)
+	defer cleanup()
+
+	var (
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		a     = &db.Record{URL: "http://a", LocalIP: "10.0.0.1", Time: start, Up: true,
+			Cert: &db.Certificate{Expiry: start.AddDate(0, 1, 0), Issuer: "Test CA", SANs: []string{"a", "b"}, HostValid: true}}
+		b = &db.Record{URL: "http://a", LocalIP: "10.0.0.2", Time: start, Up: true}
+		c = &db.Record{URL: "http://a", LocalIP: "10.0.0.1", Time: start.Add(time.Minute), Error: "dns", Confirmed: true}
+		s = &db.Record{URL: "http://a", LocalIP: "10.0.0.1", Time: start, Up: true, Step: "login"}
+	)
+	if err := d.WriteBatch([]*db.Record{a, b, s}); err != nil {
+		t.Fatalf("WriteBatch() error = %s", err)
+	}
+	// a is replayed and c is duplicated within the batch
+	if err := d.WriteBatch([]*db.Record{a, c, c}); err != nil {
+		t.Fatalf("WriteBatch() error = %s", err)
+	}
+
+	got, err := d.GetRecords(start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetRecords() error = %s", err)
+	}
+	want := []db.Record{*a, *c, *b}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records\n%+v\nwant\n%+v", got, want)
+	}
+
+	// step record is saved, but it isn't read back
+	var n int
+	if err = d.conn.QueryRow(`SELECT count(*) FROM uptime_log WHERE step = 'login'`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("saved %d step records, %v, want 1", n, err)
+	}
+}
+
+// TestAddColumns checks that database created before certificates were
+// recorded and records were unique is upgraded on open
+func TestAddColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler-sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.db")
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open db: %s", err)
+	}
+	_, err = conn.Exec(`CREATE TABLE uptime_log (time INTEGER NOT NULL, url TEXT NOT NULL,
+		local_ip TEXT NOT NULL, up BOOLEAN DEFAULT 0 NOT NULL, status_code INTEGER DEFAULT 0 NOT NULL,
+		response_time INTEGER DEFAULT 0 NOT NULL, dns_time INTEGER DEFAULT 0 NOT NULL,
+		connect_time INTEGER DEFAULT 0 NOT NULL, tls_time INTEGER DEFAULT 0 NOT NULL,
+		ttfb INTEGER DEFAULT 0 NOT NULL, size INTEGER DEFAULT 0 NOT NULL,
+		error TEXT DEFAULT '' NOT NULL, assertion TEXT DEFAULT '' NOT NULL);
+		INSERT INTO uptime_log (time, url, local_ip, up) VALUES (0, 'http://a', '10.0.0.1', 1);
+		INSERT INTO uptime_log (time, url, local_ip, up) VALUES (0, 'http://a', '10.0.0.1', 0)`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to create old schema: %s", err)
+	}
+
+	d, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %s", err)
+	}
+	defer d.conn.Close()
+
+	got, err := d.GetRecords(time.Unix(0, 0), time.Unix(60, 0))
+	if err != nil {
+		t.Fatalf("GetRecords() error = %s", err)
+	}
+	if len(got) != 1 || got[0].Cert != nil || !got[0].Up {
+		t.Errorf("GetRecords() = %+v, want the first saved record without certificate", got)
+	}
+
+	// duplicates are skipped once the index is created
+	if err = d.WriteBatch([]*db.Record{{URL: "http://a", LocalIP: "10.0.0.1", Time: time.Unix(0, 0)}}); err != nil {
+		t.Fatalf("WriteBatch() error = %s", err)
+	}
+	if got, err = d.GetRecords(time.Unix(0, 0), time.Unix(60, 0)); err != nil || len(got) != 1 {
+		t.Errorf("GetRecords() = %+v, %v, want a single record", got, err)
+	}
+}
+
+// TestAddView checks that outdated view is replaced and up-to-date one is
+// left intact on open
+func TestAddView(t *testing.T) {
+	d, cleanup := testDB(t)
+	defer cleanup()
+
+	_, err := d.conn.Exec(`DROP VIEW uptime_records;
+		CREATE VIEW uptime_records AS SELECT url, time FROM uptime_log`)
+	if err != nil {
+		t.Fatalf("Failed to create old view: %s", err)
+	}
+	if err = addView(d.conn); err != nil {
+		t.Fatalf("addView() error = %s", err)
+	}
+	var def string
+	err = d.conn.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'uptime_records'`).Scan(&def)
+	if err != nil || def != view {
+		t.Fatalf("uptime_records = %q, %v, want current definition", def, err)
+	}
+
+	var before, after int
+	if err = d.conn.QueryRow(`PRAGMA schema_version`).Scan(&before); err != nil {
+		t.Fatalf("Failed to get schema version: %s", err)
+	}
+	if err = addView(d.conn); err != nil {
+		t.Fatalf("addView() error = %s", err)
+	}
+	if err = d.conn.QueryRow(`PRAGMA schema_version`).Scan(&after); err != nil {
+		t.Fatalf("Failed to get schema version: %s", err)
+	}
+	if after != before {
+		t.Errorf("schema version changed from %d to %d, want view left intact", before, after)
+	}
+}